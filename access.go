@@ -0,0 +1,79 @@
+package mcache
+
+import (
+	"math"
+	"sync/atomic"
+	"unsafe"
+
+	"github.com/larytet-go/hashtable"
+)
+
+// Recency policies need the time of the last access. The item in the
+// hashtable is 8 bytes and has no room for a timestamp
+// With Configuration.AccessTime the cache keeps the timestamps in a separate
+// array indexed by the sequence number of the eviction queue. The live
+// entries of the FIFO have consecutive sequence numbers, the low bits of
+// the heap handles are below the size of the heap. A power of 2 array keeps
+// a distinct slot for every entry
+
+// neverAccessed is the timestamp of an entry which was not loaded yet
+const neverAccessed = math.MinInt32
+
+// Meta is the metadata of an entry, see LoadWithMeta()
+type Meta struct {
+	// Time of the previous LoadWithMeta(), valid if Accessed is true
+	LastAccessMs TimeMs
+	Accessed     bool
+}
+
+type accessTimes struct {
+	times []int32
+	mask  uint32
+}
+
+func newAccessTimes(size int) *accessTimes {
+	size = hashtable.GetPower2(size)
+	a := &accessTimes{
+		times: make([]int32, size),
+		mask:  uint32(size - 1),
+	}
+	a.reset()
+	return a
+}
+
+func (a *accessTimes) reset() {
+	for i := range a.times {
+		a.times[i] = neverAccessed
+	}
+}
+
+// clear is called with the shard locked when the entry gets the sequence number
+func (a *accessTimes) clear(seq uint32) {
+	atomic.StoreInt32(&a.times[seq&a.mask], neverAccessed)
+}
+
+// move is called with the shard locked when the entry moves in the queue
+func (a *accessTimes) move(from uint32, to uint32) {
+	atomic.StoreInt32(&a.times[to&a.mask], atomic.LoadInt32(&a.times[from&a.mask]))
+}
+
+// LoadWithMeta performs lookup and updates the access time of the entry
+// Returns the time of the previous access. Requires Configuration.AccessTime
+// Load() does not update the access time
+func (c *Cache) LoadWithMeta(key uint64, now TimeMs) (o Object, meta Meta, ok bool) {
+	if c.isClosed() {
+		return 0, meta, false
+	}
+	hash := c.hash(key)
+	shard, _ := c.shardOf(hash)
+	c.rlock(shard)
+	iValue, ok, _ := shard.table.Load(key, hash)
+	i := *(*item)(unsafe.Pointer(&iValue))
+	if ok && c.access != nil {
+		// Eviction of the entry waits for the read lock
+		last := atomic.SwapInt32(&c.access.times[i.fifoSeq&c.access.mask], int32(now))
+		meta = Meta{LastAccessMs: TimeMs(last), Accessed: last != neverAccessed}
+	}
+	c.runlock(shard)
+	return i.o, meta, ok
+}
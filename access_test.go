@@ -0,0 +1,43 @@
+package mcache
+
+import (
+	"testing"
+)
+
+func TestLoadWithMeta(t *testing.T) {
+	cache := New(Configuration{Size: 3, TTL: 100, LoadFactor: 100, AccessTime: true})
+	cache.Store(1, 1, 0)
+	if o, meta, ok := cache.LoadWithMeta(1, 10); !ok || o != 1 || meta.Accessed {
+		t.Fatalf("LoadWithMeta returned %v %+v %v", o, meta, ok)
+	}
+	if _, meta, _ := cache.LoadWithMeta(1, 20); !meta.Accessed || meta.LastAccessMs != 10 {
+		t.Fatalf("Got meta %+v", meta)
+	}
+	// Overwrite clears the access time
+	cache.Store(1, 2, 30)
+	if _, meta, _ := cache.LoadWithMeta(1, 40); meta.Accessed {
+		t.Fatalf("Got meta %+v after overwrite", meta)
+	}
+	// The sequence numbers of the FIFO wrap around the array
+	for i := 0; i < 10; i++ {
+		cache.Evict(1000, true)
+		cache.Store(uint64(i+10), Object(i), 0)
+		if _, meta, ok := cache.LoadWithMeta(uint64(i+10), 1); !ok || meta.Accessed {
+			t.Fatalf("Got meta %+v for a new entry", meta)
+		}
+	}
+	// The entry with FlagNoForceEvict keeps the access time in the tail
+	cache.Reset()
+	cache.StoreWithFlags(1, 1, 0, FlagNoForceEvict)
+	cache.Store(2, 2, 0)
+	cache.LoadWithMeta(1, 5)
+	if o, ok := cache.Evict(10, true); !ok || o != 2 {
+		t.Fatalf("Evict returned %v %v", o, ok)
+	}
+	if _, meta, _ := cache.LoadWithMeta(1, 50); !meta.Accessed || meta.LastAccessMs != 5 {
+		t.Fatalf("Got meta %+v after the move", meta)
+	}
+	if _, meta, ok := New(Configuration{Size: 3, TTL: 100}).LoadWithMeta(1, 0); ok || meta.Accessed {
+		t.Fatalf("LoadWithMeta returned %+v %v", meta, ok)
+	}
+}
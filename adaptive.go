@@ -0,0 +1,139 @@
+package mcache
+
+import (
+	"sync/atomic"
+
+	"github.com/larytet-go/hashtable"
+)
+
+// AdaptiveTTL learns the TTL of the keys from the hits
+// An entry loaded close to the expiration would be hit again with a longer
+// TTL - the TTL of the key doubles. An entry which was never loaded wastes
+// the memory - the TTL of the key halves
+// The feedback comes from Evict() of the expired entries and from the access
+// times: the application reads with LoadWithMeta(), Load() is not a hit
+// The policy applies to TTL and TTLFunc, not to StoreWithTTL()
+type AdaptiveTTL struct {
+	// Bounds of the learned TTL
+	Min TimeMs
+	Max TimeMs
+	// A hit in the last NearExpiry percent of the TTL extends the TTL,
+	// 20 by default
+	NearExpiry int
+}
+
+// maxAdaptiveLevel limits the TTL of a key to 1/256..256 of the TTL
+const maxAdaptiveLevel = 8
+
+// AdaptiveTTLStatistics is the learned distribution, see GetAdaptiveTTL()
+type AdaptiveTTLStatistics struct {
+	// Levels[maxAdaptiveLevel+L] is the number of the slots where the TTL is
+	// multiplied by 2^L
+	Levels [2*maxAdaptiveLevel + 1]uint64
+	// Evictions which doubled or halved the TTL of a slot
+	Extended  uint64
+	Shortened uint64
+}
+
+// adaptiveTTL keeps a level for every slot. The keys share the slots like
+// the counters of a sketch, the level survives the eviction of the key
+type adaptiveTTL struct {
+	configuration AdaptiveTTL
+	levels        []int32
+	mask          uint64
+	extended      uint64
+	shortened     uint64
+}
+
+func newAdaptiveTTL(configuration AdaptiveTTL, size int) *adaptiveTTL {
+	if configuration.NearExpiry == 0 {
+		configuration.NearExpiry = 20
+	}
+	if configuration.Max == 0 || configuration.Max > maxTTL {
+		configuration.Max = maxTTL
+	}
+	size = hashtable.GetPower2(size)
+	return &adaptiveTTL{
+		configuration: configuration,
+		levels:        make([]int32, size),
+		mask:          uint64(size - 1),
+	}
+}
+
+func (a *adaptiveTTL) reset() {
+	for i := range a.levels {
+		a.levels[i] = 0
+	}
+	atomic.StoreUint64(&a.extended, 0)
+	atomic.StoreUint64(&a.shortened, 0)
+}
+
+// apply returns the TTL of the slot of the hash
+func (a *adaptiveTTL) apply(hash uint64, ttl TimeMs) TimeMs {
+	level := atomic.LoadInt32(&a.levels[hash&a.mask])
+	if level > 0 {
+		if ttl > a.configuration.Max>>uint(level) {
+			return a.configuration.Max
+		}
+		ttl <<= uint(level)
+	} else if level < 0 {
+		ttl >>= uint(-level)
+	}
+	if ttl < a.configuration.Min {
+		return a.configuration.Min
+	}
+	if ttl > a.configuration.Max {
+		return a.configuration.Max
+	}
+	return ttl
+}
+
+// add moves the level of the slot by delta within the limits
+func (a *adaptiveTTL) add(hash uint64, delta int32) bool {
+	slot := &a.levels[hash&a.mask]
+	for {
+		level := atomic.LoadInt32(slot)
+		next := level + delta
+		if next > maxAdaptiveLevel || next < -maxAdaptiveLevel {
+			return false
+		}
+		if atomic.CompareAndSwapInt32(slot, level, next) {
+			return true
+		}
+	}
+}
+
+// adaptiveFeedback is called with the shard locked when the entry expires
+// The TTL of the key is the TTL of the entry unless another key of the slot
+// moved the level
+func (c *Cache) adaptiveFeedback(key uint64, hash uint64, o Object, seq uint32, expirationMs int32) {
+	a := c.adaptive
+	ttl := c.ttl(key, o)
+	last := atomic.LoadInt32(&c.access.times[seq&c.access.mask])
+	if last == neverAccessed {
+		if ttl > a.configuration.Min && a.add(hash, -1) {
+			atomic.AddUint64(&a.shortened, 1)
+		}
+		return
+	}
+	nearExpiry := TimeMs(expirationMs-last) <= ttl*TimeMs(a.configuration.NearExpiry)/100
+	if nearExpiry && ttl < a.configuration.Max && a.add(hash, 1) {
+		atomic.AddUint64(&a.extended, 1)
+	}
+}
+
+// GetAdaptiveTTL returns the learned distribution of the TTL
+// Requires Configuration.AdaptiveTTL. I scan all slots
+func (c *Cache) GetAdaptiveTTL() AdaptiveTTLStatistics {
+	var s AdaptiveTTLStatistics
+	a := c.adaptive
+	if a == nil {
+		return s
+	}
+	for i := range a.levels {
+		s.Levels[maxAdaptiveLevel+atomic.LoadInt32(&a.levels[i])]++
+	}
+	s.Extended = atomic.LoadUint64(&a.extended)
+	s.Shortened = atomic.LoadUint64(&a.shortened)
+	return s
+}
@@ -0,0 +1,52 @@
+package mcache
+
+import (
+	"testing"
+)
+
+func TestAdaptiveTTL(t *testing.T) {
+	cache := New(Configuration{Size: 16, TTL: 100, LoadFactor: 100,
+		AdaptiveTTL: &AdaptiveTTL{Min: 25, Max: 400}})
+	now := TimeMs(0)
+	// A hit close to the expiration doubles the TTL
+	for _, expected := range []TimeMs{100, 200, 400, 400} {
+		cache.Store(1, 1, now)
+		if ttl := cache.ttl(1, 1); ttl != expected {
+			t.Fatalf("Got TTL %d instead of %d", ttl, expected)
+		}
+		cache.LoadWithMeta(1, now+expected-1)
+		now += expected
+		if _, ok := cache.Evict(now, false); !ok {
+			t.Fatalf("Failed to evict")
+		}
+	}
+	// An entry which is never loaded halves the TTL
+	for _, expected := range []TimeMs{100, 50, 25, 25} {
+		cache.Store(2, 2, now)
+		if ttl := cache.ttl(2, 2); ttl != expected {
+			t.Fatalf("Got TTL %d instead of %d", ttl, expected)
+		}
+		now += expected
+		cache.Evict(now, false)
+	}
+	// A hit in the middle of the TTL does not change the TTL
+	cache.Store(3, 3, now)
+	cache.LoadWithMeta(3, now+10)
+	now += 100
+	cache.Evict(now, false)
+	if ttl := cache.ttl(3, 3); ttl != 100 {
+		t.Fatalf("Got TTL %d instead of 100", ttl)
+	}
+
+	s := cache.GetAdaptiveTTL()
+	if s.Extended != 2 || s.Shortened != 2 {
+		t.Fatalf("Bad statistics %+v", s)
+	}
+	total := uint64(0)
+	for _, count := range s.Levels {
+		total += count
+	}
+	if s.Levels[maxAdaptiveLevel+2] != 1 || s.Levels[maxAdaptiveLevel-2] != 1 || total != uint64(len(cache.adaptive.levels)) {
+		t.Fatalf("Bad levels %v", s.Levels)
+	}
+}
@@ -0,0 +1,12 @@
+//go:build auditptr
+// +build auditptr
+
+package mcache
+
+// Build with -tags auditptr to panic at startup if a type which contains
+// pointers sneaks into the cache layouts
+func init() {
+	if err := auditPointers(); err != nil {
+		panic(err)
+	}
+}
@@ -0,0 +1,294 @@
+package mcache
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+
+	"github.com/larytet/mcachego/internal/fifo"
+)
+
+// Backend is the second level of a two level cache, for example, Redis
+// The cache is the hot tier: Store() writes through to the backend, Delete()
+// removes the key from both levels, LoadOrFetch() fetches the missing keys
+// Expiration and eviction do not touch the backend, the backend keeps its
+// own TTL
+// The objects of a cache with PoolTemplate are offsets in the pools, the
+// cache does not write them. StoreNew() does not write through
+type Backend interface {
+	Get(ctx context.Context, key uint64) (o Object, ttl TimeMs, ok bool, err error)
+	Set(ctx context.Context, key uint64, o Object, ttl TimeMs) error
+	Delete(ctx context.Context, key uint64) error
+}
+
+// backendOp is a pending write of the asynchronous mode
+type backendOp struct {
+	key    uint64
+	o      Object
+	ttl    TimeMs
+	delete bool
+}
+
+// backendWriter calls the backend in the Store() goroutine or, if
+// Configuration.BackendAsync is true, in a background goroutine
+// The counters are not sampled
+type backendWriter struct {
+	backend Backend
+	// nil in the synchronous mode
+	ops chan backendOp
+	// Protects the channel from Close()
+	mutex  sync.RWMutex
+	closed bool
+	done   chan struct{}
+
+	// Pending Backend.Get() calls, see fetch()
+	callsMutex sync.Mutex
+	calls      map[uint64]*fetchCall
+
+	getErrors     uint64
+	setErrors     uint64
+	deleteErrors  uint64
+	dropped       uint64
+	fetches       uint64
+	fetchesShared uint64
+	timeouts      uint64
+}
+
+func newBackendWriter(configuration Configuration) *backendWriter {
+	b := &backendWriter{
+		backend: configuration.Backend,
+		calls:   make(map[uint64]*fetchCall),
+	}
+	if configuration.BackendAsync {
+		size := configuration.BackendQueue
+		if size == 0 {
+			size = 1024
+		}
+		b.ops = make(chan backendOp, size)
+		b.done = make(chan struct{})
+		go b.run()
+	}
+	return b
+}
+
+// run writes the pending operations in order
+func (b *backendWriter) run() {
+	defer close(b.done)
+	for op := range b.ops {
+		b.write(context.Background(), op)
+	}
+}
+
+func (b *backendWriter) write(ctx context.Context, op backendOp) error {
+	if op.delete {
+		err := b.backend.Delete(ctx, op.key)
+		if err != nil {
+			b.failed(ctx, &b.deleteErrors)
+		}
+		return err
+	}
+	err := b.backend.Set(ctx, op.key, op.o, op.ttl)
+	if err != nil {
+		b.failed(ctx, &b.setErrors)
+	}
+	return err
+}
+
+// failed counts a failure of the backend call. A call which fails after
+// the deadline of the context is a timeout
+func (b *backendWriter) failed(ctx context.Context, counter *uint64) {
+	if ctx.Err() != nil {
+		atomic.AddUint64(&b.timeouts, 1)
+	} else {
+		atomic.AddUint64(counter, 1)
+	}
+}
+
+// send calls the backend or queues the operation. A full queue drops the
+// operation, Store() does not wait for a slow backend. If the context has
+// a deadline send() waits for the queue until the deadline
+func (b *backendWriter) send(ctx context.Context, op backendOp) (err error) {
+	if b.ops == nil {
+		return b.write(ctx, op)
+	}
+	b.mutex.RLock()
+	if !b.closed {
+		select {
+		case b.ops <- op:
+		default:
+			if ctx.Done() == nil {
+				atomic.AddUint64(&b.dropped, 1)
+				break
+			}
+			select {
+			case b.ops <- op:
+			case <-ctx.Done():
+				atomic.AddUint64(&b.timeouts, 1)
+				err = ctx.Err()
+			}
+		}
+	}
+	b.mutex.RUnlock()
+	return err
+}
+
+// close waits for the pending operations
+func (b *backendWriter) close() {
+	if b.ops == nil {
+		return
+	}
+	b.mutex.Lock()
+	b.closed = true
+	close(b.ops)
+	b.mutex.Unlock()
+	<-b.done
+}
+
+// writeThrough is called after a successful Store()
+// An object of the pool is an offset in the memory of this process, the
+// backend does not get it
+func (c *Cache) writeThrough(ctx context.Context, e fifo.Entry, o Object, now TimeMs) error {
+	if c.configuration.PoolTemplate != nil {
+		return nil
+	}
+	op := backendOp{key: e.Key, o: o, ttl: TimeMs(e.ExpirationMs) - now}
+	return c.backend.send(ctx, op)
+}
+
+// Delete removes the key from the cache and from the backend
+// Returns true if the cache had the key
+func (c *Cache) Delete(key uint64) bool {
+	ok := c.deleteKey(key)
+	if c.backend != nil && !c.isClosed() {
+		c.backend.send(context.Background(), backendOp{key: key, delete: true})
+	}
+	return ok
+}
+
+// fetchCall is a pending Backend.Get(). The goroutines which miss the same
+// key wait for the first one
+type fetchCall struct {
+	done chan struct{}
+	o    Object
+	ok   bool
+	err  error
+	// The context of the first goroutine expired, the waiters retry
+	canceled bool
+}
+
+// LoadOrFetch performs lookup and fetches the missing key from the backend
+// The fetched object goes through the admission of Store(): the rate
+// limiter and the capacity of the cache. LoadOrFetch() returns the object
+// even if the cache does not keep it
+// A popular key is fetched once: the concurrent misses of the key wait for
+// the first call of Backend.Get()
+// If the backend returns TTL zero the cache uses Configuration.TTL
+func (c *Cache) LoadOrFetch(key uint64, now TimeMs) (o Object, ok bool, err error) {
+	if o, _, ok := c.Load(key); ok {
+		return o, true, nil
+	}
+	if c.backend == nil || c.isClosed() {
+		return 0, false, nil
+	}
+	return c.fetch(context.Background(), key, now)
+}
+
+// LoadCtx is LoadOrFetch() which gives up at the deadline of the context
+// The goroutine which calls the backend passes the context to
+// Backend.Get(). A goroutine which waits for the call of another goroutine
+// returns the error of the context at its own deadline, a slow backend does
+// not accumulate the waiting goroutines
+func (c *Cache) LoadCtx(ctx context.Context, key uint64, now TimeMs) (o Object, ok bool, err error) {
+	if o, _, ok := c.Load(key); ok {
+		return o, true, nil
+	}
+	if c.backend == nil || c.isClosed() {
+		return 0, false, nil
+	}
+	if err := ctx.Err(); err != nil {
+		return 0, false, err
+	}
+	return c.fetch(ctx, key, now)
+}
+
+// StoreCtx is StoreE() which writes through to the backend with the context
+// Returns the error of the backend. In the asynchronous mode StoreCtx()
+// waits for the queue until the deadline of the context
+func (c *Cache) StoreCtx(ctx context.Context, key uint64, o Object, now TimeMs, flags Flags) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	r := storeRequest{
+		e:    fifo.Entry{Key: key, ExpirationMs: int32(now + c.ttl(key, o)), Flags: uint32(flags)},
+		o:    o,
+		now:  now,
+		mode: storeLimit | storeWriteThrough,
+	}
+	result, err := c.storeEntry(ctx, &r)
+	if result != storeOK {
+		return result.err()
+	}
+	return err
+}
+
+// fetch calls the backend or waits for the call of another goroutine
+// The error of the context belongs to the goroutine which called the
+// backend. The waiters with a live context call the backend again
+func (c *Cache) fetch(ctx context.Context, key uint64, now TimeMs) (Object, bool, error) {
+	b := c.backend
+	b.callsMutex.Lock()
+	for {
+		call, ok := b.calls[key]
+		if !ok {
+			break
+		}
+		b.callsMutex.Unlock()
+		atomic.AddUint64(&b.fetchesShared, 1)
+		select {
+		case <-call.done:
+			if !call.canceled {
+				return call.o, call.ok, call.err
+			}
+		case <-ctx.Done():
+			atomic.AddUint64(&b.timeouts, 1)
+			return 0, false, ctx.Err()
+		}
+		b.callsMutex.Lock()
+	}
+	call := &fetchCall{done: make(chan struct{})}
+	b.calls[key] = call
+	b.callsMutex.Unlock()
+
+	atomic.AddUint64(&b.fetches, 1)
+	o, ttl, ok, err := b.backend.Get(ctx, key)
+	if err != nil {
+		b.failed(ctx, &b.getErrors)
+		ok = false
+	}
+	if ok {
+		c.admitFetched(key, o, now, ttl)
+	}
+	call.o, call.ok, call.err = o, ok, err
+	call.canceled = err != nil && ctx.Err() != nil
+	b.callsMutex.Lock()
+	delete(b.calls, key)
+	b.callsMutex.Unlock()
+	close(call.done)
+	return o, ok, err
+}
+
+// admitFetched stores the object without writing it back to the backend
+func (c *Cache) admitFetched(key uint64, o Object, now TimeMs, ttl TimeMs) {
+	if ttl > 0 {
+		ttl = c.transformTTL(ttl)
+	} else {
+		ttl = c.ttl(key, o)
+	}
+	r := storeRequest{
+		e:    fifo.Entry{Key: key, ExpirationMs: int32(now + ttl)},
+		o:    o,
+		now:  now,
+		mode: storeLimit,
+	}
+	c.storeEntry(context.Background(), &r)
+}
@@ -0,0 +1,291 @@
+package mcache
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// mapBackend is a Backend in memory
+type mapBackend struct {
+	mutex   sync.Mutex
+	objects map[uint64]Object
+	ttls    map[uint64]TimeMs
+	err     error
+	gets    int
+}
+
+func newMapBackend() *mapBackend {
+	return &mapBackend{objects: make(map[uint64]Object), ttls: make(map[uint64]TimeMs)}
+}
+
+func (b *mapBackend) Get(ctx context.Context, key uint64) (Object, TimeMs, bool, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.gets++
+	o, ok := b.objects[key]
+	return o, b.ttls[key], ok, b.err
+}
+
+func (b *mapBackend) Set(ctx context.Context, key uint64, o Object, ttl TimeMs) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.err != nil {
+		return b.err
+	}
+	b.objects[key], b.ttls[key] = o, ttl
+	return nil
+}
+
+func (b *mapBackend) Delete(ctx context.Context, key uint64) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.err != nil {
+		return b.err
+	}
+	delete(b.objects, key)
+	return nil
+}
+
+func (b *mapBackend) load(key uint64) (Object, TimeMs, bool) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	o, ok := b.objects[key]
+	return o, b.ttls[key], ok
+}
+
+func TestBackendWriteThrough(t *testing.T) {
+	backend := newMapBackend()
+	cache := New(Configuration{Size: 4, TTL: 10, LoadFactor: 100, Backend: backend})
+	cache.Store(1, 1, 0)
+	if o, ttl, ok := backend.load(1); !ok || o != 1 || ttl != 10 {
+		t.Fatalf("Backend got %d %d %v", o, ttl, ok)
+	}
+	// Expiration does not reach the backend
+	cache.Evict(10, false)
+	if _, _, ok := backend.load(1); !ok {
+		t.Fatalf("Eviction removed the key from the backend")
+	}
+	cache.Store(2, 2, 0)
+	if !cache.Delete(2) {
+		t.Fatalf("Failed to delete")
+	}
+	if _, _, ok := backend.load(2); ok {
+		t.Fatalf("Delete did not reach the backend")
+	}
+	backend.err = errors.New("down")
+	cache.Store(3, 3, 0)
+	cache.Delete(3)
+	if s := cache.GetStatistics(); s.BackendSetErrors != 1 || s.BackendDeleteErrors != 1 {
+		t.Fatalf("Bad statistics %+v", s)
+	}
+}
+
+func TestBackendAsync(t *testing.T) {
+	backend := newMapBackend()
+	cache := New(Configuration{Size: 128, TTL: 10, Backend: backend, BackendAsync: true})
+	for i := 0; i < 100; i++ {
+		cache.Store(uint64(i), Object(i), 0)
+	}
+	cache.Delete(5)
+	if err := cache.Close(context.Background()); err != nil {
+		t.Fatalf("%v", err)
+	}
+	dropped := int(cache.GetStatistics().BackendDropped)
+	count := 0
+	for i := 0; i < 100; i++ {
+		if o, _, ok := backend.load(uint64(i)); ok {
+			if o != Object(i) {
+				t.Fatalf("Got %d instead of %d", o, i)
+			}
+			count++
+		}
+	}
+	if count+dropped != 99 && count+dropped != 100 {
+		t.Fatalf("Backend got %d keys, dropped %d", count, dropped)
+	}
+	if _, _, ok := backend.load(5); ok && dropped == 0 {
+		t.Fatalf("Delete did not reach the backend")
+	}
+}
+
+// blockingBackend holds Get() until release is closed
+type blockingBackend struct {
+	*mapBackend
+	release chan struct{}
+}
+
+func (b *blockingBackend) Get(ctx context.Context, key uint64) (Object, TimeMs, bool, error) {
+	<-b.release
+	return b.mapBackend.Get(ctx, key)
+}
+
+func TestLoadOrFetch(t *testing.T) {
+	backend := newMapBackend()
+	backend.Set(context.Background(), 1, 1, 50)
+	cache := New(Configuration{Size: 4, TTL: 10, LoadFactor: 100, Backend: backend})
+	if o, ok, err := cache.LoadOrFetch(1, 0); !ok || o != 1 || err != nil {
+		t.Fatalf("Got %d %v %v", o, ok, err)
+	}
+	// The fetched entry lives the TTL of the backend
+	if _, ok := cache.Evict(10, false); ok {
+		t.Fatalf("Evicted the fetched entry after TTL of the cache")
+	}
+	if _, _, ok := cache.Load(1); !ok {
+		t.Fatalf("Fetched key is not in the cache")
+	}
+	cache.LoadOrFetch(1, 0)
+	if _, ok, _ := cache.LoadOrFetch(2, 0); ok {
+		t.Fatalf("Fetched a missing key")
+	}
+	backend.err = errors.New("down")
+	if _, ok, err := cache.LoadOrFetch(3, 0); ok || err == nil {
+		t.Fatalf("Got %v %v", ok, err)
+	}
+	s := cache.GetStatistics()
+	if s.BackendFetches != 3 || s.BackendGetErrors != 1 || s.BackendSetErrors != 0 {
+		t.Fatalf("Bad statistics %+v", s)
+	}
+}
+
+func TestLoadOrFetchShared(t *testing.T) {
+	backend := &blockingBackend{mapBackend: newMapBackend(), release: make(chan struct{})}
+	backend.Set(context.Background(), 1, 1, 0)
+	cache := New(Configuration{Size: 4, TTL: 10, LoadFactor: 100, Backend: backend})
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if o, ok, _ := cache.LoadOrFetch(1, 0); !ok || o != 1 {
+				t.Errorf("Got %d %v", o, ok)
+			}
+		}()
+	}
+	// The first call holds the key, the rest wait for it
+	for s := cache.GetStatistics(); s.BackendFetches+s.BackendFetchesShared < 8; s = cache.GetStatistics() {
+		time.Sleep(time.Millisecond)
+	}
+	close(backend.release)
+	wg.Wait()
+	if s := cache.GetStatistics(); s.BackendFetches != 1 || s.BackendFetchesShared != 7 {
+		t.Fatalf("Bad statistics %+v", s)
+	}
+	if backend.gets != 1 {
+		t.Fatalf("Backend got %d calls", backend.gets)
+	}
+}
+
+// slowBackend returns the error of the context
+type slowBackend struct {
+	*mapBackend
+}
+
+func (b slowBackend) Get(ctx context.Context, key uint64) (Object, TimeMs, bool, error) {
+	<-ctx.Done()
+	return 0, 0, false, ctx.Err()
+}
+
+func (b slowBackend) Set(ctx context.Context, key uint64, o Object, ttl TimeMs) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestContext(t *testing.T) {
+	backend := slowBackend{newMapBackend()}
+	cache := New(Configuration{Size: 4, TTL: 10, LoadFactor: 100, Backend: backend})
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	if err := cache.StoreCtx(ctx, 1, 1, 0, 0); err != context.DeadlineExceeded {
+		t.Fatalf("Got %v instead of %v", err, context.DeadlineExceeded)
+	}
+	// The cache keeps the entry
+	if _, _, ok := cache.Load(1); !ok {
+		t.Fatalf("Failed to store")
+	}
+	if err := cache.StoreCtx(ctx, 2, 2, 0, 0); err != context.DeadlineExceeded {
+		t.Fatalf("Got %v instead of %v", err, context.DeadlineExceeded)
+	}
+	if _, _, ok := cache.Load(2); ok {
+		t.Fatalf("Stored after the deadline")
+	}
+	ctx, cancel = context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	if _, ok, err := cache.LoadCtx(ctx, 3, 0); ok || err != context.DeadlineExceeded {
+		t.Fatalf("Got %v %v", ok, err)
+	}
+	if o, ok, err := cache.LoadCtx(ctx, 1, 0); !ok || o != 1 || err != nil {
+		t.Fatalf("Got %d %v %v", o, ok, err)
+	}
+	s := cache.GetStatistics()
+	if s.BackendTimeouts != 2 || s.BackendSetErrors != 0 || s.BackendGetErrors != 0 {
+		t.Fatalf("Bad statistics %+v", s)
+	}
+}
+
+func TestLoadCtxShared(t *testing.T) {
+	backend := &blockingBackend{mapBackend: newMapBackend(), release: make(chan struct{})}
+	defer close(backend.release)
+	cache := New(Configuration{Size: 4, TTL: 10, LoadFactor: 100, Backend: backend})
+	go cache.LoadOrFetch(1, 0)
+	for cache.GetStatistics().BackendFetches == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	// The waiting goroutine does not wait for the slow call
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	if _, _, err := cache.LoadCtx(ctx, 1, 0); err != context.DeadlineExceeded {
+		t.Fatalf("Got %v instead of %v", err, context.DeadlineExceeded)
+	}
+	if s := cache.GetStatistics(); s.BackendFetchesShared != 1 || s.BackendTimeouts != 1 {
+		t.Fatalf("Bad statistics %+v", s)
+	}
+}
+
+// cancelBackend returns the error of the context in the first Get()
+type cancelBackend struct {
+	*mapBackend
+	calls int32
+}
+
+func (b *cancelBackend) Get(ctx context.Context, key uint64) (Object, TimeMs, bool, error) {
+	if atomic.AddInt32(&b.calls, 1) == 1 {
+		<-ctx.Done()
+		return 0, 0, false, ctx.Err()
+	}
+	return b.mapBackend.Get(ctx, key)
+}
+
+func TestLoadCtxRetry(t *testing.T) {
+	backend := &cancelBackend{mapBackend: newMapBackend()}
+	backend.Set(context.Background(), 1, 1, 0)
+	cache := New(Configuration{Size: 4, TTL: 10, LoadFactor: 100, Backend: backend})
+	ctx, cancel := context.WithCancel(context.Background())
+	errs := make(chan error, 1)
+	go func() {
+		_, _, err := cache.LoadCtx(ctx, 1, 0)
+		errs <- err
+	}()
+	for cache.GetStatistics().BackendFetches == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	// The waiter does not get the error of the first goroutine
+	go func() {
+		for cache.GetStatistics().BackendFetchesShared == 0 {
+			time.Sleep(time.Millisecond)
+		}
+		cancel()
+	}()
+	if o, ok, err := cache.LoadOrFetch(1, 0); !ok || o != 1 || err != nil {
+		t.Fatalf("Got %d %v %v", o, ok, err)
+	}
+	if err := <-errs; err != context.Canceled {
+		t.Fatalf("Got %v instead of %v", err, context.Canceled)
+	}
+	if s := cache.GetStatistics(); s.BackendFetches != 2 || s.BackendFetchesShared != 1 {
+		t.Fatalf("Bad statistics %+v", s)
+	}
+}
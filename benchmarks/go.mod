@@ -0,0 +1,13 @@
+module github.com/larytet/mcachego/benchmarks
+
+go 1.13
+
+// The module isolates the dependencies of the alternative caches from the
+// cache itself. Run "go mod tidy" to pull github.com/larytet/mcachego
+// The benchmarks run the published module and not this tree: the cache is
+// managed by dep (Gopkg.toml), the root has no go.mod to replace it with
+require (
+	github.com/allegro/bigcache v1.2.1
+	github.com/coocood/freecache v1.2.3
+	github.com/dgraph-io/ristretto v0.1.1
+)
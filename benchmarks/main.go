@@ -0,0 +1,301 @@
+// benchmarks runs the same Zipfian workload against mcachego and the popular
+// alternatives and prints a CSV: throughput, hit rate and GC pauses
+// Try
+//
+//	cd benchmarks && go mod tidy && go run . -keys 1000000 -zipf 1.1 -reads 90 > results.csv
+//
+// The keys and the read/write mix are generated in advance and are the same
+// for all caches. The workload is cache aside: every miss is followed by
+// a store. All caches are driven from a single goroutine
+package main
+
+import (
+	"encoding/binary"
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"math/rand"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/allegro/bigcache"
+	"github.com/coocood/freecache"
+	"github.com/dgraph-io/ristretto"
+	"github.com/larytet/mcachego"
+)
+
+type configuration struct {
+	keys   int
+	size   int
+	zipf   float64
+	reads  int
+	ttl    int
+	ops    int
+	seed   int64
+	caches string
+}
+
+// adapter hides the API differences between the caches
+// Values are 8 bytes for all caches
+type adapter interface {
+	load(key uint64) bool
+	store(key uint64)
+}
+
+type result struct {
+	name     string
+	loads    int
+	hits     int
+	stores   int
+	duration time.Duration
+	gcRuns   uint32
+	gcTotal  time.Duration
+	gcMax    time.Duration
+	heapMB   uint64
+}
+
+func parseFlags() configuration {
+	var c configuration
+	flag.IntVar(&c.keys, "keys", 1000*1000, "Number of distinct keys")
+	flag.IntVar(&c.size, "size", 0, "Cache size in entries, zero means 10% of the keys")
+	flag.Float64Var(&c.zipf, "zipf", 1.1, "Zipf skew s > 1, zero means uniform distribution")
+	flag.IntVar(&c.reads, "reads", 90, "Percent of reads")
+	flag.IntVar(&c.ttl, "ttl", 60*1000, "TTL ms")
+	flag.IntVar(&c.ops, "ops", 10*1000*1000, "Number of operations")
+	flag.Int64Var(&c.seed, "seed", 1, "Random seed")
+	flag.StringVar(&c.caches, "caches", "mcache,ristretto,bigcache,freecache", "Comma separated list of caches")
+	flag.Parse()
+	if c.size == 0 {
+		c.size = c.keys / 10
+	}
+	return c
+}
+
+type mcacheAdapter struct {
+	cache *mcache.Cache
+	now   mcache.TimeMs
+	count int
+}
+
+func (a *mcacheAdapter) load(key uint64) bool {
+	_, _, ok := a.cache.Load(key)
+	return ok
+}
+
+func (a *mcacheAdapter) store(key uint64) {
+	// GetTime() once in a while is enough
+	if a.count&0xff == 0 {
+		a.now = mcache.GetTime()
+	}
+	a.count++
+	a.cache.Evict(a.now, false)
+	if a.cache.Store(key, mcache.Object(key), a.now) {
+		return
+	}
+	// The cache is full. Force eviction and try again
+	a.cache.Evict(a.now, true)
+	a.cache.Store(key, mcache.Object(key), a.now)
+}
+
+type ristrettoAdapter struct {
+	cache *ristretto.Cache
+}
+
+func (a *ristrettoAdapter) load(key uint64) bool {
+	_, ok := a.cache.Get(key)
+	return ok
+}
+
+// Set() is asynchronous and the next Get() can miss the entry. Wait() makes
+// the hit rate comparable, the throughput includes the cost of Wait()
+func (a *ristrettoAdapter) store(key uint64) {
+	a.cache.Set(key, key, 1)
+	a.cache.Wait()
+}
+
+type bigcacheAdapter struct {
+	cache *bigcache.BigCache
+	key   [8]byte
+	value [8]byte
+}
+
+// bigcache API requires string keys. This is an allocation in every call
+func (a *bigcacheAdapter) load(key uint64) bool {
+	binary.LittleEndian.PutUint64(a.key[:], key)
+	_, err := a.cache.Get(string(a.key[:]))
+	return err == nil
+}
+
+func (a *bigcacheAdapter) store(key uint64) {
+	binary.LittleEndian.PutUint64(a.key[:], key)
+	binary.LittleEndian.PutUint64(a.value[:], key)
+	a.cache.Set(string(a.key[:]), a.value[:])
+}
+
+type freecacheAdapter struct {
+	cache *freecache.Cache
+	ttl   int
+	value [8]byte
+}
+
+func (a *freecacheAdapter) load(key uint64) bool {
+	_, err := a.cache.GetInt(int64(key))
+	return err == nil
+}
+
+func (a *freecacheAdapter) store(key uint64) {
+	binary.LittleEndian.PutUint64(a.value[:], key)
+	a.cache.SetInt(int64(key), a.value[:], a.ttl)
+}
+
+// entryOverhead is a rough estimate of the per entry memory in the byte
+// oriented caches: headers, key and value
+const entryOverhead = 64
+
+func newAdapter(name string, c configuration) (adapter, error) {
+	ttlSeconds := c.ttl / 1000
+	if ttlSeconds == 0 {
+		ttlSeconds = 1
+	}
+	switch name {
+	case "mcache":
+		cache := mcache.New(mcache.Configuration{Size: c.size, TTL: mcache.TimeMs(c.ttl)})
+		return &mcacheAdapter{cache: cache}, nil
+	case "ristretto":
+		cache, err := ristretto.NewCache(&ristretto.Config{
+			NumCounters: int64(10 * c.size),
+			MaxCost:     int64(c.size),
+			BufferItems: 64,
+		})
+		if err != nil {
+			return nil, err
+		}
+		return &ristrettoAdapter{cache: cache}, nil
+	case "bigcache":
+		config := bigcache.DefaultConfig(time.Duration(c.ttl) * time.Millisecond)
+		config.MaxEntriesInWindow = c.size
+		config.HardMaxCacheSize = (c.size*entryOverhead)/(1024*1024) + 1
+		config.Verbose = false
+		cache, err := bigcache.NewBigCache(config)
+		if err != nil {
+			return nil, err
+		}
+		return &bigcacheAdapter{cache: cache}, nil
+	case "freecache":
+		cache := freecache.NewCache(c.size * entryOverhead)
+		return &freecacheAdapter{cache: cache, ttl: ttlSeconds}, nil
+	}
+	return nil, fmt.Errorf("unknown cache %q", name)
+}
+
+// workload prepares the keys and the operations in advance. I do not want
+// to measure the random generator
+func workload(c configuration) ([]uint64, []bool) {
+	r := rand.New(rand.NewSource(c.seed))
+	keys := make([]uint64, c.ops)
+	if c.zipf > 1 {
+		z := rand.NewZipf(r, c.zipf, 1, uint64(c.keys-1))
+		for i := range keys {
+			keys[i] = z.Uint64()
+		}
+	} else {
+		for i := range keys {
+			keys[i] = uint64(r.Intn(c.keys))
+		}
+	}
+	reads := make([]bool, c.ops)
+	for i := range reads {
+		reads[i] = r.Intn(100) < c.reads
+	}
+	return keys, reads
+}
+
+func maxPause(before, after *runtime.MemStats) time.Duration {
+	var max uint64
+	runs := after.NumGC - before.NumGC
+	if runs > uint32(len(after.PauseNs)) {
+		runs = uint32(len(after.PauseNs))
+	}
+	for i := uint32(0); i < runs; i++ {
+		pause := after.PauseNs[(after.NumGC-i+255)%256]
+		if pause > max {
+			max = pause
+		}
+	}
+	return time.Duration(max)
+}
+
+func run(name string, a adapter, keys []uint64, reads []bool) result {
+	res := result{name: name}
+	runtime.GC()
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	start := time.Now()
+	for i, key := range keys {
+		if reads[i] {
+			res.loads++
+			if a.load(key) {
+				res.hits++
+				continue
+			}
+		}
+		res.stores++
+		a.store(key)
+	}
+	res.duration = time.Since(start)
+	runtime.ReadMemStats(&after)
+	res.gcRuns = after.NumGC - before.NumGC
+	res.gcTotal = time.Duration(after.PauseTotalNs - before.PauseTotalNs)
+	res.gcMax = maxPause(&before, &after)
+	res.heapMB = after.HeapAlloc / (1024 * 1024)
+	return res
+}
+
+func (r result) record(c configuration) []string {
+	ops := r.loads + r.stores
+	hitRate := 0.0
+	if r.loads > 0 {
+		hitRate = 100 * float64(r.hits) / float64(r.loads)
+	}
+	return []string{
+		r.name,
+		strconv.Itoa(c.keys),
+		strconv.Itoa(c.size),
+		strconv.FormatFloat(c.zipf, 'f', 2, 64),
+		strconv.Itoa(c.reads),
+		strconv.Itoa(ops),
+		strconv.FormatFloat(float64(ops)/r.duration.Seconds()/1e6, 'f', 3, 64),
+		strconv.FormatFloat(hitRate, 'f', 2, 64),
+		strconv.FormatUint(uint64(r.gcRuns), 10),
+		strconv.FormatInt(r.gcTotal.Microseconds(), 10),
+		strconv.FormatInt(r.gcMax.Microseconds(), 10),
+		strconv.FormatUint(r.heapMB, 10),
+	}
+}
+
+var header = []string{"cache", "keys", "size", "zipf", "reads", "ops",
+	"mops", "hit_rate", "gc_runs", "gc_pause_total_us", "gc_pause_max_us", "heap_mb"}
+
+func main() {
+	c := parseFlags()
+	keys, reads := workload(c)
+	w := csv.NewWriter(os.Stdout)
+	w.Write(header)
+	for _, name := range strings.Split(c.caches, ",") {
+		a, err := newAdapter(name, c)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		res := run(name, a, keys, reads)
+		w.Write(res.record(c))
+		w.Flush()
+	}
+	if err := w.Error(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,106 @@
+package mcache
+
+import (
+	"context"
+	"runtime/pprof"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Clock is GetTime() for the request handlers which call the cache several
+// times per request. A goroutine refreshes the time every 'resolution',
+// Now() is a load from memory and does not call nanotime()
+// The time returned by Now() is at most 'resolution' old. The TTL is in ms,
+// a resolution of a few hundreds of microseconds costs nothing in accuracy
+// The refresh is lazy. If nobody calls Now() for a 'resolution' the
+// goroutine stops the ticker and sleeps. The next Now() calls GetTime() and
+// wakes the goroutine up. An idle Clock does not wake up 10K times/s
+type Clock struct {
+	now int32
+	// Set by Now(), cleared by the goroutine every tick
+	used int32
+	// Set by the goroutine before it sleeps
+	idle       int32
+	resolution time.Duration
+	wake       chan struct{}
+	stop       chan struct{}
+	done       chan struct{}
+	once       sync.Once
+}
+
+// NewClock starts the goroutine. Call Stop() to release it
+func NewClock(resolution time.Duration) *Clock {
+	c := &Clock{
+		now:        int32(GetTime()),
+		resolution: resolution,
+		wake:       make(chan struct{}, 1),
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+	pprof.Do(context.Background(), pprof.Labels("mcache.worker", "clock"), func(context.Context) {
+		go c.run()
+	})
+	return c
+}
+
+func (c *Clock) run() {
+	defer close(c.done)
+	ticker := time.NewTicker(c.resolution)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if atomic.SwapInt32(&c.used, 0) == 0 && !c.sleep(ticker) {
+				return
+			}
+			atomic.StoreInt32(&c.now, int32(GetTime()))
+		case <-c.stop:
+			return
+		}
+	}
+}
+
+// sleep waits for Now() if Now() was not called since the last tick
+// Returns false if the Clock is stopped
+// Now() sets 'used' and then checks 'idle', I set 'idle' and then check
+// 'used'. One of us sees the flag of the other
+func (c *Clock) sleep(ticker *time.Ticker) bool {
+	atomic.StoreInt32(&c.idle, 1)
+	if atomic.LoadInt32(&c.used) == 0 {
+		ticker.Stop()
+		select {
+		case <-c.wake:
+		case <-c.stop:
+			return false
+		}
+		ticker.Reset(c.resolution)
+	}
+	atomic.StoreInt32(&c.idle, 0)
+	return true
+}
+
+// Now returns the cached time stamp
+// A busy handler finds 'used' set and does not write to the shared memory
+func (c *Clock) Now() TimeMs {
+	if atomic.LoadInt32(&c.used) == 0 {
+		atomic.StoreInt32(&c.used, 1)
+		if atomic.LoadInt32(&c.idle) != 0 {
+			// The goroutine sleeps and the time stamp is stale
+			atomic.StoreInt32(&c.now, int32(GetTime()))
+			select {
+			case c.wake <- struct{}{}:
+			default:
+			}
+		}
+	}
+	return TimeMs(atomic.LoadInt32(&c.now))
+}
+
+// Stop stops the goroutine. Now() returns the last time stamp after Stop()
+func (c *Clock) Stop() {
+	c.once.Do(func() {
+		close(c.stop)
+		<-c.done
+	})
+}
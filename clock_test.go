@@ -0,0 +1,52 @@
+package mcache
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestClock(t *testing.T) {
+	clock := NewClock(100 * time.Microsecond)
+	defer clock.Stop()
+	start := clock.Now()
+	if d := GetTime() - start; d < 0 || d > 10 {
+		t.Fatalf("Clock is %dms behind", d)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if d := clock.Now() - start; d < 10 {
+		t.Fatalf("Clock advanced %dms instead of 20ms", d)
+	}
+	clock.Stop()
+	clock.Stop()
+}
+
+func TestClockIdle(t *testing.T) {
+	clock := NewClock(time.Millisecond)
+	defer clock.Stop()
+	// The goroutine sleeps after a tick without Now()
+	for i := 0; i < 100 && atomic.LoadInt32(&clock.idle) == 0; i++ {
+		time.Sleep(time.Millisecond)
+	}
+	if atomic.LoadInt32(&clock.idle) == 0 {
+		t.Fatalf("The clock does not sleep")
+	}
+	time.Sleep(20 * time.Millisecond)
+	if d := GetTime() - clock.Now(); d < 0 || d > 10 {
+		t.Fatalf("Clock is %dms behind", d)
+	}
+	// The goroutine woke up and can sleep again
+	start := clock.Now()
+	time.Sleep(20 * time.Millisecond)
+	if d := clock.Now() - start; d < 10 {
+		t.Fatalf("Clock advanced %dms instead of 20ms", d)
+	}
+}
+
+func BenchmarkClockNow(b *testing.B) {
+	clock := NewClock(time.Millisecond)
+	defer clock.Stop()
+	for i := 0; i < b.N; i++ {
+		clock.Now()
+	}
+}
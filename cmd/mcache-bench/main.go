@@ -0,0 +1,235 @@
+// mcache-bench runs a synthetic workload against the cache and prints
+// throughput, hit rate, latency percentiles and GC statistics
+// Try
+//
+//	go run ./cmd/mcache-bench -keys 1000000 -zipf 1.1 -reads 90 -ops 10000000
+//
+// The cache is driven from a single goroutine by default. The eviction FIFO
+// is shared by all shards and is protected by a mutex. Try -goroutines to
+// measure the contended path
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math/rand"
+	"os"
+	"runtime"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/larytet/mcachego"
+)
+
+type configuration struct {
+	keys     int
+	size     int
+	zipf     float64
+	reads    int
+	ttl      int
+	shards   int
+	policy   string
+	ops      int
+	sampling int
+	seed     int64
+	// Goroutines calling the cache, every goroutine runs a part of the keys
+	goroutines int
+}
+
+type results struct {
+	loads     int
+	hits      int
+	stores    int
+	storeFail int
+	evictions int
+	duration  time.Duration
+	latencies []time.Duration
+}
+
+func parseFlags() configuration {
+	var c configuration
+	flag.IntVar(&c.keys, "keys", 1000*1000, "Number of distinct keys")
+	flag.IntVar(&c.size, "size", 0, "Cache size, zero means number of keys")
+	flag.Float64Var(&c.zipf, "zipf", 1.1, "Zipf skew s > 1, zero means uniform distribution")
+	flag.IntVar(&c.reads, "reads", 90, "Percent of reads")
+	flag.IntVar(&c.ttl, "ttl", 1000, "TTL ms")
+	flag.IntVar(&c.shards, "shards", 0, "Number of shards, zero means 2*NumCPU")
+	flag.StringVar(&c.policy, "policy", "fifo", "Eviction order: fifo or heap")
+	flag.IntVar(&c.ops, "ops", 10*1000*1000, "Number of operations")
+	flag.IntVar(&c.sampling, "sampling", 64, "Measure latency of every Nth operation")
+	flag.Int64Var(&c.seed, "seed", 1, "Random seed")
+	flag.IntVar(&c.goroutines, "goroutines", 1, "Number of goroutines calling the cache")
+	flag.Parse()
+	if c.size == 0 {
+		c.size = c.keys
+	}
+	if c.sampling <= 0 {
+		c.sampling = 1
+	}
+	if c.goroutines <= 0 {
+		c.goroutines = 1
+	}
+	return c
+}
+
+func newCache(c configuration) (*mcache.Cache, error) {
+	var expiryIndex mcache.ExpiryIndex
+	switch c.policy {
+	case "fifo":
+		expiryIndex = mcache.ExpiryIndexFIFO
+	case "heap":
+		expiryIndex = mcache.ExpiryIndexHeap
+	default:
+		return nil, fmt.Errorf("unknown policy %q", c.policy)
+	}
+	return mcache.New(mcache.Configuration{
+		Size:        c.size,
+		Shards:      c.shards,
+		TTL:         mcache.TimeMs(c.ttl),
+		ExpiryIndex: expiryIndex,
+	}), nil
+}
+
+// keys prepares the keys in advance. I do not want to measure the
+// random generator
+func keys(c configuration) []uint64 {
+	r := rand.New(rand.NewSource(c.seed))
+	keys := make([]uint64, c.ops)
+	if c.zipf > 1 {
+		z := rand.NewZipf(r, c.zipf, 1, uint64(c.keys-1))
+		for i := range keys {
+			keys[i] = z.Uint64()
+		}
+	} else {
+		for i := range keys {
+			keys[i] = uint64(r.Intn(c.keys))
+		}
+	}
+	return keys
+}
+
+func store(cache *mcache.Cache, res *results, key uint64, now mcache.TimeMs) {
+	res.stores++
+	if _, expired := cache.Evict(now, false); expired {
+		res.evictions++
+	}
+	if cache.Store(key, mcache.Object(key), now) {
+		return
+	}
+	// The cache is full. Force eviction and try again
+	if _, expired := cache.Evict(now, true); expired {
+		res.evictions++
+	}
+	if !cache.Store(key, mcache.Object(key), now) {
+		res.storeFail++
+	}
+}
+
+func run(c configuration, cache *mcache.Cache, keys []uint64) results {
+	r := rand.New(rand.NewSource(c.seed + 1))
+	reads := make([]bool, c.ops)
+	for i := range reads {
+		reads[i] = r.Intn(100) < c.reads
+	}
+	parts := make([]results, c.goroutines)
+	var wg sync.WaitGroup
+	start := time.Now()
+	for g := range parts {
+		from, to := g*len(keys)/c.goroutines, (g+1)*len(keys)/c.goroutines
+		wg.Add(1)
+		go func(res *results, keys []uint64, reads []bool) {
+			defer wg.Done()
+			*res = worker(c, cache, keys, reads)
+		}(&parts[g], keys[from:to], reads[from:to])
+	}
+	wg.Wait()
+	res := results{duration: time.Since(start)}
+	for _, part := range parts {
+		res.loads += part.loads
+		res.hits += part.hits
+		res.stores += part.stores
+		res.storeFail += part.storeFail
+		res.evictions += part.evictions
+		res.latencies = append(res.latencies, part.latencies...)
+	}
+	return res
+}
+
+// worker runs the keys in a goroutine
+func worker(c configuration, cache *mcache.Cache, keys []uint64, reads []bool) results {
+	res := results{latencies: make([]time.Duration, 0, len(keys)/c.sampling+1)}
+	now := mcache.GetTime()
+	for i, key := range keys {
+		// GetTime() once in a while is enough
+		if i&0xff == 0 {
+			now = mcache.GetTime()
+		}
+		var opStart time.Time
+		sampled := (i % c.sampling) == 0
+		if sampled {
+			opStart = time.Now()
+		}
+		if reads[i] {
+			res.loads++
+			if _, _, ok := cache.Load(key); ok {
+				res.hits++
+			} else {
+				// Cache aside: store after a miss
+				store(cache, &res, key, now)
+			}
+		} else {
+			store(cache, &res, key, now)
+		}
+		if sampled {
+			res.latencies = append(res.latencies, time.Since(opStart))
+		}
+	}
+	return res
+}
+
+func percentile(latencies []time.Duration, p float64) time.Duration {
+	if len(latencies) == 0 {
+		return 0
+	}
+	idx := int(float64(len(latencies)-1) * p / 100)
+	return latencies[idx]
+}
+
+func report(c configuration, res results, before, after runtime.MemStats) {
+	sort.Slice(res.latencies, func(i, j int) bool { return res.latencies[i] < res.latencies[j] })
+	ops := res.loads + (res.stores - (res.loads - res.hits))
+	hitRate := 0.0
+	if res.loads > 0 {
+		hitRate = 100 * float64(res.hits) / float64(res.loads)
+	}
+	fmt.Printf("keys=%d size=%d zipf=%.2f reads=%d%% ttl=%dms shards=%d policy=%s goroutines=%d\n",
+		c.keys, c.size, c.zipf, c.reads, c.ttl, c.shards, c.policy, c.goroutines)
+	fmt.Printf("ops=%d duration=%v throughput=%.2fM ops/s\n",
+		ops, res.duration, float64(ops)/res.duration.Seconds()/1e6)
+	fmt.Printf("loads=%d hits=%d hit rate=%.2f%% stores=%d store failures=%d evictions=%d\n",
+		res.loads, res.hits, hitRate, res.stores, res.storeFail, res.evictions)
+	fmt.Printf("latency p50=%v p90=%v p99=%v p99.9=%v max=%v\n",
+		percentile(res.latencies, 50), percentile(res.latencies, 90),
+		percentile(res.latencies, 99), percentile(res.latencies, 99.9),
+		percentile(res.latencies, 100))
+	fmt.Printf("GC runs=%d pause total=%v heap=%dMB\n",
+		after.NumGC-before.NumGC, time.Duration(after.PauseTotalNs-before.PauseTotalNs),
+		after.HeapAlloc/(1024*1024))
+}
+
+func main() {
+	c := parseFlags()
+	cache, err := newCache(c)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	keys := keys(c)
+	runtime.GC()
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	res := run(c, cache, keys)
+	runtime.ReadMemStats(&after)
+	report(c, res, before, after)
+}
@@ -0,0 +1,133 @@
+// Package cmsketch is a count-min sketch for frequency estimation
+// See https://en.wikipedia.org/wiki/Count%E2%80%93min_sketch
+// A key touches 4 counters. All 4 counters are in the same 64 bytes block - a
+// single cache line. 8 bits counters saturate at 255
+// The sketch ages: after every 'agingPeriod' additions all counters are halved,
+// so the estimation follows the recent popularity of the keys
+// The sketch is not thread safe
+package cmsketch
+
+import (
+	"github.com/larytet-go/hashtable"
+)
+
+const (
+	// Counters in a block - 64 bytes cache line
+	blockCounters = 64
+	// Counters a key touches, one in every row of the block
+	depth = 4
+	// Counters in a row of the block
+	rowCounters = blockCounters / depth
+	// 8 counters in a 64 bits word
+	wordCounters = 8
+	maxCounter   = 0xff
+	// Halving mask, the shifted bits of one counter do not leak to
+	// the next counter
+	halfMask = 0x7f7f7f7f7f7f7f7f
+)
+
+// Sketch keeps the counters
+type Sketch struct {
+	words       []uint64
+	blocksMask  uint64
+	additions   int
+	agingPeriod int
+}
+
+// New creates a sketch with at least 'counters' counters
+// The number of counters is rounded up to a power of 2, at least 64
+// If 'agingPeriod' is zero the counters are halved after 10*counters
+// additions
+func New(counters int, agingPeriod int) *Sketch {
+	if counters < blockCounters {
+		counters = blockCounters
+	}
+	counters = hashtable.GetPower2(counters)
+	if agingPeriod <= 0 {
+		agingPeriod = 10 * counters
+	}
+	blocks := counters / blockCounters
+	return &Sketch{
+		words:       make([]uint64, counters/wordCounters),
+		blocksMask:  uint64(blocks) - 1,
+		agingPeriod: agingPeriod,
+	}
+}
+
+// indexes returns the counters of the hash
+// The block comes from the high bits, the counters in the block from
+// the low 16 bits of the hash
+func (s *Sketch) indexes(hash uint64) (idx [depth]uint64) {
+	block := ((hash >> 32) & s.blocksMask) * blockCounters
+	for i := uint64(0); i < depth; i++ {
+		idx[i] = block + i*rowCounters + ((hash >> (4 * i)) & (rowCounters - 1))
+	}
+	return idx
+}
+
+func (s *Sketch) get(idx uint64) uint64 {
+	return (s.words[idx/wordCounters] >> ((idx % wordCounters) * 8)) & maxCounter
+}
+
+func (s *Sketch) inc(idx uint64) {
+	s.words[idx/wordCounters] += 1 << ((idx % wordCounters) * 8)
+}
+
+// Add increments the frequency of the hash
+// The hash is expected to be random in all bits, see keyhash package
+// Conservative update: I increment only the counters equal to the minimum.
+// This reduces the overestimation for the rare keys
+func (s *Sketch) Add(hash uint64) {
+	idx := s.indexes(hash)
+	min := uint64(maxCounter)
+	for _, i := range idx {
+		if c := s.get(i); c < min {
+			min = c
+		}
+	}
+	if min < maxCounter {
+		for _, i := range idx {
+			if s.get(i) == min {
+				s.inc(i)
+			}
+		}
+	}
+	s.additions++
+	if s.additions >= s.agingPeriod {
+		s.Age()
+	}
+}
+
+// Estimate returns the estimated frequency of the hash
+func (s *Sketch) Estimate(hash uint64) int {
+	idx := s.indexes(hash)
+	min := uint64(maxCounter)
+	for _, i := range idx {
+		if c := s.get(i); c < min {
+			min = c
+		}
+	}
+	return int(min)
+}
+
+// Age halves all counters
+// Add() calls Age() periodically. The application can call Age() explicitly
+func (s *Sketch) Age() {
+	for i, w := range s.words {
+		s.words[i] = (w >> 1) & halfMask
+	}
+	s.additions = 0
+}
+
+// Reset sets all counters to zero
+func (s *Sketch) Reset() {
+	for i := range s.words {
+		s.words[i] = 0
+	}
+	s.additions = 0
+}
+
+// Size returns number of counters
+func (s *Sketch) Size() int {
+	return len(s.words) * wordCounters
+}
@@ -0,0 +1,72 @@
+package cmsketch
+
+import (
+	"testing"
+
+	"github.com/larytet/mcachego/keyhash"
+)
+
+func TestEstimate(t *testing.T) {
+	s := New(1024, 1000*1000)
+	hot := keyhash.Uint64(1)
+	for i := 0; i < 100; i++ {
+		s.Add(hot)
+	}
+	for i := 2; i < 200; i++ {
+		s.Add(keyhash.Uint64(uint64(i)))
+	}
+	if e := s.Estimate(hot); e < 100 || e > 110 {
+		t.Fatalf("Estimated %d instead of 100", e)
+	}
+	if e := s.Estimate(keyhash.Uint64(1000)); e > 5 {
+		t.Fatalf("Estimated %d for a missing key", e)
+	}
+}
+
+func TestSaturation(t *testing.T) {
+	s := New(64, 1000*1000)
+	for i := 0; i < 1000; i++ {
+		s.Add(0)
+	}
+	if e := s.Estimate(0); e != maxCounter {
+		t.Fatalf("Estimated %d instead of %d", e, maxCounter)
+	}
+}
+
+func TestAging(t *testing.T) {
+	s := New(64, 10)
+	for i := 0; i < 9; i++ {
+		s.Add(0)
+	}
+	if e := s.Estimate(0); e != 9 {
+		t.Fatalf("Estimated %d instead of 9", e)
+	}
+	s.Add(0)
+	if e := s.Estimate(0); e != 5 {
+		t.Fatalf("Estimated %d instead of 5 after aging", e)
+	}
+	s.Reset()
+	if e := s.Estimate(0); e != 0 {
+		t.Fatalf("Estimated %d after reset", e)
+	}
+}
+
+func TestSize(t *testing.T) {
+	if s := New(100, 0); s.Size() != 128 {
+		t.Fatalf("Got size %d instead of 128", s.Size())
+	}
+}
+
+func BenchmarkAdd(b *testing.B) {
+	s := New(1024*1024, 0)
+	for i := 0; i < b.N; i++ {
+		s.Add(keyhash.Uint64(uint64(i)))
+	}
+}
+
+func BenchmarkEstimate(b *testing.B) {
+	s := New(1024*1024, 0)
+	for i := 0; i < b.N; i++ {
+		s.Estimate(keyhash.Uint64(uint64(i)))
+	}
+}
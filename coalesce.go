@@ -0,0 +1,36 @@
+package mcache
+
+import (
+	"unsafe"
+
+	"github.com/larytet/mcachego/internal/fifo"
+)
+
+// coalesce returns true if the table keeps the key with the same expiration
+// time and flags - another goroutine stored the key in the same millisecond
+// A coordinated miss ends with many goroutines storing the same key. The
+// first Store() wins, the rest find the entry and do not touch the queue,
+// the table and the log. The table keeps the object of the first Store(),
+// StoreE() returns ErrCoalesced to the rest
+// The table is the "recent insert" index: the shard has no room for more
+// fields, the queue entry keeps the expiration time
+// Returns the number of entries in the queue. Called with the shard locked
+func (c *Cache) coalesce(shard *shard, hash uint64, e fifo.Entry) (int, bool) {
+	iValue, ok, _ := shard.table.Load(e.Key, hash)
+	if !ok {
+		return 0, false
+	}
+	i := *(*item)(unsafe.Pointer(&iValue))
+	c.lockQueue()
+	existing, ok := c.queue.Get(i.fifoSeq)
+	if ok && c.configuration.IntrinsicItem {
+		existing = c.getHeader(shard, i.o, e.Key)
+	}
+	ok = ok && existing.ExpirationMs == e.ExpirationMs && existing.Flags == e.Flags
+	if ok {
+		c.storeCoalesced++
+	}
+	count := c.queue.Len()
+	c.unlockQueue()
+	return count, ok
+}
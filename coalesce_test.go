@@ -0,0 +1,66 @@
+package mcache
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestCoalesceStores(t *testing.T) {
+	cache := New(Configuration{Size: 4, TTL: 10, LoadFactor: 100, CoalesceStores: true})
+	if !cache.Store(1, 1, 0) {
+		t.Fatalf("Failed to store")
+	}
+	// The cache does not keep the object
+	if cache.Store(1, 2, 0) {
+		t.Fatalf("Coalesced Store returned true")
+	}
+	if o, _, ok := cache.Load(1); !ok || o != 1 {
+		t.Fatalf("Got %d instead of the first object", o)
+	}
+	if cache.Len() != 1 {
+		t.Fatalf("Got Len %d instead of 1", cache.Len())
+	}
+	// The application frees the object
+	if err := cache.StoreE(1, 5, 0, 0); err != ErrCoalesced {
+		t.Fatalf("Got %v instead of %v", err, ErrCoalesced)
+	}
+	// Another millisecond, Store() replaces the object
+	cache.Store(1, 3, 1)
+	if o, _, _ := cache.Load(1); o != 3 {
+		t.Fatalf("Got %d instead of 3", o)
+	}
+	// Other flags
+	cache.StoreWithFlags(1, 4, 1, FlagNoForceEvict)
+	if o, _, _ := cache.Load(1); o != 4 {
+		t.Fatalf("Got %d instead of 4", o)
+	}
+	if s := cache.GetStatistics(); s.StoreCoalesced != 2 {
+		t.Fatalf("Got StoreCoalesced %d instead of 2", s.StoreCoalesced)
+	}
+}
+
+func TestCoalesceConcurrent(t *testing.T) {
+	cache := New(Configuration{Size: 4, TTL: 10, LoadFactor: 100, CoalesceStores: true})
+	var wg sync.WaitGroup
+	stored := int32(0)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if cache.Store(1, Object(i), 0) {
+				atomic.AddInt32(&stored, 1)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if stored != 1 {
+		t.Fatalf("Store returned true %d times", stored)
+	}
+	if cache.Len() != 1 {
+		t.Fatalf("Got Len %d instead of 1", cache.Len())
+	}
+	if s := cache.GetStatistics(); s.StoreCoalesced != 7 {
+		t.Fatalf("Got StoreCoalesced %d instead of 7", s.StoreCoalesced)
+	}
+}
@@ -0,0 +1,165 @@
+package mcache
+
+import (
+	"fmt"
+	"reflect"
+	"runtime"
+	"runtime/metrics"
+	"time"
+
+	"github.com/larytet/mcachego/internal/fifo"
+	"github.com/larytet/mcachego/internal/minheap"
+)
+
+// GCStatistics is a result of a forced GC
+type GCStatistics struct {
+	// Wall time of runtime.GC()
+	Duration time.Duration
+	// Stop the world pause of the forced GC
+	Pause time.Duration
+	// Process wide heap which the GC scans - objects containing pointers
+	HeapScanBytes uint64
+	// Process wide live heap after the GC
+	HeapLiveBytes uint64
+	// Memory of the tables, the pools, the queue and the side arrays of the
+	// cache. The maps are estimated by the number of entries
+	CacheBytes uint64
+	// The part of CacheBytes which the GC scans, should be a few KB
+	CacheScanBytes uint64
+}
+
+// MeasureGC forces GC and returns the GC costs
+// The cache keeps the data in large pointer free arrays. The GC marks the
+// arrays, but never scans them. Call MeasureGC() before and after filling
+// the cache: HeapLiveBytes grows, HeapScanBytes should not
+// The heap statistics are process wide. I walk the slices of the cache for
+// CacheBytes and CacheScanBytes
+// This API stops the world. Do not call it in production
+func (c *Cache) MeasureGC() GCStatistics {
+	start := time.Now()
+	runtime.GC()
+	duration := time.Since(start)
+
+	var memStats runtime.MemStats
+	runtime.ReadMemStats(&memStats)
+	samples := []metrics.Sample{
+		{Name: "/gc/scan/heap:bytes"},
+		{Name: "/gc/heap/live:bytes"},
+	}
+	metrics.Read(samples)
+	s := GCStatistics{
+		Duration: duration,
+		Pause:    time.Duration(memStats.PauseNs[(memStats.NumGC+255)%256]),
+	}
+	if samples[0].Value.Kind() == metrics.KindUint64 {
+		s.HeapScanBytes = samples[0].Value.Uint64()
+	}
+	if samples[1].Value.Kind() == metrics.KindUint64 {
+		s.HeapLiveBytes = samples[1].Value.Uint64()
+	}
+	c.footprint(&s)
+	return s
+}
+
+// footprint adds the memory of the cache structures to the statistics
+func (c *Cache) footprint(s *GCStatistics) {
+	visited := make(map[uintptr]bool)
+	roots := []interface{}{c.shards, c.queue, c.stale, c.access, c.fingerprints, c.ttls,
+		c.adaptive, c.reverse}
+	for _, root := range roots {
+		footprint(reflect.ValueOf(root), visited, s)
+	}
+}
+
+// footprint follows the pointers and the fields of the structures and adds
+// the slices and the maps to the statistics. I look into the elements of
+// a slice only if the elements contain pointers, the large arrays do not
+func footprint(v reflect.Value, visited map[uintptr]bool, s *GCStatistics) {
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() || visited[v.Pointer()] {
+			return
+		}
+		visited[v.Pointer()] = true
+		footprint(v.Elem(), visited, s)
+	case reflect.Interface:
+		if !v.IsNil() {
+			footprint(v.Elem(), visited, s)
+		}
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			footprint(v.Field(i), visited, s)
+		}
+	case reflect.Slice:
+		elem := v.Type().Elem()
+		bytes := uint64(v.Cap()) * uint64(elem.Size())
+		s.CacheBytes += bytes
+		if hasPointers(elem) {
+			s.CacheScanBytes += bytes
+			for i := 0; i < v.Len(); i++ {
+				footprint(v.Index(i), visited, s)
+			}
+		}
+	case reflect.Map:
+		t := v.Type()
+		bytes := uint64(v.Len()) * uint64(t.Key().Size()+t.Elem().Size())
+		s.CacheBytes += bytes
+		if hasPointers(t.Key()) || hasPointers(t.Elem()) {
+			s.CacheScanBytes += bytes
+		}
+	}
+}
+
+// hasPointers returns true if the GC has to scan the type
+func hasPointers(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Ptr, reflect.UnsafePointer, reflect.Map, reflect.Slice, reflect.String,
+		reflect.Interface, reflect.Chan, reflect.Func:
+		return true
+	case reflect.Array:
+		return t.Len() > 0 && hasPointers(t.Elem())
+	case reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			if hasPointers(t.Field(i).Type) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// auditPointers checks that the types stored in the large arrays are
+// pointer free. One string in the item and the GC scans gigabytes
+// The stale index keeps items in the tables of the shards and a FIFO
+func auditPointers() error {
+	types := []reflect.Type{
+		reflect.TypeOf(item{}),
+		reflect.TypeOf(fifo.Entry{}),
+		reflect.TypeOf(ItemRef{}),
+		reflect.TypeOf(Object(0)),
+		reflect.TypeOf(TimeMs(0)),
+	}
+	for _, t := range types {
+		if hasPointers(t) {
+			return fmt.Errorf("type %v contains pointers", t)
+		}
+	}
+	// The slices of the queues and of the side arrays
+	layouts := []reflect.Type{
+		reflect.TypeOf(fifo.Fifo{}),
+		reflect.TypeOf(minheap.Heap{}),
+		reflect.TypeOf(accessTimes{}),
+		reflect.TypeOf(fingerprints{}),
+		reflect.TypeOf(storedTTLs{}),
+		reflect.TypeOf(adaptiveTTL{}),
+	}
+	for _, t := range layouts {
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if f.Type.Kind() == reflect.Slice && hasPointers(f.Type.Elem()) {
+				return fmt.Errorf("%v.%s contains pointers", t, f.Name)
+			}
+		}
+	}
+	return nil
+}
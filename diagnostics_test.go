@@ -0,0 +1,49 @@
+package mcache
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAuditPointers(t *testing.T) {
+	if err := auditPointers(); err != nil {
+		t.Fatalf("%v", err)
+	}
+	type withPointer struct {
+		a int
+		b [2]*int
+	}
+	if !hasPointers(reflect.TypeOf(withPointer{})) {
+		t.Fatalf("Failed to find pointer in %T", withPointer{})
+	}
+	if hasPointers(reflect.TypeOf([4]item{})) {
+		t.Fatalf("Found pointer in %T", [4]item{})
+	}
+}
+
+func TestMeasureGC(t *testing.T) {
+	cache := New(Configuration{Size: 1000 * 1000, TTL: TTL, AccessTime: true, Histograms: true})
+	before := cache.MeasureGC()
+	now := GetTime()
+	for i := 0; i < 1000*1000; i++ {
+		cache.Store(uint64(i), Object(i), now)
+	}
+	after := cache.MeasureGC()
+	if after.HeapLiveBytes < after.CacheBytes {
+		t.Fatalf("Live heap %d is below the cache %d", after.HeapLiveBytes, after.CacheBytes)
+	}
+	// The cache arrays are not scanned
+	if after.HeapScanBytes > before.HeapScanBytes+1024*1024 {
+		t.Fatalf("Scannable heap grew from %d to %d", before.HeapScanBytes, after.HeapScanBytes)
+	}
+	// The queue alone is 12MB, the access times 4MB
+	if after.CacheBytes < 16*1024*1024 || after.CacheBytes != before.CacheBytes {
+		t.Fatalf("Cache bytes %d %d", before.CacheBytes, after.CacheBytes)
+	}
+	if after.CacheScanBytes > 64*1024 {
+		t.Fatalf("Cache scan bytes %d", after.CacheScanBytes)
+	}
+	if cache.Len() == 0 {
+		t.Fatalf("Cache is empty")
+	}
+}
@@ -0,0 +1,145 @@
+// Package dnskey normalizes and hashes domain names for the cache
+// "Google.COM" and "google.com." is the same key. The functions lowercase
+// ASCII letters and add the trailing dot
+// Hashing functions do not allocate for valid names (up to 255 bytes)
+package dnskey
+
+import (
+	"github.com/larytet/mcachego/keyhash"
+)
+
+// MaxNameLength is the limit from RFC 1035 including the trailing dot
+const MaxNameLength = 255
+
+func toLower(c byte) byte {
+	if c >= 'A' && c <= 'Z' {
+		return c + ('a' - 'A')
+	}
+	return c
+}
+
+// Normalize appends the lowercase name with the trailing dot to dst
+// Normalize does not allocate if dst has enough capacity
+func Normalize(dst []byte, name []byte) []byte {
+	for _, c := range name {
+		dst = append(dst, toLower(c))
+	}
+	if len(name) == 0 || name[len(name)-1] != '.' {
+		dst = append(dst, '.')
+	}
+	return dst
+}
+
+// NormalizeString returns the lowercase name with the trailing dot
+func NormalizeString(name string) string {
+	return string(Normalize(make([]byte, 0, len(name)+1), []byte(name)))
+}
+
+// Labels returns number of labels in the name. The root "." has zero labels
+func Labels(name []byte) int {
+	labels := 0
+	start := 0
+	for i, c := range name {
+		if c == '.' {
+			if i > start {
+				labels++
+			}
+			start = i + 1
+		}
+	}
+	if start < len(name) {
+		labels++
+	}
+	return labels
+}
+
+// Hash returns hash of the normalized name
+// I normalize the name into a buffer on the stack
+func Hash(name []byte) uint64 {
+	var buf [MaxNameLength + 1]byte
+	if len(name) >= len(buf) {
+		// Not a valid name anyway
+		return keyhash.Bytes(Normalize(nil, name))
+	}
+	return keyhash.Bytes(Normalize(buf[:0], name))
+}
+
+// HashString returns hash of the normalized name
+func HashString(name string) uint64 {
+	var buf [MaxNameLength + 1]byte
+	if len(name) >= len(buf) {
+		return keyhash.Bytes(Normalize(nil, []byte(name)))
+	}
+	n := 0
+	for i := 0; i < len(name); i++ {
+		buf[n] = toLower(name[i])
+		n++
+	}
+	if n == 0 || buf[n-1] != '.' {
+		buf[n] = '.'
+		n++
+	}
+	return keyhash.Bytes(buf[:n])
+}
+
+// Second level labels which are usually a public suffix: "co.uk", "com.au"
+func isPublicSecondLevel(label []byte) bool {
+	switch string(label) {
+	case "co", "com", "net", "org", "gov", "edu", "ac", "or", "ne", "go":
+		return true
+	}
+	return false
+}
+
+// Domain returns the registrable part of the normalized name: two last
+// labels or three if the second level label looks like a public suffix
+// "www.google.com." -> "google.com.", "news.bbc.co.uk." -> "bbc.co.uk."
+// This is a heuristic, not the Public Suffix List
+func Domain(name []byte) []byte {
+	end := len(name)
+	if end > 0 && name[end-1] == '.' {
+		end--
+	}
+	// Start positions of the last three labels
+	var starts [3]int
+	found := 0
+	for i := end - 1; i >= 0 && found < 3; i-- {
+		if name[i] == '.' {
+			starts[found] = i + 1
+			found++
+		}
+	}
+	for ; found < 3; found++ {
+		starts[found] = 0
+	}
+	// starts[0] is the TLD, starts[1] is the second level label
+	if starts[1] == 0 {
+		return name
+	}
+	if isPublicSecondLevel(name[starts[1] : starts[0]-1]) {
+		return name[starts[2]:]
+	}
+	return name[starts[1]:]
+}
+
+// ClusteredHash returns a hash where all bits but the lowest clusterBits
+// come from the registrable domain. The hashtable uses the low bits for the
+// slot index. "www.google.com." and "mail.google.com." land within
+// 2^clusterBits slots from each other. With 16 bytes slots clusterBits=8
+// is a 4K page
+// The popular domains are accessed together. If their subdomains share a
+// memory page I pay for one data cache/TLB miss instead of several
+// Use Configuration.RawHash - mixing the key again destroys the clustering
+func ClusteredHash(name []byte, clusterBits uint) uint64 {
+	var buf [MaxNameLength + 1]byte
+	var normalized []byte
+	if len(name) >= len(buf) {
+		normalized = Normalize(nil, name)
+	} else {
+		normalized = Normalize(buf[:0], name)
+	}
+	mask := (uint64(1) << clusterBits) - 1
+	domainHash := keyhash.Bytes(Domain(normalized))
+	nameHash := keyhash.Bytes(normalized)
+	return (domainHash &^ mask) | (nameHash & mask)
+}
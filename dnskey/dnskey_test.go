@@ -0,0 +1,108 @@
+package dnskey
+
+import (
+	"testing"
+)
+
+func TestNormalize(t *testing.T) {
+	tests := []struct{ name, expected string }{
+		{"Google.COM", "google.com."},
+		{"google.com.", "google.com."},
+		{"", "."},
+		{".", "."},
+	}
+	for _, test := range tests {
+		if n := NormalizeString(test.name); n != test.expected {
+			t.Fatalf("Got %s instead of %s", n, test.expected)
+		}
+	}
+}
+
+func TestHash(t *testing.T) {
+	if Hash([]byte("Google.COM")) != Hash([]byte("google.com.")) {
+		t.Fatalf("Hash is not case insensitive")
+	}
+	if HashString("Google.COM") != Hash([]byte("google.com.")) {
+		t.Fatalf("HashString differs from Hash")
+	}
+	if Hash([]byte("google.com.")) == Hash([]byte("google.org.")) {
+		t.Fatalf("Collision")
+	}
+}
+
+func TestHashAllocs(t *testing.T) {
+	name := []byte("WWW.Google.com")
+	allocs := testing.AllocsPerRun(100, func() {
+		Hash(name)
+		HashString("WWW.Google.com")
+		ClusteredHash(name, 8)
+	})
+	if allocs != 0 {
+		t.Fatalf("Hashing allocates %v", allocs)
+	}
+}
+
+func TestLabels(t *testing.T) {
+	tests := []struct {
+		name   string
+		labels int
+	}{
+		{".", 0},
+		{"com.", 1},
+		{"google.com", 2},
+		{"www.google.com.", 3},
+	}
+	for _, test := range tests {
+		if l := Labels([]byte(test.name)); l != test.labels {
+			t.Fatalf("Got %d labels instead of %d in %s", l, test.labels, test.name)
+		}
+	}
+}
+
+func TestDomain(t *testing.T) {
+	tests := []struct{ name, domain string }{
+		{"com.", "com."},
+		{"google.com.", "google.com."},
+		{"www.google.com.", "google.com."},
+		{"a.b.www.google.com.", "google.com."},
+		{"news.bbc.co.uk.", "bbc.co.uk."},
+		{"co.uk.", "co.uk."},
+		{"google.com", "google.com"},
+	}
+	for _, test := range tests {
+		if d := string(Domain([]byte(test.name))); d != test.domain {
+			t.Fatalf("Got %s instead of %s for %s", d, test.domain, test.name)
+		}
+	}
+}
+
+func TestClusteredHash(t *testing.T) {
+	var clusterBits uint = 8
+	mask := (uint64(1) << clusterBits) - 1
+	h1 := ClusteredHash([]byte("www.google.com."), clusterBits)
+	h2 := ClusteredHash([]byte("Mail.Google.com"), clusterBits)
+	if h1&^mask != h2&^mask {
+		t.Fatalf("Subdomains are not clustered %x %x", h1, h2)
+	}
+	if h1 == h2 {
+		t.Fatalf("Collision")
+	}
+	h3 := ClusteredHash([]byte("www.google.org."), clusterBits)
+	if h1&^mask == h3&^mask {
+		t.Fatalf("Different domains are clustered %x %x", h1, h3)
+	}
+}
+
+func BenchmarkHash(b *testing.B) {
+	name := []byte("www.Google.com")
+	for i := 0; i < b.N; i++ {
+		Hash(name)
+	}
+}
+
+func BenchmarkClusteredHash(b *testing.B) {
+	name := []byte("www.Google.com")
+	for i := 0; i < b.N; i++ {
+		ClusteredHash(name, 8)
+	}
+}
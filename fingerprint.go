@@ -0,0 +1,111 @@
+package mcache
+
+import (
+	"context"
+	"sync/atomic"
+	"unsafe"
+
+	"github.com/larytet-go/hashtable"
+	"github.com/larytet/mcachego/internal/fifo"
+)
+
+// The key of the cache is a 64 bits hash of the user key. Two domain names
+// can alias and the cache serves the answer of another name
+// With Configuration.Fingerprints the application stores a second, 32 bits,
+// hash of the user key, see keyhash.Fingerprint(). LoadVerified() compares
+// the fingerprints and misses if the entry belongs to another user key
+// The item in the hashtable has no room for the fingerprint. Like the access
+// time the fingerprints are in a separate array indexed by the sequence
+// number of the eviction queue, see access.go
+
+// fingerprints keeps a fingerprint for every sequence number
+// Zero means that the entry has no fingerprint
+type fingerprints struct {
+	values []uint32
+	mask   uint32
+	// Number of mismatches in LoadVerified()
+	collisions uint64
+}
+
+func newFingerprints(size int) *fingerprints {
+	size = hashtable.GetPower2(size)
+	return &fingerprints{
+		values: make([]uint32, size),
+		mask:   uint32(size - 1),
+	}
+}
+
+func (f *fingerprints) reset() {
+	for i := range f.values {
+		f.values[i] = 0
+	}
+	atomic.StoreUint64(&f.collisions, 0)
+}
+
+// set is called with the shard locked
+func (f *fingerprints) set(seq uint32, fingerprint uint32) {
+	atomic.StoreUint32(&f.values[seq&f.mask], fingerprint)
+}
+
+// get is called with the shard locked for read
+func (f *fingerprints) get(seq uint32) uint32 {
+	return atomic.LoadUint32(&f.values[seq&f.mask])
+}
+
+// move is called with the shard locked when the entry moves in the queue
+func (f *fingerprints) move(from uint32, to uint32) {
+	f.set(to, f.get(from))
+}
+
+// setFingerprint sets the fingerprint of the stored key. Called with the
+// shard locked
+func (c *Cache) setFingerprint(shard *shard, hash uint64, key uint64, fingerprint uint32) {
+	iValue, _, _ := shard.table.Load(key, hash)
+	i := *(*item)(unsafe.Pointer(&iValue))
+	c.fingerprints.set(i.fifoSeq, fingerprint)
+}
+
+// StoreVerified adds an object with the fingerprint of the user key
+// Requires Configuration.Fingerprints
+// StoreVerified costs an additional lookup in the hashtable. Otherwise it is
+// Store(): the rate limiter, EvictOnStore, CoalesceStores and the backend
+// apply. A coalesced store keeps the fingerprint of the first Store() and
+// returns false
+func (c *Cache) StoreVerified(key uint64, fingerprint uint32, o Object, now TimeMs) bool {
+	if c.fingerprints == nil {
+		return false
+	}
+	r := storeRequest{
+		e:           fifo.Entry{Key: key, ExpirationMs: int32(now + c.ttl(key, o))},
+		o:           o,
+		now:         now,
+		mode:        storeLimit | storeWriteThrough | storeLog,
+		fingerprint: fingerprint,
+	}
+	result, _ := c.storeEntry(context.Background(), &r)
+	return result == storeOK
+}
+
+// LoadVerified performs lookup and compares the fingerprints
+// Returns false if the entry was stored with another fingerprint and counts
+// the collision, see Statistics.CollisionDetected
+// An entry stored without a fingerprint matches any fingerprint
+func (c *Cache) LoadVerified(key uint64, fingerprint uint32) (o Object, ok bool) {
+	if c.isClosed() {
+		return 0, false
+	}
+	hash := c.hash(key)
+	shard, _ := c.shardOf(hash)
+	c.rlock(shard)
+	iValue, ok, _ := shard.table.Load(key, hash)
+	i := *(*item)(unsafe.Pointer(&iValue))
+	if ok && c.fingerprints != nil {
+		stored := c.fingerprints.get(i.fifoSeq)
+		if stored != 0 && stored != fingerprint {
+			atomic.AddUint64(&c.fingerprints.collisions, 1)
+			ok = false
+		}
+	}
+	c.runlock(shard)
+	return i.o, ok
+}
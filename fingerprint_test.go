@@ -0,0 +1,77 @@
+package mcache
+
+import (
+	"testing"
+
+	"github.com/larytet/mcachego/keyhash"
+)
+
+func TestLoadVerified(t *testing.T) {
+	cache := New(Configuration{Size: 10, TTL: 100, LoadFactor: 100, Fingerprints: true})
+	name := "google.com."
+	key := keyhash.String(name)
+	if !cache.StoreVerified(key, keyhash.Fingerprint(name), 1, 0) {
+		t.Fatalf("Failed to store")
+	}
+	if o, ok := cache.LoadVerified(key, keyhash.Fingerprint(name)); !ok || o != 1 {
+		t.Fatalf("LoadVerified returned %v %v", o, ok)
+	}
+	// Another name with the same 64 bits hash
+	if _, ok := cache.LoadVerified(key, keyhash.Fingerprint("example.com.")); ok {
+		t.Fatalf("LoadVerified ignored the fingerprint")
+	}
+	if s := cache.GetStatistics(); s.CollisionDetected != 1 {
+		t.Fatalf("Got %d collisions instead of 1", s.CollisionDetected)
+	}
+	// Store() clears the fingerprint
+	cache.Store(key, 2, 0)
+	if o, ok := cache.LoadVerified(key, 7); !ok || o != 2 {
+		t.Fatalf("LoadVerified returned %v %v", o, ok)
+	}
+	cache.Reset()
+	if s := cache.GetStatistics(); s.CollisionDetected != 0 {
+		t.Fatalf("Got %d collisions after Reset", s.CollisionDetected)
+	}
+	if New(Configuration{Size: 10, TTL: 100}).StoreVerified(key, 1, 1, 0) {
+		t.Fatalf("StoreVerified succeeded without Fingerprints")
+	}
+}
+
+func TestStoreVerifiedPath(t *testing.T) {
+	cache := New(Configuration{Size: 10, TTL: 100, LoadFactor: 100, Fingerprints: true,
+		CoalesceStores: true, EvictOnStore: 1})
+	if !cache.StoreVerified(1, 1, 1, 0) {
+		t.Fatalf("Failed to store")
+	}
+	// The second store is coalesced and keeps the first fingerprint
+	if cache.StoreVerified(1, 2, 2, 0) {
+		t.Fatalf("Coalesced StoreVerified returned true")
+	}
+	if o, ok := cache.LoadVerified(1, 1); !ok || o != 1 {
+		t.Fatalf("LoadVerified returned %v %v", o, ok)
+	}
+	if s := cache.GetStatistics(); s.StoreCoalesced != 1 {
+		t.Fatalf("Got StoreCoalesced %d instead of 1", s.StoreCoalesced)
+	}
+	// The store evicts the expired entry
+	if !cache.StoreVerified(2, 1, 2, 200) {
+		t.Fatalf("Failed to store")
+	}
+	if _, ok := cache.LoadVerified(1, 1); ok {
+		t.Fatalf("The expired entry was not evicted")
+	}
+}
+
+func TestStoreVerifiedSingle(t *testing.T) {
+	cache := New(Configuration{Size: 10, TTL: 100, LoadFactor: 100, Fingerprints: true,
+		SingleGoroutine: true})
+	if !cache.StoreVerified(1, 1, 1, 0) {
+		t.Fatalf("Failed to store")
+	}
+	if o, ok := cache.LoadVerified(1, 1); !ok || o != 1 {
+		t.Fatalf("LoadVerified returned %v %v", o, ok)
+	}
+	if _, ok := cache.LoadVerified(1, 2); ok {
+		t.Fatalf("LoadVerified ignored the fingerprint")
+	}
+}
@@ -0,0 +1,138 @@
+package mcache
+
+import (
+	"unsafe"
+
+	"github.com/larytet-go/hashtable"
+	"github.com/larytet/mcachego/internal/fifo"
+)
+
+// An operator can clear a misbehaving part of the key space - a shard or
+// a tenant - without taking the hit rate cliff of Reset()
+// The flush copies the matching keys from the eviction queue and removes
+// the entries shard by shard. Entries stored during the flush can survive
+// These APIs allocate
+
+// flushEntry is a candidate for removal
+type flushEntry struct {
+	key  uint64
+	hash uint64
+	seq  uint32
+	o    Object
+	// The entry is in the stale table, see StaleTTL
+	stale bool
+}
+
+// Shards returns the number of shards
+func (c *Cache) Shards() int {
+	return len(c.shards)
+}
+
+// ShardOf returns the index of the shard of the key
+func (c *Cache) ShardOf(key uint64) int {
+	return int(c.shardIdx(c.hash(key)))
+}
+
+// FlushShard removes all entries of the shard, see ShardOf()
+// 'removed' is called for every removed entry after the shard is unlocked,
+// can be nil. The pool objects are free already
+// Returns the number of removed entries
+func (c *Cache) FlushShard(idx int, removed func(key uint64, o Object)) int {
+	if idx < 0 || idx >= len(c.shards) {
+		return 0
+	}
+	return c.flush(func(key uint64, hash uint64) bool {
+		return c.shardIdx(hash) == uint64(idx)
+	}, removed)
+}
+
+// Flush removes the entries for which 'match' returns true, for example,
+// all keys of a tenant
+// 'match' is called under the queue lock and must not call the cache API
+// 'removed' is called for every removed entry after the shard is unlocked,
+// can be nil
+// Returns the number of removed entries
+func (c *Cache) Flush(match func(key uint64) bool, removed func(key uint64, o Object)) int {
+	return c.flush(func(key uint64, hash uint64) bool {
+		return match(key)
+	}, removed)
+}
+
+func (c *Cache) flush(match func(key uint64, hash uint64) bool, removed func(key uint64, o Object)) int {
+	batches := make([][]flushEntry, len(c.shards))
+	collect := func(queue expirationQueue, stale bool) {
+		queue.Range(func(seq uint32, e fifo.Entry) bool {
+			hash := c.hash(e.Key)
+			if match(e.Key, hash) {
+				idx := c.shardIdx(hash)
+				batches[idx] = append(batches[idx], flushEntry{key: e.Key, hash: hash, seq: seq, stale: stale})
+			}
+			return true
+		})
+	}
+	c.lockQueue()
+	collect(c.queue, false)
+	if c.stale != nil {
+		collect(c.stale.queue, true)
+	}
+	c.unlockQueue()
+
+	count := 0
+	for idx, batch := range batches {
+		if len(batch) == 0 {
+			continue
+		}
+		batch = c.flushBatch(idx, batch)
+		for _, entry := range batch {
+			if c.reverse != nil && !entry.stale {
+				c.reverse.remove(entry.o, entry.key)
+			}
+			if removed != nil {
+				removed(entry.key, entry.o)
+			}
+		}
+		count += len(batch)
+	}
+	if count > 0 && c.watermarks != nil {
+		c.checkWatermarks(c.Len())
+	}
+	return count
+}
+
+// flushBatch removes the entries of the shard
+// Returns the removed entries
+func (c *Cache) flushBatch(idx int, batch []flushEntry) []flushEntry {
+	shard := c.shards[idx]
+	n := 0
+	c.lock(shard)
+	for _, entry := range batch {
+		var table *hashtable.Hashtable
+		var queue expirationQueue
+		if entry.stale {
+			table, queue = c.stale.tables[idx], c.stale.queue
+		} else {
+			table, queue = shard.table, c.queue
+		}
+		iValue, ok, ref := table.Load(entry.key, entry.hash)
+		i := *(*item)(unsafe.Pointer(&iValue))
+		if !ok || i.fifoSeq != entry.seq {
+			// Overwritten or evicted after I copied the queue
+			continue
+		}
+		table.RemoveByRef(ref)
+		c.lockQueue()
+		queue.Tombstone(entry.seq)
+		c.unlockQueue()
+		if shard.pool != nil {
+			c.poolFree(shard, i.o)
+		}
+		if !entry.stale && c.wal != nil {
+			c.logDelete(entry.key)
+		}
+		entry.o = i.o
+		batch[n] = entry
+		n++
+	}
+	c.unlock(shard)
+	return batch[:n]
+}
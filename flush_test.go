@@ -0,0 +1,69 @@
+package mcache
+
+import (
+	"testing"
+)
+
+func TestFlushShard(t *testing.T) {
+	cache := New(Configuration{Size: 1000, TTL: 100, Shards: 4})
+	for i := 0; i < 1000; i++ {
+		cache.Store(uint64(i), Object(i), 0)
+	}
+	idx := cache.ShardOf(7)
+	expected := 0
+	for i := 0; i < 1000; i++ {
+		if cache.ShardOf(uint64(i)) == idx {
+			expected++
+		}
+	}
+	removed := 0
+	count := cache.FlushShard(idx, func(key uint64, o Object) {
+		if cache.ShardOf(key) != idx || Object(key) != o {
+			t.Fatalf("Removed key %d, object %d", key, o)
+		}
+		removed++
+	})
+	if count != expected || removed != expected {
+		t.Fatalf("Removed %d/%d entries instead of %d", count, removed, expected)
+	}
+	if cache.Len() != 1000-expected {
+		t.Fatalf("Got Len %d instead of %d", cache.Len(), 1000-expected)
+	}
+	for i := 0; i < 1000; i++ {
+		_, _, ok := cache.Load(uint64(i))
+		if ok != (cache.ShardOf(uint64(i)) != idx) {
+			t.Fatalf("Load(%d) returned %v", i, ok)
+		}
+	}
+	if count := cache.FlushShard(cache.Shards(), nil); count != 0 {
+		t.Fatalf("Flushed %d entries of a bad shard", count)
+	}
+}
+
+func TestFlush(t *testing.T) {
+	cache := New(Configuration{Size: 100, TTL: 100, LoadFactor: 100, StaleTTL: 100})
+	for i := 0; i < 10; i++ {
+		cache.Store(uint64(i), Object(i), 0)
+	}
+	// Overwritten entries leave dead entries in the FIFO
+	cache.Store(2, 2, 50)
+	for i := 0; i < 10; i++ {
+		cache.Evict(100, false)
+	}
+	if _, stale, ok := cache.LoadStale(0); !ok || !stale {
+		t.Fatalf("Entry 0 is not stale")
+	}
+	// Tenant is the low bit of the key
+	count := cache.Flush(func(key uint64) bool { return key&1 == 0 }, nil)
+	if count != 5 {
+		t.Fatalf("Removed %d entries instead of 5", count)
+	}
+	for i := 0; i < 10; i++ {
+		if _, _, ok := cache.LoadStale(uint64(i)); ok != (i&1 == 1) {
+			t.Fatalf("LoadStale(%d) returned %v", i, ok)
+		}
+	}
+	if cache.Len() != 0 {
+		t.Fatalf("Got Len %d instead of 0", cache.Len())
+	}
+}
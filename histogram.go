@@ -0,0 +1,145 @@
+package mcache
+
+import (
+	"math"
+	"math/bits"
+	"sync/atomic"
+
+	"github.com/larytet-go/hashtable"
+)
+
+// HistogramBuckets is the number of buckets in the histogram
+const HistogramBuckets = 32
+
+// Histogram counts durations in power of 2 buckets
+// Bucket 0 keeps the values <= 0, bucket i keeps the values in the
+// range [2^(i-1), 2^i) ms. The last bucket is about 12 days
+type Histogram [HistogramBuckets]uint64
+
+func (h *Histogram) add(v TimeMs) {
+	idx := 0
+	if v > 0 {
+		idx = bits.Len32(uint32(v))
+		if idx >= HistogramBuckets {
+			idx = HistogramBuckets - 1
+		}
+	}
+	atomic.AddUint64(&h[idx], 1)
+}
+
+// Count returns the number of values in the histogram
+func (h *Histogram) Count() uint64 {
+	var count uint64
+	for _, c := range h {
+		count += c
+	}
+	return count
+}
+
+// Percentile returns the upper bound of the bucket which keeps the
+// percentile p, for example, 99
+func (h *Histogram) Percentile(p float64) TimeMs {
+	count := h.Count()
+	if count == 0 {
+		return 0
+	}
+	threshold := uint64(float64(count) * p / 100)
+	var sum uint64
+	for idx, c := range h {
+		sum += c
+		if sum > threshold || sum == count {
+			return bucketLimit(idx)
+		}
+	}
+	return bucketLimit(HistogramBuckets - 1)
+}
+
+// bucketLimit returns the upper bound of the bucket
+func bucketLimit(idx int) TimeMs {
+	if idx == 0 {
+		return 0
+	}
+	return TimeMs(uint32(1)<<uint(idx) - 1)
+}
+
+// Histograms of the TTLs and of the age of the entries at eviction
+// If ForcedAge is large compared to ExpiredAge the cache is too small: the
+// entries die from Evict(force=true) and not from the TTL
+// The age histograms skip the entries of a snapshot and of an import, the
+// TTL of such entries is not known
+type Histograms struct {
+	// TTL of the stored entries
+	TTL Histogram
+	// Age of the expired entries at eviction. The age is larger than the
+	// TTL if the application calls Evict() late
+	ExpiredAge Histogram
+	// Age of the entries evicted by Evict(force=true)
+	ForcedAge Histogram
+}
+
+// The counters are atomic. The histograms are optional and I do not want
+// to couple them with the shard or the queue locks
+func (h *Histograms) copy() Histograms {
+	var res Histograms
+	for i := 0; i < HistogramBuckets; i++ {
+		res.TTL[i] = atomic.LoadUint64(&h.TTL[i])
+		res.ExpiredAge[i] = atomic.LoadUint64(&h.ExpiredAge[i])
+		res.ForcedAge[i] = atomic.LoadUint64(&h.ForcedAge[i])
+	}
+	return res
+}
+
+// The entry keeps only the expiration time. TTLFunc, StoreWithTTL() and
+// the backend set different TTLs for the same key. Like the access time the
+// TTLs recorded by Store() are in a separate array indexed by the sequence
+// number of the eviction queue, see access.go
+
+// unknownTTL is the TTL of the restored and the imported entries
+const unknownTTL = math.MinInt32
+
+type storedTTLs struct {
+	values []int32
+	mask   uint32
+}
+
+func newStoredTTLs(size int) *storedTTLs {
+	size = hashtable.GetPower2(size)
+	return &storedTTLs{
+		values: make([]int32, size),
+		mask:   uint32(size - 1),
+	}
+}
+
+// set is called with the shard locked
+func (s *storedTTLs) set(seq uint32, ttl TimeMs) {
+	atomic.StoreInt32(&s.values[seq&s.mask], int32(ttl))
+}
+
+// get is called with the shard locked
+func (s *storedTTLs) get(seq uint32) TimeMs {
+	return TimeMs(atomic.LoadInt32(&s.values[seq&s.mask]))
+}
+
+// move is called with the shard locked when the entry moves in the queue
+func (s *storedTTLs) move(from uint32, to uint32) {
+	s.set(to, s.get(from))
+}
+
+// evictionAge returns the age of the entry at time 'now'
+// Returns false if the TTL of the entry is not known
+func (c *Cache) evictionAge(seq uint32, expirationMs int32, now TimeMs) (TimeMs, bool) {
+	ttl := c.ttls.get(seq)
+	if ttl == unknownTTL {
+		return 0, false
+	}
+	return now - (TimeMs(expirationMs) - ttl), true
+}
+
+// GetHistograms returns a copy of the histograms
+// Requires Configuration.Histograms
+func (c *Cache) GetHistograms() Histograms {
+	if c.histograms == nil {
+		return Histograms{}
+	}
+	return c.histograms.copy()
+}
@@ -0,0 +1,95 @@
+package mcache
+
+import (
+	"context"
+	"testing"
+)
+
+func TestHistogram(t *testing.T) {
+	var h Histogram
+	for _, v := range []TimeMs{-1, 0, 1, 2, 3, 1000, 1 << 30} {
+		h.add(v)
+	}
+	expected := map[int]uint64{0: 2, 1: 1, 2: 2, 10: 1, 31: 1}
+	for idx, count := range h {
+		if count != expected[idx] {
+			t.Fatalf("Bucket %d has %d instead of %d", idx, count, expected[idx])
+		}
+	}
+	if h.Count() != 7 {
+		t.Fatalf("Got count %d instead of 7", h.Count())
+	}
+	if p := h.Percentile(50); p != 3 {
+		t.Fatalf("Got median %d instead of 3", p)
+	}
+	if p := h.Percentile(100); p != bucketLimit(31) {
+		t.Fatalf("Got max %d instead of %d", p, bucketLimit(31))
+	}
+}
+
+func TestHistograms(t *testing.T) {
+	cache := New(Configuration{Size: 10, TTL: 100, LoadFactor: 100, Histograms: true})
+	now := TimeMs(1000)
+	for i := 0; i < 4; i++ {
+		cache.Store(uint64(i), Object(i), now)
+	}
+	cache.Evict(now+150, false)
+	cache.Evict(now+10, true)
+	h := cache.GetHistograms()
+	if h.TTL.Count() != 4 || h.TTL.Percentile(100) != 127 {
+		t.Fatalf("Bad TTL histogram %v", h.TTL)
+	}
+	// Age 150 is in the bucket [128, 256)
+	if h.ExpiredAge.Count() != 1 || h.ExpiredAge[8] != 1 {
+		t.Fatalf("Bad expired age histogram %v", h.ExpiredAge)
+	}
+	// Age 10 is in the bucket [8, 16)
+	if h.ForcedAge.Count() != 1 || h.ForcedAge[4] != 1 {
+		t.Fatalf("Bad forced age histogram %v", h.ForcedAge)
+	}
+	if h := New(Configuration{Size: 10, TTL: 100}).GetHistograms(); h.TTL.Count() != 0 {
+		t.Fatalf("Histograms are not empty")
+	}
+}
+
+func TestHistogramsStoreAPI(t *testing.T) {
+	backend := newMapBackend()
+	cache := New(Configuration{Size: 10, TTL: 100, LoadFactor: 100, Histograms: true,
+		Fingerprints: true, Backend: backend})
+	now := TimeMs(1000)
+	cache.Store(1, 1, now)
+	cache.StoreWithTTL(2, 2, now, 100, 0)
+	cache.StoreE(3, 3, now, 0)
+	cache.StoreNoAlloc(4, 4, now)
+	cache.StoreCtx(context.Background(), 5, 5, now, 0)
+	cache.StoreVerified(6, 1, 6, now)
+	// Every Store*() API counts the TTL and writes through once
+	if h := cache.GetHistograms(); h.TTL.Count() != 6 {
+		t.Fatalf("Got %d TTLs instead of 6", h.TTL.Count())
+	}
+	for key := uint64(1); key <= 6; key++ {
+		if _, ttl, ok := backend.load(key); !ok || ttl != 100 {
+			t.Fatalf("Key %d is not in the backend, ttl %d", key, ttl)
+		}
+	}
+}
+
+func TestHistogramsStoredTTL(t *testing.T) {
+	cache := New(Configuration{Size: 10, TTL: 100, LoadFactor: 100, Histograms: true})
+	now := TimeMs(1000)
+	// The age is 10 and not 10 + (100 - 20)
+	cache.StoreWithTTL(1, 1, now, 20, 0)
+	cache.Evict(now+10, true)
+	// Age 10 is in the bucket [8, 16)
+	if h := cache.GetHistograms(); h.ForcedAge.Count() != 1 || h.ForcedAge[4] != 1 {
+		t.Fatalf("Bad forced age histogram %v", h.ForcedAge)
+	}
+	// The imported entries have no TTL
+	src := New(Configuration{Size: 10, TTL: 100, LoadFactor: 100})
+	src.Store(2, 2, now)
+	cache.ImportFrom(src, now)
+	cache.Evict(now+150, false)
+	if h := cache.GetHistograms(); h.ExpiredAge.Count() != 0 {
+		t.Fatalf("Bad expired age histogram %v", h.ExpiredAge)
+	}
+}
@@ -0,0 +1,140 @@
+package mcache
+
+import (
+	"unsafe"
+
+	"github.com/larytet/mcachego/internal/fifo"
+)
+
+// liveEntry is an entry of the cache with the object
+type liveEntry struct {
+	e    fifo.Entry
+	o    Object
+	hash uint64
+	// Sequence number of the queue, see restoreEntries()
+	seq uint32
+}
+
+// liveEntries returns the entries which are not expired in the order of
+// the queue. The order of the heap is not defined
+// I copy the queue under the queue lock and check every entry against the
+// table under the shard lock. An entry removed or overwritten after the copy
+// is skipped. In the intrinsic mode the queue keeps only the keys, I read
+// the expiration time and the flags from the header of the object
+// This function allocates a copy of the queue
+func (c *Cache) liveEntries(now TimeMs) []liveEntry {
+	type queued struct {
+		seq uint32
+		e   fifo.Entry
+	}
+	intrinsic := c.configuration.IntrinsicItem
+	c.lockQueue()
+	queue := make([]queued, 0, c.queue.Len())
+	c.queue.Range(func(seq uint32, e fifo.Entry) bool {
+		queue = append(queue, queued{seq, e})
+		return true
+	})
+	c.unlockQueue()
+
+	entries := make([]liveEntry, 0, len(queue))
+	for _, q := range queue {
+		if !intrinsic && TimeMs(q.e.ExpirationMs)-now <= 0 {
+			continue
+		}
+		hash := c.hash(q.e.Key)
+		shard, _ := c.shardOf(hash)
+		c.rlock(shard)
+		iValue, ok, _ := shard.table.Load(q.e.Key, hash)
+		i := *(*item)(unsafe.Pointer(&iValue))
+		ok = ok && i.fifoSeq == q.seq
+		if ok && intrinsic {
+			q.e = c.getHeader(shard, i.o, q.e.Key)
+		}
+		c.runlock(shard)
+		if !ok || TimeMs(q.e.ExpirationMs)-now <= 0 {
+			continue
+		}
+		entries = append(entries, liveEntry{e: q.e, o: i.o})
+	}
+	return entries
+}
+
+// importBatch is the number of entries I group by shard
+// The FIFO keeps the order of the source up to the batch. The batch is
+// small enough to not delay eviction of the expired entries
+const importBatch = 1024
+
+// importEntries stores the entries with one lock of a shard per batch
+// Returns the keys of the entries which do not fit the cache
+// The objects of a cache with PoolTemplate belong to the pools of the shards
+// and I reject all entries
+func (c *Cache) importEntries(entries []liveEntry) (rejected []uint64) {
+	if c.configuration.PoolTemplate != nil {
+		for _, entry := range entries {
+			rejected = append(rejected, entry.e.Key)
+		}
+		return rejected
+	}
+	batches := make([][]liveEntry, len(c.shards))
+	for start := 0; start < len(entries); start += importBatch {
+		end := start + importBatch
+		if end > len(entries) {
+			end = len(entries)
+		}
+		for idx := range batches {
+			batches[idx] = batches[idx][:0]
+		}
+		for _, entry := range entries[start:end] {
+			// The source cache can mix the keys differently
+			entry.hash = c.hash(entry.e.Key)
+			idx := c.shardIdx(entry.hash)
+			batches[idx] = append(batches[idx], entry)
+		}
+		for idx, batch := range batches {
+			if len(batch) > 0 {
+				rejected = c.storeBatch(c.shards[idx], batch, rejected)
+			}
+		}
+	}
+	if c.watermarks != nil {
+		c.checkWatermarks(c.Len())
+	}
+	return rejected
+}
+
+func (c *Cache) storeBatch(shard *shard, batch []liveEntry, rejected []uint64) []uint64 {
+	c.lock(shard)
+	for _, entry := range batch {
+		if result, _ := c.storeLocked(shard, entry.hash, entry.e, entry.o, unknownTTL); result != storeOK {
+			rejected = append(rejected, entry.e.Key)
+		}
+	}
+	c.unlock(shard)
+	return rejected
+}
+
+// ImportFrom copies the entries of the source cache which are not expired
+// The entries keep the expiration time and the flags
+// The blue/green rollover: a new, larger cache takes over from the old one
+// The source cache is not modified and can serve the lookups during the copy
+// Returns the keys which do not fit the cache
+// This API allocates
+func (c *Cache) ImportFrom(src *Cache, now TimeMs) (rejected []uint64) {
+	return c.importEntries(src.liveEntries(now))
+}
+
+// ImportMap stores the objects with the TTL
+// The order of the entries in the FIFO follows the order of the map - random
+// Returns the keys which do not fit the cache
+// This API allocates
+func (c *Cache) ImportMap(objects map[uint64]Object, ttl TimeMs, now TimeMs) (rejected []uint64) {
+	entries := make([]liveEntry, 0, len(objects))
+	expiration := int32(now + ttl)
+	for key, o := range objects {
+		entries = append(entries, liveEntry{
+			e: fifo.Entry{Key: key, ExpirationMs: expiration},
+			o: o,
+		})
+	}
+	return c.importEntries(entries)
+}
@@ -0,0 +1,73 @@
+package mcache
+
+import (
+	"testing"
+)
+
+func TestImportFrom(t *testing.T) {
+	src := New(Configuration{Size: 10, TTL: TTL, LoadFactor: 100, Shards: 2})
+	now := GetTime()
+	for i := 0; i < 10; i++ {
+		src.Store(uint64(i), Object(i), now)
+	}
+	_, ref, _ := src.Load(3)
+	src.EvictByRef(ref)
+	src.StoreWithFlags(20, 20, now-2*TTL, FlagNoForceEvict)
+
+	dst := New(Configuration{Size: 100, TTL: TTL, Shards: 4, RawHash: true})
+	if rejected := dst.ImportFrom(src, now); len(rejected) != 0 {
+		t.Fatalf("Rejected %v", rejected)
+	}
+	if dst.Len() != 9 {
+		t.Fatalf("Got Len %d instead of 9", dst.Len())
+	}
+	for i := 0; i < 10; i++ {
+		o, _, ok := dst.Load(uint64(i))
+		if i == 3 {
+			if ok {
+				t.Fatalf("Removed key %d is imported", i)
+			}
+			continue
+		}
+		if !ok || o != Object(i) {
+			t.Fatalf("Key %d is not imported", i)
+		}
+	}
+	if _, _, ok := dst.Load(20); ok {
+		t.Fatalf("Expired key is imported")
+	}
+	// The entries keep the expiration time
+	if _, expired := dst.Evict(now+TTL-1, false); expired {
+		t.Fatalf("Evicted before expiration")
+	}
+	if o, expired := dst.Evict(now+TTL, false); !expired || o != 0 {
+		t.Fatalf("Evicted %v instead of 0", o)
+	}
+}
+
+func TestImportMap(t *testing.T) {
+	dst := New(Configuration{Size: 5, TTL: TTL, LoadFactor: 100})
+	objects := make(map[uint64]Object)
+	for i := 0; i < 8; i++ {
+		objects[uint64(i)] = Object(i)
+	}
+	now := GetTime()
+	rejected := dst.ImportMap(objects, 2*TTL, now)
+	if len(rejected) != 3 || dst.Len() != 5 {
+		t.Fatalf("Rejected %v, Len %d", rejected, dst.Len())
+	}
+	for _, key := range rejected {
+		if _, _, ok := dst.Load(key); ok {
+			t.Fatalf("Rejected key %d is in the cache", key)
+		}
+		delete(objects, key)
+	}
+	for key, o := range objects {
+		if found, _, ok := dst.Load(key); !ok || found != o {
+			t.Fatalf("Key %d is not imported", key)
+		}
+	}
+	if _, expired := dst.Evict(now+TTL, false); expired {
+		t.Fatalf("Evicted before expiration")
+	}
+}
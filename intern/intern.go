@@ -0,0 +1,140 @@
+// Package intern maps repeated strings (domain names) to stable integer IDs
+// The application hashes and interns a name once and uses the cheap integer
+// ID as a key in the cache, in the logs, in the secondary structures
+// The strings are kept back to back in a preallocated byte arena. The GC
+// does not see the strings at all
+package intern
+
+import (
+	"sync"
+	"unsafe"
+
+	"github.com/larytet-go/hashtable"
+	"github.com/larytet/mcachego/keyhash"
+)
+
+// Interner keeps the interned strings
+type Interner struct {
+	// Maps hash of the string to the ID
+	table *hashtable.Hashtable
+	// All strings back to back
+	arena []byte
+	// Offset of the string in the arena by ID, offsets[id+1] is the end
+	offsets    []uint32
+	statistics Statistics
+	mutex      sync.RWMutex
+}
+
+// Statistics is a placeholder for debug counters
+type Statistics struct {
+	Hits uint64
+	// New strings
+	Misses uint64
+	// Different strings with the same 64 bits hash
+	Collisions uint64
+	// Not enough space in the arena or in the table
+	Overflows uint64
+}
+
+// New creates an interner for up to 'size' strings of total 'arenaSize' bytes
+// The arena does not grow. The strings returned by String() point to the arena
+func New(size int, arenaSize int) *Interner {
+	in := &Interner{
+		table:   hashtable.New(2*size, 64),
+		arena:   make([]byte, 0, arenaSize),
+		offsets: make([]uint32, 1, size+1),
+	}
+	return in
+}
+
+// Reset removes all strings
+// This API is not thread safe. The strings returned by String() become invalid
+func (in *Interner) Reset() {
+	in.mutex.Lock()
+	in.table.Reset()
+	in.arena = in.arena[:0]
+	in.offsets = in.offsets[:1]
+	in.statistics = Statistics{}
+	in.mutex.Unlock()
+}
+
+// bytes returns the string by ID. The caller holds the lock
+func (in *Interner) bytes(id uint64) []byte {
+	return in.arena[in.offsets[id]:in.offsets[id+1]]
+}
+
+// Lookup returns the ID of the string if the string is interned
+func (in *Interner) Lookup(s string) (id uint64, ok bool) {
+	hash := keyhash.String(s)
+	in.mutex.RLock()
+	value, ok, _ := in.table.Load(hash, hash)
+	if ok {
+		id = uint64(value)
+		ok = (string(in.bytes(id)) == s)
+	}
+	in.mutex.RUnlock()
+	return id, ok
+}
+
+// Intern returns the ID of the string, adds the string if not found
+// Returns false if there is no space or if a different string with the same
+// hash is interned already
+func (in *Interner) Intern(s string) (id uint64, ok bool) {
+	hash := keyhash.String(s)
+	in.mutex.Lock()
+	defer in.mutex.Unlock()
+	if value, found, _ := in.table.Load(hash, hash); found {
+		id = uint64(value)
+		if string(in.bytes(id)) == s {
+			in.statistics.Hits++
+			return id, true
+		}
+		in.statistics.Collisions++
+		return 0, false
+	}
+	if len(in.arena)+len(s) > cap(in.arena) || len(in.offsets) == cap(in.offsets) {
+		in.statistics.Overflows++
+		return 0, false
+	}
+	id = uint64(len(in.offsets) - 1)
+	if ok := in.table.Store(hash, hash, uintptr(id)); !ok {
+		in.statistics.Overflows++
+		return 0, false
+	}
+	in.arena = append(in.arena, s...)
+	in.offsets = append(in.offsets, uint32(len(in.arena)))
+	in.statistics.Misses++
+	return id, true
+}
+
+// InternBytes is Intern() for a slice. No allocations
+func (in *Interner) InternBytes(b []byte) (id uint64, ok bool) {
+	return in.Intern(*(*string)(unsafe.Pointer(&b)))
+}
+
+// String returns the string by ID
+// The string points to the arena, no allocations. The string is valid
+// until Reset()
+func (in *Interner) String(id uint64) (s string, ok bool) {
+	in.mutex.RLock()
+	defer in.mutex.RUnlock()
+	if id >= uint64(len(in.offsets)-1) {
+		return "", false
+	}
+	b := in.bytes(id)
+	return *(*string)(unsafe.Pointer(&b)), true
+}
+
+// Len returns number of interned strings
+func (in *Interner) Len() int {
+	in.mutex.RLock()
+	defer in.mutex.RUnlock()
+	return len(in.offsets) - 1
+}
+
+// GetStatistics returns a snapshot of debug counters
+func (in *Interner) GetStatistics() Statistics {
+	in.mutex.RLock()
+	defer in.mutex.RUnlock()
+	return in.statistics
+}
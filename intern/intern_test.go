@@ -0,0 +1,75 @@
+package intern
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestIntern(t *testing.T) {
+	in := New(2, 64)
+	id0, ok := in.Intern("google.com.")
+	if !ok {
+		t.Fatalf("Failed to intern")
+	}
+	id1, ok := in.InternBytes([]byte("yahoo.com."))
+	if !ok || id1 == id0 {
+		t.Fatalf("Failed to intern, id %d", id1)
+	}
+	if id, ok := in.Intern("google.com."); !ok || id != id0 {
+		t.Fatalf("Got id %d instead of %d", id, id0)
+	}
+	if id, ok := in.Lookup("yahoo.com."); !ok || id != id1 {
+		t.Fatalf("Got id %d instead of %d", id, id1)
+	}
+	if _, ok := in.Lookup("bing.com."); ok {
+		t.Fatalf("Found not interned string")
+	}
+	if _, ok := in.Intern("bing.com."); ok {
+		t.Fatalf("Did not fail on overflow")
+	}
+	if s, ok := in.String(id1); !ok || s != "yahoo.com." {
+		t.Fatalf("Got %s", s)
+	}
+	if _, ok := in.String(2); ok {
+		t.Fatalf("Got string for a bad id")
+	}
+	s := in.GetStatistics()
+	if s.Hits != 1 || s.Misses != 2 || s.Overflows != 1 {
+		t.Fatalf("Bad statistics %v", s)
+	}
+	in.Reset()
+	if in.Len() != 0 {
+		t.Fatalf("Got Len %d after Reset", in.Len())
+	}
+}
+
+func TestInternArenaFull(t *testing.T) {
+	in := New(10, 8)
+	if _, ok := in.Intern("google.com."); ok {
+		t.Fatalf("Did not fail on arena overflow")
+	}
+}
+
+func TestInternAllocs(t *testing.T) {
+	in := New(1, 64)
+	b := []byte("google.com.")
+	in.InternBytes(b)
+	allocs := testing.AllocsPerRun(100, func() {
+		in.InternBytes(b)
+	})
+	if allocs != 0 {
+		t.Fatalf("InternBytes allocates %v", allocs)
+	}
+}
+
+func BenchmarkIntern(b *testing.B) {
+	names := make([][]byte, 1024)
+	for i := range names {
+		names[i] = []byte(fmt.Sprintf("www.%d.com.", i))
+	}
+	in := New(len(names), 32*len(names))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		in.InternBytes(names[i&1023])
+	}
+}
@@ -0,0 +1,218 @@
+// Package fifo is a ring buffer of the cache entries
+// The cache keeps the keys of the stored entries in the FIFO and evicts
+// the entries in the order of arrival
+// The FIFO is not thread safe. The cache calls the API under a lock
+package fifo
+
+// Entry is an element of the FIFO
+// The cache keeps the expiration time here and not in the hashtable
+// The hashtable item keeps the sequence number of the entry instead
+type Entry struct {
+	Key          uint64
+	ExpirationMs int32
+	Flags        uint32
+}
+
+// Fifo is a fixed size ring buffer
+// Every added entry gets a 32 bits sequence number. The application can use
+// the sequence number to find the entry and mark it as removed
+type Fifo struct {
+	data []Entry
+	// Removed entries, see Tombstone()
+	tombstones []bool
+	// Index of the oldest entry
+	head int
+	// Index of the next free slot
+	tail int
+	// Sequence number of the oldest entry
+	headSeq uint32
+	// Occupied slots including tombstones
+	occupied int
+	// Entries which are not tombstones
+	count int
+	size  int
+}
+
+// New creates a FIFO which can keep 'size' entries
+func New(size int) *Fifo {
+	return &Fifo{
+		data:       make([]Entry, size, size),
+		tombstones: make([]bool, size, size),
+		size:       size,
+	}
+}
+
+// inc returns the next index
+// I avoid a modulo here - the size is not necessarily a power of 2
+func (f *Fifo) inc(idx int) int {
+	idx++
+	if idx >= f.size {
+		idx = 0
+	}
+	return idx
+}
+
+// Add appends the entry to the tail of the FIFO
+// Returns the sequence number of the entry, false if the FIFO is full
+func (f *Fifo) Add(e Entry) (seq uint32, ok bool) {
+	if f.occupied >= f.size {
+		// Tombstones on the head occupy the slots until Peek()
+		f.skipTombstones()
+		if f.occupied >= f.size {
+			return 0, false
+		}
+	}
+	seq = f.headSeq + uint32(f.occupied)
+	f.data[f.tail] = e
+	f.tombstones[f.tail] = false
+	f.tail = f.inc(f.tail)
+	f.occupied++
+	f.count++
+	return seq, true
+}
+
+// skipTombstones drops removed entries from the head of the FIFO
+func (f *Fifo) skipTombstones() {
+	for f.occupied > 0 && f.tombstones[f.head] {
+		f.head = f.inc(f.head)
+		f.headSeq++
+		f.occupied--
+	}
+}
+
+// Peek returns the head of the FIFO without removing it
+func (f *Fifo) Peek() (e Entry, seq uint32, ok bool) {
+	f.skipTombstones()
+	if f.occupied == 0 {
+		return e, 0, false
+	}
+	return f.data[f.head], f.headSeq, true
+}
+
+// Remove removes and returns the head of the FIFO
+func (f *Fifo) Remove() (e Entry, ok bool) {
+	f.skipTombstones()
+	if f.occupied == 0 {
+		return e, false
+	}
+	e = f.data[f.head]
+	f.head = f.inc(f.head)
+	f.headSeq++
+	f.occupied--
+	f.count--
+	return e, true
+}
+
+// index returns the slot of the entry with the sequence number
+func (f *Fifo) index(seq uint32) (idx int, ok bool) {
+	// Distance from the head. Wrap around of the sequence number is fine
+	offset := seq - f.headSeq
+	if uint64(offset) >= uint64(f.occupied) {
+		return 0, false
+	}
+	idx = f.head + int(offset)
+	if idx >= f.size {
+		idx -= f.size
+	}
+	return idx, !f.tombstones[idx]
+}
+
+// Get returns the entry with the sequence number
+func (f *Fifo) Get(seq uint32) (e Entry, ok bool) {
+	idx, ok := f.index(seq)
+	if !ok {
+		return e, false
+	}
+	return f.data[idx], true
+}
+
+// Tombstone marks the entry with the sequence number as removed
+// Peek() and Remove() skip the removed entries
+// Returns false if the entry is not in the FIFO
+func (f *Fifo) Tombstone(seq uint32) bool {
+	idx, ok := f.index(seq)
+	if !ok {
+		return false
+	}
+	f.tombstones[idx] = true
+	f.count--
+	return true
+}
+
+// PeekN appends up to k entries from the head of the FIFO to the slice
+// The entries remain in the FIFO. Tombstones are skipped
+// The evictor can check expiration of a batch of entries in one call
+func (f *Fifo) PeekN(k int, entries []Entry) []Entry {
+	f.skipTombstones()
+	idx := f.head
+	for i := 0; i < f.occupied && k > 0; i++ {
+		if !f.tombstones[idx] {
+			entries = append(entries, f.data[idx])
+			k--
+		}
+		idx = f.inc(idx)
+	}
+	return entries
+}
+
+// Range calls fn for every entry from the head to the tail of the FIFO
+// Tombstones are skipped. Range stops if fn returns false
+func (f *Fifo) Range(fn func(seq uint32, e Entry) bool) {
+	idx := f.head
+	for i := 0; i < f.occupied; i++ {
+		if !f.tombstones[idx] && !fn(f.headSeq+uint32(i), f.data[idx]) {
+			return
+		}
+		idx = f.inc(idx)
+	}
+}
+
+// At returns the entry in the slot 'i' modulo the occupied slots counting
+// from the head. Returns false for a tombstone or if the FIFO is empty
+// The self check picks random entries
+func (f *Fifo) At(i uint32) (seq uint32, e Entry, ok bool) {
+	if f.occupied == 0 {
+		return 0, e, false
+	}
+	offset := int(uint64(i) % uint64(f.occupied))
+	idx := f.head + offset
+	if idx >= f.size {
+		idx -= f.size
+	}
+	return f.headSeq + uint32(offset), f.data[idx], !f.tombstones[idx]
+}
+
+// Grow adds n slots to the FIFO
+// The sequence numbers of the entries do not change
+// This API allocates memory and copies all entries
+func (f *Fifo) Grow(n int) {
+	if n <= 0 {
+		return
+	}
+	size := f.size + n
+	data := make([]Entry, size, size)
+	tombstones := make([]bool, size, size)
+	idx := f.head
+	for i := 0; i < f.occupied; i++ {
+		data[i] = f.data[idx]
+		tombstones[i] = f.tombstones[idx]
+		idx = f.inc(idx)
+	}
+	f.data = data
+	f.tombstones = tombstones
+	f.size = size
+	f.head = 0
+	f.tail = f.occupied
+}
+
+// Len returns number of entries in the FIFO
+// Tombstones are not counted
+func (f *Fifo) Len() int {
+	return f.count
+}
+
+// Size returns the capacity of the FIFO
+// A tombstone occupies a slot until it reaches the head of the FIFO
+func (f *Fifo) Size() int {
+	return f.size
+}
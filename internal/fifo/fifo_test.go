@@ -0,0 +1,256 @@
+package fifo
+
+import (
+	"testing"
+)
+
+func TestAddRemove(t *testing.T) {
+	f := New(3)
+	if _, _, ok := f.Peek(); ok {
+		t.Fatalf("Peek succeeded in empty FIFO")
+	}
+	if _, ok := f.Remove(); ok {
+		t.Fatalf("Remove succeeded in empty FIFO")
+	}
+	for i := 0; i < 3; i++ {
+		if seq, ok := f.Add(Entry{Key: uint64(i)}); !ok || seq != uint32(i) {
+			t.Fatalf("Failed to add %d, seq %d", i, seq)
+		}
+	}
+	if _, ok := f.Add(Entry{Key: 3}); ok {
+		t.Fatalf("Did not fail on overflow")
+	}
+	if f.Len() != 3 || f.Size() != 3 {
+		t.Fatalf("Bad Len %d or Size %d", f.Len(), f.Size())
+	}
+	for i := 0; i < 3; i++ {
+		if e, seq, ok := f.Peek(); !ok || e.Key != uint64(i) || seq != uint32(i) {
+			t.Fatalf("Peek returned %d instead of %d", e.Key, i)
+		}
+		if e, ok := f.Remove(); !ok || e.Key != uint64(i) {
+			t.Fatalf("Remove returned %d instead of %d", e.Key, i)
+		}
+	}
+	if f.Len() != 0 {
+		t.Fatalf("FIFO is not empty %d", f.Len())
+	}
+}
+
+func TestWrapAround(t *testing.T) {
+	f := New(3)
+	for i := 0; i < 10; i++ {
+		f.Add(Entry{Key: uint64(i)})
+		f.Add(Entry{Key: uint64(i)})
+		for k := 0; k < 2; k++ {
+			if e, ok := f.Remove(); !ok || e.Key != uint64(i) {
+				t.Fatalf("Remove returned %d instead of %d", e.Key, i)
+			}
+		}
+	}
+}
+
+func TestTombstone(t *testing.T) {
+	f := New(3)
+	seq0, _ := f.Add(Entry{Key: 0})
+	seq1, _ := f.Add(Entry{Key: 1})
+	seq2, _ := f.Add(Entry{Key: 2})
+	if ok := f.Tombstone(seq1); !ok {
+		t.Fatalf("Failed to remove seq %d", seq1)
+	}
+	if ok := f.Tombstone(seq1); ok {
+		t.Fatalf("Removed seq %d twice", seq1)
+	}
+	if _, ok := f.Get(seq1); ok {
+		t.Fatalf("Found removed seq %d", seq1)
+	}
+	if e, ok := f.Get(seq2); !ok || e.Key != 2 {
+		t.Fatalf("Failed to get seq %d", seq2)
+	}
+	if f.Len() != 2 {
+		t.Fatalf("Got Len %d instead of 2", f.Len())
+	}
+	f.Tombstone(seq0)
+	if e, seq, ok := f.Peek(); !ok || e.Key != 2 || seq != seq2 {
+		t.Fatalf("Peek returned %d instead of %d", e.Key, 2)
+	}
+	f.Remove()
+	if f.Len() != 0 {
+		t.Fatalf("FIFO is not empty %d", f.Len())
+	}
+	if ok := f.Tombstone(seq2); ok {
+		t.Fatalf("Removed seq %d which is not in the FIFO", seq2)
+	}
+
+	// A full FIFO with a tombstone on the head
+	for i := 0; i < 3; i++ {
+		f.Add(Entry{Key: uint64(i)})
+	}
+	_, seq, _ := f.Peek()
+	f.Tombstone(seq)
+	if _, ok := f.Add(Entry{Key: 3}); !ok {
+		t.Fatalf("Tombstone on the head blocks Add")
+	}
+}
+
+func TestPeekN(t *testing.T) {
+	f := New(4)
+	for i := 0; i < 4; i++ {
+		f.Add(Entry{Key: uint64(i)})
+	}
+	f.Remove()
+	f.Tombstone(2)
+	entries := f.PeekN(2, make([]Entry, 0, 4))
+	if len(entries) != 2 || entries[0].Key != 1 || entries[1].Key != 3 {
+		t.Fatalf("Got %v", entries)
+	}
+	entries = f.PeekN(4, entries[:0])
+	if len(entries) != 2 {
+		t.Fatalf("Got %d entries instead of 2", len(entries))
+	}
+}
+
+func TestRange(t *testing.T) {
+	f := New(4)
+	for i := 0; i < 4; i++ {
+		f.Add(Entry{Key: uint64(i)})
+	}
+	f.Remove()
+	f.Tombstone(2)
+	f.Add(Entry{Key: 4})
+	var keys []uint64
+	f.Range(func(seq uint32, e Entry) bool {
+		if e.Key != uint64(seq) {
+			t.Fatalf("Entry %d has seq %d", e.Key, seq)
+		}
+		keys = append(keys, e.Key)
+		return true
+	})
+	if len(keys) != 3 || keys[0] != 1 || keys[1] != 3 || keys[2] != 4 {
+		t.Fatalf("Bad keys %v", keys)
+	}
+	count := 0
+	f.Range(func(uint32, Entry) bool {
+		count++
+		return false
+	})
+	if count != 1 {
+		t.Fatalf("Range did not stop %d", count)
+	}
+}
+
+func TestAt(t *testing.T) {
+	f := New(4)
+	if _, _, ok := f.At(0); ok {
+		t.Fatalf("At() of an empty FIFO")
+	}
+	for i := 0; i < 4; i++ {
+		f.Add(Entry{Key: uint64(i)})
+	}
+	f.Remove()
+	f.Tombstone(2)
+	f.Add(Entry{Key: 4})
+	if seq, e, ok := f.At(0); !ok || seq != 1 || e.Key != 1 {
+		t.Fatalf("At(0) returned %d %d %v", seq, e.Key, ok)
+	}
+	if _, _, ok := f.At(1); ok {
+		t.Fatalf("At() returned a tombstone")
+	}
+	// Wraps around the occupied slots
+	if seq, e, ok := f.At(7); !ok || seq != 4 || e.Key != 4 {
+		t.Fatalf("At(7) returned %d %d %v", seq, e.Key, ok)
+	}
+}
+
+func TestGrow(t *testing.T) {
+	f := New(3)
+	f.Add(Entry{Key: 0})
+	f.Remove()
+	seq1, _ := f.Add(Entry{Key: 1})
+	seq2, _ := f.Add(Entry{Key: 2})
+	seq3, _ := f.Add(Entry{Key: 3})
+	if _, ok := f.Add(Entry{Key: 4}); ok {
+		t.Fatalf("Did not fail on overflow")
+	}
+	f.Grow(2)
+	if f.Size() != 5 {
+		t.Fatalf("Got Size %d instead of 5", f.Size())
+	}
+	seq4, ok := f.Add(Entry{Key: 4})
+	if !ok {
+		t.Fatalf("Failed to add after Grow")
+	}
+	for k, seq := range []uint32{seq1, seq2, seq3, seq4} {
+		if e, ok := f.Get(seq); !ok || e.Key != uint64(k+1) {
+			t.Fatalf("Got %d instead of %d", e.Key, k+1)
+		}
+	}
+	for k := 1; k <= 4; k++ {
+		if e, ok := f.Remove(); !ok || e.Key != uint64(k) {
+			t.Fatalf("Remove returned %d instead of %d", e.Key, k)
+		}
+	}
+}
+
+// FuzzFifo compares the FIFO with a slice
+func FuzzFifo(f *testing.F) {
+	f.Add([]byte{0, 0, 0, 1, 2, 0, 1})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		size := 5
+		q := New(size)
+		type modelEntry struct {
+			key  uint64
+			seq  uint32
+			dead bool
+		}
+		var model []*modelEntry
+		for i, b := range data {
+			switch b % 4 {
+			case 0:
+				seq, ok := q.Add(Entry{Key: uint64(i)})
+				if ok != (len(model) < size) {
+					t.Fatalf("Add returned %v, model size %d", ok, len(model))
+				}
+				if ok {
+					model = append(model, &modelEntry{key: uint64(i), seq: seq})
+				}
+			case 1, 2:
+				for len(model) > 0 && model[0].dead {
+					model = model[1:]
+				}
+				e, ok := q.Remove()
+				if ok != (len(model) > 0) || (ok && e.Key != model[0].key) {
+					t.Fatalf("Remove returned %d %v", e.Key, ok)
+				}
+				if ok {
+					model = model[1:]
+				}
+			case 3:
+				if len(model) == 0 {
+					continue
+				}
+				m := model[int(b/4)%len(model)]
+				if ok := q.Tombstone(m.seq); ok == m.dead {
+					t.Fatalf("Tombstone returned %v for dead=%v", ok, m.dead)
+				}
+				m.dead = true
+			}
+			count := 0
+			for _, m := range model {
+				if !m.dead {
+					count++
+				}
+			}
+			if q.Len() != count {
+				t.Fatalf("Len returned %d, model %d", q.Len(), count)
+			}
+		}
+	})
+}
+
+func BenchmarkAddRemove(b *testing.B) {
+	f := New(1024)
+	for i := 0; i < b.N; i++ {
+		f.Add(Entry{Key: uint64(i)})
+		f.Remove()
+	}
+}
@@ -0,0 +1,238 @@
+// Package minheap is an expiration queue ordered by the expiration time
+// The FIFO evicts the entries in the order of arrival which is correct only
+// if all entries have the same TTL. The heap is slower - O(log(n)) Add and
+// Remove - but evicts the entries in the order of expiration
+// The heap is not thread safe. The cache calls the API under a lock
+package minheap
+
+import (
+	"github.com/larytet/mcachego/internal/fifo"
+)
+
+// Heap keeps the same entries as the FIFO
+// Every added entry gets a 32 bits handle. The handle remains valid until
+// the entry is removed. The API mirrors the FIFO, the handle plays the role
+// of the FIFO sequence number
+// The low bits of the handle are the index of the slot, the high bits are
+// the generation of the slot. A slot is reused right after removal, the
+// generation makes the old handle of the slot invalid like the sequence
+// number of a FIFO entry. The cache indexes the side arrays by the low bits
+type Heap struct {
+	// Binary heap of the entries
+	entries []fifo.Entry
+	// Handle of the entry in the same position
+	handles []uint32
+	// Position of the entry by slot, -1 if the slot is free
+	positions []int32
+	// Stack of the last handles of the free slots
+	free []uint32
+	size int
+	// Mask of the slot bits, power of 2 minus 1
+	mask uint32
+}
+
+// New creates a heap which can keep 'size' entries
+func New(size int) *Heap {
+	h := &Heap{
+		entries:   make([]fifo.Entry, 0, size),
+		handles:   make([]uint32, 0, size),
+		positions: make([]int32, size, size),
+		free:      make([]uint32, size, size),
+		size:      size,
+	}
+	for h.mask < uint32(size-1) {
+		h.mask = h.mask<<1 | 1
+	}
+	for i := range h.positions {
+		h.positions[i] = -1
+		// Allocate low handles first
+		h.free[i] = uint32(size - 1 - i)
+	}
+	return h
+}
+
+// less compares expiration times. Wrap around of the time is fine
+func less(a, b fifo.Entry) bool {
+	return (a.ExpirationMs - b.ExpirationMs) < 0
+}
+
+func (h *Heap) swap(i, j int) {
+	h.entries[i], h.entries[j] = h.entries[j], h.entries[i]
+	h.handles[i], h.handles[j] = h.handles[j], h.handles[i]
+	h.positions[h.handles[i]&h.mask] = int32(i)
+	h.positions[h.handles[j]&h.mask] = int32(j)
+}
+
+func (h *Heap) up(i int) {
+	for i > 0 {
+		parent := (i - 1) / 2
+		if !less(h.entries[i], h.entries[parent]) {
+			break
+		}
+		h.swap(i, parent)
+		i = parent
+	}
+}
+
+func (h *Heap) down(i int) {
+	n := len(h.entries)
+	for {
+		smallest := i
+		left := 2*i + 1
+		right := left + 1
+		if left < n && less(h.entries[left], h.entries[smallest]) {
+			smallest = left
+		}
+		if right < n && less(h.entries[right], h.entries[smallest]) {
+			smallest = right
+		}
+		if smallest == i {
+			break
+		}
+		h.swap(i, smallest)
+		i = smallest
+	}
+}
+
+// Add inserts the entry
+// Returns the handle of the entry, false if the heap is full
+func (h *Heap) Add(e fifo.Entry) (handle uint32, ok bool) {
+	if len(h.free) == 0 {
+		return 0, false
+	}
+	// Next generation of the slot
+	handle = h.free[len(h.free)-1] + h.mask + 1
+	h.free = h.free[:len(h.free)-1]
+	pos := len(h.entries)
+	h.entries = append(h.entries, e)
+	h.handles = append(h.handles, handle)
+	h.positions[handle&h.mask] = int32(pos)
+	h.up(pos)
+	return handle, true
+}
+
+// removeAt removes the entry in the position
+func (h *Heap) removeAt(pos int) fifo.Entry {
+	e := h.entries[pos]
+	handle := h.handles[pos]
+	last := len(h.entries) - 1
+	if pos != last {
+		h.swap(pos, last)
+	}
+	h.entries = h.entries[:last]
+	h.handles = h.handles[:last]
+	h.positions[handle&h.mask] = -1
+	h.free = append(h.free, handle)
+	if pos != last {
+		h.down(pos)
+		h.up(pos)
+	}
+	return e
+}
+
+// Peek returns the entry with the earliest expiration time
+func (h *Heap) Peek() (e fifo.Entry, handle uint32, ok bool) {
+	if len(h.entries) == 0 {
+		return e, 0, false
+	}
+	return h.entries[0], h.handles[0], true
+}
+
+// PeekSkip returns the entry with the earliest expiration time among the
+// entries without any of the 'flags'. The forced eviction skips the entries
+// which can not be evicted
+// The search does not descend below a match, the cost depends on the number
+// of the entries with the flags
+func (h *Heap) PeekSkip(flags uint32) (e fifo.Entry, handle uint32, ok bool) {
+	best := h.peekSkip(0, flags, -1)
+	if best < 0 {
+		return e, 0, false
+	}
+	return h.entries[best], h.handles[best], true
+}
+
+// peekSkip returns the position of the match in the subtree or 'best'
+// The entries of the subtree do not expire before the root of the subtree
+func (h *Heap) peekSkip(pos int, flags uint32, best int) int {
+	if pos >= len(h.entries) {
+		return best
+	}
+	if best >= 0 && !less(h.entries[pos], h.entries[best]) {
+		return best
+	}
+	if h.entries[pos].Flags&flags == 0 {
+		return pos
+	}
+	best = h.peekSkip(2*pos+1, flags, best)
+	return h.peekSkip(2*pos+2, flags, best)
+}
+
+// Remove removes and returns the entry with the earliest expiration time
+func (h *Heap) Remove() (e fifo.Entry, ok bool) {
+	if len(h.entries) == 0 {
+		return e, false
+	}
+	return h.removeAt(0), true
+}
+
+// position returns the position of the entry by handle
+// Returns false if the slot is free or the handle is of an older generation
+func (h *Heap) position(handle uint32) (pos int, ok bool) {
+	slot := handle & h.mask
+	if uint64(slot) >= uint64(h.size) {
+		return 0, false
+	}
+	pos = int(h.positions[slot])
+	return pos, pos >= 0 && h.handles[pos] == handle
+}
+
+// Get returns the entry by handle
+func (h *Heap) Get(handle uint32) (e fifo.Entry, ok bool) {
+	pos, ok := h.position(handle)
+	if !ok {
+		return e, false
+	}
+	return h.entries[pos], true
+}
+
+// Tombstone removes the entry by handle
+// The name follows the FIFO API. Unlike the FIFO the heap removes the entry
+// immediately
+func (h *Heap) Tombstone(handle uint32) bool {
+	pos, ok := h.position(handle)
+	if !ok {
+		return false
+	}
+	h.removeAt(pos)
+	return true
+}
+
+// Range calls fn for every entry in the heap. The order is not defined
+// Range stops if fn returns false
+func (h *Heap) Range(fn func(handle uint32, e fifo.Entry) bool) {
+	for pos, e := range h.entries {
+		if !fn(h.handles[pos], e) {
+			return
+		}
+	}
+}
+
+// At returns the entry in the position 'i' modulo the number of entries
+// Returns false if the heap is empty
+func (h *Heap) At(i uint32) (handle uint32, e fifo.Entry, ok bool) {
+	if len(h.entries) == 0 {
+		return 0, e, false
+	}
+	pos := int(uint64(i) % uint64(len(h.entries)))
+	return h.handles[pos], h.entries[pos], true
+}
+
+// Len returns number of entries in the heap
+func (h *Heap) Len() int {
+	return len(h.entries)
+}
+
+// Size returns the capacity of the heap
+func (h *Heap) Size() int {
+	return h.size
+}
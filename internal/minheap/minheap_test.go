@@ -0,0 +1,165 @@
+package minheap
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/larytet/mcachego/internal/fifo"
+)
+
+func TestOrder(t *testing.T) {
+	size := 100
+	h := New(size)
+	for i := 0; i < size; i++ {
+		if _, ok := h.Add(fifo.Entry{Key: uint64(i), ExpirationMs: int32(rand.Intn(1000))}); !ok {
+			t.Fatalf("Failed to add %d", i)
+		}
+	}
+	if _, ok := h.Add(fifo.Entry{}); ok {
+		t.Fatalf("Did not fail on overflow")
+	}
+	last := int32(-1)
+	for i := 0; i < size; i++ {
+		e, ok := h.Remove()
+		if !ok {
+			t.Fatalf("Failed to remove %d", i)
+		}
+		if e.ExpirationMs < last {
+			t.Fatalf("Expiration %d after %d", e.ExpirationMs, last)
+		}
+		last = e.ExpirationMs
+	}
+	if h.Len() != 0 {
+		t.Fatalf("Heap is not empty %d", h.Len())
+	}
+}
+
+func TestTombstone(t *testing.T) {
+	h := New(3)
+	h0, _ := h.Add(fifo.Entry{Key: 0, ExpirationMs: 30})
+	h1, _ := h.Add(fifo.Entry{Key: 1, ExpirationMs: 10})
+	h2, _ := h.Add(fifo.Entry{Key: 2, ExpirationMs: 20})
+	if e, handle, ok := h.Peek(); !ok || e.Key != 1 || handle != h1 {
+		t.Fatalf("Peek returned %d instead of %d", e.Key, 1)
+	}
+	if ok := h.Tombstone(h1); !ok {
+		t.Fatalf("Failed to remove handle %d", h1)
+	}
+	if ok := h.Tombstone(h1); ok {
+		t.Fatalf("Removed handle %d twice", h1)
+	}
+	if e, ok := h.Get(h0); !ok || e.Key != 0 {
+		t.Fatalf("Failed to get handle %d", h0)
+	}
+	if e, handle, ok := h.Peek(); !ok || e.Key != 2 || handle != h2 {
+		t.Fatalf("Peek returned %d instead of %d", e.Key, 2)
+	}
+	if h.Len() != 2 {
+		t.Fatalf("Got Len %d instead of 2", h.Len())
+	}
+}
+
+func TestStaleHandle(t *testing.T) {
+	h := New(2)
+	h0, _ := h.Add(fifo.Entry{Key: 0, ExpirationMs: 10})
+	h.Tombstone(h0)
+	h1, _ := h.Add(fifo.Entry{Key: 1, ExpirationMs: 10})
+	if h0 == h1 {
+		t.Fatalf("Handle %d is reused", h0)
+	}
+	if _, ok := h.Get(h0); ok {
+		t.Fatalf("Got the entry by a stale handle %d", h0)
+	}
+	if ok := h.Tombstone(h0); ok {
+		t.Fatalf("Removed the entry by a stale handle %d", h0)
+	}
+	if e, ok := h.Get(h1); !ok || e.Key != 1 {
+		t.Fatalf("Failed to get handle %d", h1)
+	}
+}
+
+func TestRange(t *testing.T) {
+	h := New(4)
+	handles := make(map[uint32]uint64)
+	for i := 0; i < 4; i++ {
+		handle, _ := h.Add(fifo.Entry{Key: uint64(i), ExpirationMs: int32(10 - i)})
+		handles[handle] = uint64(i)
+	}
+	h.Remove()
+	count := 0
+	h.Range(func(handle uint32, e fifo.Entry) bool {
+		if handles[handle] != e.Key {
+			t.Fatalf("Handle %d points to %d instead of %d", handle, e.Key, handles[handle])
+		}
+		count++
+		return true
+	})
+	if count != 3 {
+		t.Fatalf("Got %d entries instead of 3", count)
+	}
+}
+
+func TestPeekSkip(t *testing.T) {
+	h := New(8)
+	for i := 0; i < 8; i++ {
+		// Every third entry is without the flag
+		flags := uint32(1)
+		if i%3 == 2 {
+			flags = 0
+		}
+		h.Add(fifo.Entry{Key: uint64(i), ExpirationMs: int32(i), Flags: flags})
+	}
+	for _, expected := range []uint64{2, 5} {
+		e, handle, ok := h.PeekSkip(1)
+		if !ok || e.Key != expected {
+			t.Fatalf("PeekSkip returned %v %v instead of %d", e, ok, expected)
+		}
+		h.Tombstone(handle)
+	}
+	if _, _, ok := h.PeekSkip(1); ok {
+		t.Fatalf("PeekSkip found an entry with the flag")
+	}
+	if e, _, ok := h.PeekSkip(2); !ok || e.Key != 0 {
+		t.Fatalf("PeekSkip returned %v %v instead of 0", e, ok)
+	}
+}
+
+func TestAt(t *testing.T) {
+	h := New(4)
+	if _, _, ok := h.At(0); ok {
+		t.Fatalf("At() of an empty heap")
+	}
+	for i := 0; i < 3; i++ {
+		h.Add(fifo.Entry{Key: uint64(i), ExpirationMs: int32(10 - i)})
+	}
+	for i := uint32(0); i < 6; i++ {
+		handle, e, ok := h.At(i)
+		if !ok {
+			t.Fatalf("At(%d) failed", i)
+		}
+		if g, _ := h.Get(handle); g != e {
+			t.Fatalf("At(%d) returned handle %d of %v instead of %v", i, handle, g, e)
+		}
+	}
+}
+
+func TestWrapAround(t *testing.T) {
+	h := New(2)
+	h.Add(fifo.Entry{Key: 0, ExpirationMs: -2147483647})
+	h.Add(fifo.Entry{Key: 1, ExpirationMs: 2147483647})
+	if e, _, _ := h.Peek(); e.Key != 1 {
+		t.Fatalf("Peek returned %d instead of %d", e.Key, 1)
+	}
+}
+
+func BenchmarkAddRemove(b *testing.B) {
+	h := New(1024)
+	for i := 0; i < 512; i++ {
+		h.Add(fifo.Entry{Key: uint64(i), ExpirationMs: int32(rand.Intn(1000))})
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		h.Add(fifo.Entry{Key: uint64(i), ExpirationMs: int32(i)})
+		h.Remove()
+	}
+}
@@ -0,0 +1,353 @@
+// Package wal is a write-ahead log of the cache operations
+// The log is a sequence of segment files in a directory. The writer appends
+// fixed size records to a buffer in memory, a background goroutine writes
+// the buffer to the current segment and calls fsync() - group commit
+// Store() pays for a copy of 24 bytes under a mutex, not for a system call
+// A crash loses at most the last sync interval
+package wal
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Op is the type of the record
+type Op uint8
+
+const (
+	OpStore Op = 1 + iota
+	OpDelete
+	// OpReset removes all entries
+	OpReset
+	// OpTime maps the monotonic time of the process to the wall clock
+	// Key is Unix time ms, ExpirationMs is the monotonic time
+	// Every segment starts with a time record
+	OpTime
+)
+
+// RecordSize is the size of the encoded record
+//
+//	key uint64, object uint32, expiration int32, flags uint16, op uint8,
+//	reserved uint8, crc32 uint32
+const RecordSize = 24
+
+// Record is a single cache operation
+type Record struct {
+	Key          uint64
+	Object       uint32
+	ExpirationMs int32
+	Flags        uint16
+	Op           Op
+}
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// Encode writes the record to the buffer of at least RecordSize bytes
+func (r *Record) Encode(b []byte) {
+	binary.LittleEndian.PutUint64(b[0:], r.Key)
+	binary.LittleEndian.PutUint32(b[8:], r.Object)
+	binary.LittleEndian.PutUint32(b[12:], uint32(r.ExpirationMs))
+	binary.LittleEndian.PutUint16(b[16:], r.Flags)
+	b[18] = byte(r.Op)
+	b[19] = 0
+	binary.LittleEndian.PutUint32(b[20:], crc32.Checksum(b[:20], crcTable))
+}
+
+// Decode returns false if the checksum does not match
+func (r *Record) Decode(b []byte) bool {
+	if crc32.Checksum(b[:20], crcTable) != binary.LittleEndian.Uint32(b[20:]) {
+		return false
+	}
+	r.Key = binary.LittleEndian.Uint64(b[0:])
+	r.Object = binary.LittleEndian.Uint32(b[8:])
+	r.ExpirationMs = int32(binary.LittleEndian.Uint32(b[12:]))
+	r.Flags = binary.LittleEndian.Uint16(b[16:])
+	r.Op = Op(b[18])
+	return r.Op >= OpStore && r.Op <= OpTime
+}
+
+// Configuration of the log
+type Configuration struct {
+	Dir string
+	// Rotate the segment after SegmentSize bytes, 64MB by default
+	SegmentSize int64
+	// Group commit interval, 10ms by default
+	SyncInterval time.Duration
+	// TimeBase returns the record written at the beginning of every segment
+	TimeBase func() Record
+	// OnError is called from the background goroutine, can be nil
+	OnError func(err error)
+}
+
+// Log is the writer side of the write-ahead log
+type Log struct {
+	configuration Configuration
+	// Protects the buffers
+	mutex   sync.Mutex
+	pending []byte
+	spare   []byte
+	// Protects the segment file
+	fileMutex sync.Mutex
+	file      *os.File
+	segment   uint64
+	written   int64
+	err       error
+	stop      chan struct{}
+	done      chan struct{}
+}
+
+const segmentSuffix = ".wal"
+
+// SegmentName returns the file name of the segment
+func SegmentName(segment uint64) string {
+	return fmt.Sprintf("%016x%s", segment, segmentSuffix)
+}
+
+// Segments returns the segment numbers in the directory in ascending order
+func Segments(dir string) ([]uint64, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var segments []uint64
+	for _, f := range files {
+		name := f.Name()
+		if !strings.HasSuffix(name, segmentSuffix) {
+			continue
+		}
+		segment, err := strconv.ParseUint(strings.TrimSuffix(name, segmentSuffix), 16, 64)
+		if err != nil {
+			continue
+		}
+		segments = append(segments, segment)
+	}
+	sort.Slice(segments, func(i, j int) bool { return segments[i] < segments[j] })
+	return segments, nil
+}
+
+// Open starts a new segment after the existing segments in the directory
+func Open(configuration Configuration) (*Log, error) {
+	if configuration.SegmentSize == 0 {
+		configuration.SegmentSize = 64 * 1024 * 1024
+	}
+	if configuration.SyncInterval == 0 {
+		configuration.SyncInterval = 10 * time.Millisecond
+	}
+	if err := os.MkdirAll(configuration.Dir, 0755); err != nil {
+		return nil, err
+	}
+	segments, err := Segments(configuration.Dir)
+	if err != nil {
+		return nil, err
+	}
+	l := &Log{
+		configuration: configuration,
+		pending:       make([]byte, 0, 64*1024),
+		spare:         make([]byte, 0, 64*1024),
+		stop:          make(chan struct{}),
+		done:          make(chan struct{}),
+	}
+	if len(segments) > 0 {
+		l.segment = segments[len(segments)-1]
+	}
+	if err := l.openSegment(l.segment + 1); err != nil {
+		return nil, err
+	}
+	go l.run()
+	return l, nil
+}
+
+// openSegment creates a new segment and writes the time record
+// Called with the fileMutex locked
+func (l *Log) openSegment(segment uint64) error {
+	name := filepath.Join(l.configuration.Dir, SegmentName(segment))
+	file, err := os.OpenFile(name, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	l.file = file
+	l.segment = segment
+	l.written = 0
+	if l.configuration.TimeBase != nil {
+		var b [RecordSize]byte
+		r := l.configuration.TimeBase()
+		r.Encode(b[:])
+		n, err := l.file.Write(b[:])
+		l.written += int64(n)
+		return err
+	}
+	return nil
+}
+
+// Append adds the record to the pending buffer
+// The record is on the disk after the next sync
+func (l *Log) Append(r Record) {
+	l.mutex.Lock()
+	n := len(l.pending)
+	if n+RecordSize > cap(l.pending) {
+		// The disk is slower than the cache. I do not drop the records
+		pending := make([]byte, n, 2*cap(l.pending))
+		copy(pending, l.pending)
+		l.pending = pending
+	}
+	l.pending = l.pending[:n+RecordSize]
+	r.Encode(l.pending[n:])
+	l.mutex.Unlock()
+}
+
+func (l *Log) run() {
+	ticker := time.NewTicker(l.configuration.SyncInterval)
+	defer ticker.Stop()
+	defer close(l.done)
+	for {
+		select {
+		case <-ticker.C:
+			if err := l.Sync(); err != nil && l.configuration.OnError != nil {
+				l.configuration.OnError(err)
+			}
+		case <-l.stop:
+			return
+		}
+	}
+}
+
+// flush writes the pending records to the segment
+// Called with the fileMutex locked
+func (l *Log) flush() error {
+	l.mutex.Lock()
+	buf := l.pending
+	l.pending = l.spare[:0]
+	l.mutex.Unlock()
+	l.spare = buf
+	if len(buf) == 0 || l.err != nil {
+		return l.err
+	}
+	n, err := l.file.Write(buf)
+	l.written += int64(n)
+	if err == nil {
+		err = l.file.Sync()
+	}
+	// A failed write leaves a hole in the log. I stop writing
+	l.err = err
+	return err
+}
+
+// Sync writes the pending records and rotates the segment if needed
+func (l *Log) Sync() error {
+	l.fileMutex.Lock()
+	defer l.fileMutex.Unlock()
+	if err := l.flush(); err != nil {
+		return err
+	}
+	if l.written >= l.configuration.SegmentSize {
+		return l.rotate()
+	}
+	return nil
+}
+
+// rotate is called with the fileMutex locked
+func (l *Log) rotate() error {
+	if err := l.flush(); err != nil {
+		return err
+	}
+	if err := l.file.Close(); err != nil {
+		l.err = err
+		return err
+	}
+	l.err = l.openSegment(l.segment + 1)
+	return l.err
+}
+
+// Rotate starts a new segment and returns the segment number
+// All records appended before the call are in the previous segments
+func (l *Log) Rotate() (segment uint64, err error) {
+	l.fileMutex.Lock()
+	defer l.fileMutex.Unlock()
+	err = l.rotate()
+	return l.segment, err
+}
+
+// Close stops the background goroutine, writes the pending records and
+// closes the segment
+func (l *Log) Close() error {
+	close(l.stop)
+	<-l.done
+	l.fileMutex.Lock()
+	defer l.fileMutex.Unlock()
+	err := l.flush()
+	if cerr := l.file.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// RemoveSegments deletes the segments older than 'segment'
+func RemoveSegments(dir string, segment uint64) error {
+	segments, err := Segments(dir)
+	if err != nil {
+		return err
+	}
+	for _, s := range segments {
+		if s >= segment {
+			break
+		}
+		if err := os.Remove(filepath.Join(dir, SegmentName(s))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Replay calls fn for every record in the segments starting from 'segment'
+// A crash in the middle of a write leaves a torn record at the end of the
+// segment. The next process starts a new segment, and the torn record is not
+// necessarily in the last segment. I stop replaying the segment at the first
+// bad record
+func Replay(dir string, segment uint64, fn func(r Record)) error {
+	segments, err := Segments(dir)
+	if err != nil {
+		return err
+	}
+	for _, s := range segments {
+		if s < segment {
+			continue
+		}
+		if err := replaySegment(filepath.Join(dir, SegmentName(s)), fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func replaySegment(name string, fn func(r Record)) error {
+	file, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	var b [RecordSize]byte
+	var r Record
+	for {
+		_, err := io.ReadFull(file, b[:])
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("wal: %s: %w", name, err)
+		}
+		if !r.Decode(b[:]) {
+			return nil
+		}
+		fn(r)
+	}
+}
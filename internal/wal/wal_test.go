@@ -0,0 +1,125 @@
+package wal
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "wal")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	return dir
+}
+
+func TestRecord(t *testing.T) {
+	r := Record{Key: 1 << 60, Object: 7, ExpirationMs: -5, Flags: 3, Op: OpStore}
+	var b [RecordSize]byte
+	r.Encode(b[:])
+	var decoded Record
+	if !decoded.Decode(b[:]) || decoded != r {
+		t.Fatalf("Got %v instead of %v", decoded, r)
+	}
+	b[3] ^= 1
+	if decoded.Decode(b[:]) {
+		t.Fatalf("Corruption is not detected")
+	}
+}
+
+func replay(t *testing.T, dir string, segment uint64) []Record {
+	var records []Record
+	if err := Replay(dir, segment, func(r Record) { records = append(records, r) }); err != nil {
+		t.Fatalf("%v", err)
+	}
+	return records
+}
+
+func TestAppendReplay(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	l, err := Open(Configuration{Dir: dir, SyncInterval: time.Millisecond,
+		TimeBase: func() Record { return Record{Op: OpTime, Key: 100} }})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	for i := 0; i < 100; i++ {
+		l.Append(Record{Op: OpStore, Key: uint64(i)})
+	}
+	if err := l.Close(); err != nil {
+		t.Fatalf("%v", err)
+	}
+	records := replay(t, dir, 0)
+	if len(records) != 101 || records[0].Op != OpTime {
+		t.Fatalf("Got %d records, first %v", len(records), records[0])
+	}
+	for i, r := range records[1:] {
+		if r.Key != uint64(i) || r.Op != OpStore {
+			t.Fatalf("Got %v instead of key %d", r, i)
+		}
+	}
+
+	// The second Open() starts a new segment
+	l, _ = Open(Configuration{Dir: dir})
+	l.Append(Record{Op: OpDelete, Key: 1})
+	l.Close()
+	segments, _ := Segments(dir)
+	if len(segments) != 2 || segments[0] != 1 || segments[1] != 2 {
+		t.Fatalf("Bad segments %v", segments)
+	}
+	records = replay(t, dir, 2)
+	if len(records) != 1 || records[0].Op != OpDelete {
+		t.Fatalf("Bad records %v", records)
+	}
+}
+
+func TestRotate(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	l, _ := Open(Configuration{Dir: dir, SegmentSize: 10 * RecordSize, SyncInterval: time.Hour})
+	for i := 0; i < 25; i++ {
+		l.Append(Record{Op: OpStore, Key: uint64(i)})
+		if i%10 == 9 {
+			l.Sync()
+		}
+	}
+	segment, err := l.Rotate()
+	if err != nil || segment != 4 {
+		t.Fatalf("Rotate returned %d %v", segment, err)
+	}
+	l.Append(Record{Op: OpStore, Key: 25})
+	l.Close()
+	if records := replay(t, dir, 0); len(records) != 26 {
+		t.Fatalf("Got %d records instead of 26", len(records))
+	}
+	if records := replay(t, dir, segment); len(records) != 1 || records[0].Key != 25 {
+		t.Fatalf("Bad records %v", records)
+	}
+	RemoveSegments(dir, segment)
+	if segments, _ := Segments(dir); len(segments) != 1 || segments[0] != segment {
+		t.Fatalf("Bad segments %v", segments)
+	}
+}
+
+func TestTornRecord(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	l, _ := Open(Configuration{Dir: dir})
+	l.Append(Record{Op: OpStore, Key: 1})
+	l.Append(Record{Op: OpStore, Key: 2})
+	l.Close()
+	name := filepath.Join(dir, SegmentName(1))
+	data, _ := ioutil.ReadFile(name)
+	// Crash in the middle of the second record
+	ioutil.WriteFile(name, data[:RecordSize+RecordSize/2], 0644)
+	l, _ = Open(Configuration{Dir: dir})
+	l.Append(Record{Op: OpStore, Key: 3})
+	l.Close()
+	records := replay(t, dir, 0)
+	if len(records) != 2 || records[0].Key != 1 || records[1].Key != 3 {
+		t.Fatalf("Bad records %v", records)
+	}
+}
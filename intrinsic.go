@@ -0,0 +1,93 @@
+package mcache
+
+import (
+	"fmt"
+	"reflect"
+	"sync/atomic"
+	"unsafe"
+
+	"github.com/larytet/mcachego/internal/fifo"
+)
+
+// The comment in StoreWithFlags() asks for the expiration time in the
+// user structure. With Configuration.IntrinsicItem the first 8 bytes of
+// every pool object are the ItemHeader. The cache writes the expiration
+// time and the flags there and the eviction queue keeps only the key
+// Evict() can not check the head of the queue without the object and locks
+// the shard of the head in every call. ExpirationOf() reads the object and
+// does not lock the eviction queue
+// The hashtable keeps the item: a uintptr cast of 8 bytes does not cost
+// a copy, the sequence number stays in the item
+// The heap orders the entries by the expiration time in the queue and
+// does not support the intrinsic mode
+
+// ItemHeader is the first field of the PoolTemplate type in the intrinsic
+// mode. The application does not write the header
+type ItemHeader struct {
+	ExpirationMs int32
+	Flags        uint32
+}
+
+// checkIntrinsic returns an error if the configuration does not support the
+// intrinsic mode
+func checkIntrinsic(configuration Configuration) error {
+	if configuration.ExpiryIndex != ExpiryIndexFIFO {
+		return fmt.Errorf("mcache: IntrinsicItem requires ExpiryIndexFIFO")
+	}
+	t := configuration.PoolTemplate
+	if t == nil || t.Kind() != reflect.Ptr || t.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("mcache: IntrinsicItem requires PoolTemplate of a pointer to a struct")
+	}
+	s := t.Elem()
+	if s.NumField() == 0 || s.Field(0).Type != reflect.TypeOf(ItemHeader{}) {
+		return fmt.Errorf("mcache: the first field of %v is not ItemHeader", s)
+	}
+	return nil
+}
+
+// queueEntry returns the entry of the eviction queue
+func (c *Cache) queueEntry(e fifo.Entry) fifo.Entry {
+	if c.configuration.IntrinsicItem {
+		return fifo.Entry{Key: e.Key}
+	}
+	return e
+}
+
+// getHeader returns the queue entry of the key with the expiration time
+// and the flags of the object. Called with the shard locked
+func (c *Cache) getHeader(shard *shard, o Object, key uint64) fifo.Entry {
+	h := (*ItemHeader)(objectPointer(shard, o))
+	return fifo.Entry{Key: key, ExpirationMs: atomic.LoadInt32(&h.ExpirationMs), Flags: atomic.LoadUint32(&h.Flags)}
+}
+
+// setHeader is called with the shard locked
+func (c *Cache) setHeader(shard *shard, o Object, e fifo.Entry) {
+	h := (*ItemHeader)(objectPointer(shard, o))
+	atomic.StoreInt32(&h.ExpirationMs, e.ExpirationMs)
+	atomic.StoreUint32(&h.Flags, e.Flags)
+}
+
+// ExpirationOf returns the expiration time of the entry
+// In the intrinsic mode I read the header of the object under the shard read
+// lock, otherwise I look the entry up in the eviction queue
+func (c *Cache) ExpirationOf(key uint64) (expirationMs TimeMs, ok bool) {
+	if c.isClosed() {
+		return 0, false
+	}
+	hash := c.hash(key)
+	shard, _ := c.shardOf(hash)
+	c.rlock(shard)
+	iValue, ok, _ := shard.table.Load(key, hash)
+	i := *(*item)(unsafe.Pointer(&iValue))
+	if ok && c.configuration.IntrinsicItem {
+		expirationMs = TimeMs(c.getHeader(shard, i.o, key).ExpirationMs)
+	} else if ok {
+		c.lockQueue()
+		var e fifo.Entry
+		e, ok = c.queue.Get(i.fifoSeq)
+		c.unlockQueue()
+		expirationMs = TimeMs(e.ExpirationMs)
+	}
+	c.runlock(shard)
+	return expirationMs, ok
+}
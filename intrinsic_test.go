@@ -0,0 +1,90 @@
+package mcache
+
+import (
+	"reflect"
+	"testing"
+	"unsafe"
+)
+
+type intrinsicData struct {
+	ItemHeader
+	value uint64
+}
+
+func TestIntrinsicItem(t *testing.T) {
+	cache := New(Configuration{Size: 4, TTL: 10, IntrinsicItem: true,
+		PoolTemplate: reflect.TypeOf(new(intrinsicData))})
+	if !cache.StoreNew(1, 5, func(p unsafe.Pointer) { (*intrinsicData)(p).value = 1 }) {
+		t.Fatalf("Failed to store")
+	}
+	if expirationMs, ok := cache.ExpirationOf(1); !ok || expirationMs != 15 {
+		t.Fatalf("Got expiration %d instead of 15", expirationMs)
+	}
+	o, _, _ := cache.Load(1)
+	d := (*intrinsicData)(cache.Pointer(1, o))
+	if d.ExpirationMs != 15 || d.value != 1 {
+		t.Fatalf("Bad object %+v", *d)
+	}
+	if _, ok := cache.ExpirationOf(2); ok {
+		t.Fatalf("Found a missing key")
+	}
+	if e, _, _ := cache.queue.Peek(); e.Key != 1 || e.ExpirationMs != 0 {
+		t.Fatalf("The queue keeps the expiration time %+v", e)
+	}
+	if _, ok := cache.Evict(14, false); ok {
+		t.Fatalf("Evicted before the expiration")
+	}
+	if _, ok := cache.Evict(15, false); !ok {
+		t.Fatalf("Failed to evict")
+	}
+	if cache.Len() != 0 {
+		t.Fatalf("Got Len %d instead of 0", cache.Len())
+	}
+}
+
+func TestExpirationOf(t *testing.T) {
+	cache := New(Configuration{Size: 4, TTL: 10})
+	cache.Store(1, 1, 5)
+	if expirationMs, ok := cache.ExpirationOf(1); !ok || expirationMs != 15 {
+		t.Fatalf("Got expiration %d instead of 15", expirationMs)
+	}
+}
+
+func TestIntrinsicImport(t *testing.T) {
+	src := New(Configuration{Size: 4, TTL: 10, IntrinsicItem: true,
+		PoolTemplate: reflect.TypeOf(new(intrinsicData))})
+	init := func(p unsafe.Pointer) {}
+	src.StoreNew(1, 5, init)
+	src.StoreNew(2, 20, init)
+	// The queue of the source keeps only the keys
+	dst := New(Configuration{Size: 4, TTL: 10})
+	if rejected := dst.ImportFrom(src, 16); len(rejected) != 0 {
+		t.Fatalf("Rejected %v", rejected)
+	}
+	if dst.Len() != 1 {
+		t.Fatalf("Got Len %d instead of 1", dst.Len())
+	}
+	if expirationMs, ok := dst.ExpirationOf(2); !ok || expirationMs != 30 {
+		t.Fatalf("Got expiration %d %v instead of 30", expirationMs, ok)
+	}
+}
+
+func TestIntrinsicItemTemplate(t *testing.T) {
+	type data struct{ value uint64 }
+	defer func() {
+		if recover() == nil {
+			t.Fatalf("Accepted a type without the header")
+		}
+	}()
+	New(Configuration{Size: 4, TTL: 10, IntrinsicItem: true, PoolTemplate: reflect.TypeOf(new(data))})
+}
+
+func TestIntrinsicItemHeap(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Fatalf("Accepted the heap")
+		}
+	}()
+	New(Configuration{Size: 4, TTL: 10, IntrinsicItem: true, ExpiryIndex: ExpiryIndexHeap,
+		PoolTemplate: reflect.TypeOf(new(intrinsicData))})
+}
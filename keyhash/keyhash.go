@@ -0,0 +1,116 @@
+// Package keyhash provides fast hash functions for the cache keys
+// The hashtable and the shard selection need all 64 bits of the hash to be
+// random. Sequential integer keys (IDs, counters, IP addresses) are
+// catastrophic if used as is
+package keyhash
+
+import (
+	"math/bits"
+	"unsafe"
+)
+
+// Uint64 is the splitmix64 finalizer
+// See http://xoshiro.di.unimi.it/splitmix64.c
+// Every bit of the input affects every bit of the output. The function is a
+// bijection - different keys never collide. 3ns on x86
+func Uint64(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+// wyhash constants
+const (
+	p0 = 0xa0761d6478bd642f
+	p1 = 0xe7037ed1a0b428db
+	p2 = 0x8ebc6af09c88c6e3
+	p3 = 0x589965cc75374cc3
+)
+
+func mix(a, b uint64) uint64 {
+	hi, lo := bits.Mul64(a, b)
+	return hi ^ lo
+}
+
+// I read the string byte by byte. The compiler merges the loads
+func r8(s string, i int) uint64 {
+	_ = s[i+7]
+	return uint64(s[i]) | uint64(s[i+1])<<8 | uint64(s[i+2])<<16 | uint64(s[i+3])<<24 |
+		uint64(s[i+4])<<32 | uint64(s[i+5])<<40 | uint64(s[i+6])<<48 | uint64(s[i+7])<<56
+}
+
+func r4(s string, i int) uint64 {
+	_ = s[i+3]
+	return uint64(s[i]) | uint64(s[i+1])<<8 | uint64(s[i+2])<<16 | uint64(s[i+3])<<24
+}
+
+// String returns 64 bits hash of the string
+// This is a wyhash style function, see https://github.com/wangyi-fudan/wyhash
+// It is not bit compatible with the reference implementation. I do not need
+// compatibility, I need no dependencies and no allocations
+// Domain names (10-30 bytes) hash in 5-8ns
+func String(s string) uint64 {
+	return StringWithSeed(s, 0)
+}
+
+// StringWithSeed returns 64 bits hash of the string
+func StringWithSeed(s string, seed uint64) uint64 {
+	length := len(s)
+	seed ^= mix(seed^p0, p1)
+	var a, b uint64
+	if length <= 16 {
+		if length >= 4 {
+			shift := (length >> 3) << 2
+			a = r4(s, 0)<<32 | r4(s, shift)
+			b = r4(s, length-4)<<32 | r4(s, length-4-shift)
+		} else if length > 0 {
+			a = uint64(s[0])<<16 | uint64(s[length>>1])<<8 | uint64(s[length-1])
+		}
+	} else {
+		i, p := length, 0
+		if i > 48 {
+			see1, see2 := seed, seed
+			for i > 48 {
+				seed = mix(r8(s, p)^p1, r8(s, p+8)^seed)
+				see1 = mix(r8(s, p+16)^p2, r8(s, p+24)^see1)
+				see2 = mix(r8(s, p+32)^p3, r8(s, p+40)^see2)
+				p += 48
+				i -= 48
+			}
+			seed ^= see1 ^ see2
+		}
+		for i > 16 {
+			seed = mix(r8(s, p)^p1, r8(s, p+8)^seed)
+			p += 16
+			i -= 16
+		}
+		a = r8(s, p+i-16)
+		b = r8(s, p+i-8)
+	}
+	hi, lo := bits.Mul64(a^p1, b^seed)
+	return mix(lo^p0^uint64(length), hi^p1)
+}
+
+// Bytes returns the same hash as String() for the same data
+// No allocations - I convert the slice to a string without copying
+func Bytes(b []byte) uint64 {
+	return String(*(*string)(unsafe.Pointer(&b)))
+}
+
+// fingerprintSeed makes the fingerprint independent of String()
+const fingerprintSeed = 0x9e3779b97f4a7c15
+
+// Fingerprint returns 32 bits hash of the string, independent of String()
+// Two strings with the same String() have different fingerprints with
+// probability 1-2^-32. The fingerprint is never zero
+func Fingerprint(s string) uint32 {
+	h := StringWithSeed(s, fingerprintSeed)
+	fp := uint32(h) ^ uint32(h>>32)
+	if fp == 0 {
+		fp = 1
+	}
+	return fp
+}
@@ -0,0 +1,83 @@
+package keyhash
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestUint64Distribution(t *testing.T) {
+	buckets := make([]int, 64)
+	count := 64 * 1024
+	for i := 0; i < count; i++ {
+		// Low bits of the sequential keys
+		buckets[Uint64(uint64(i))&63]++
+	}
+	for i, b := range buckets {
+		if b < count/64/2 || b > 2*count/64 {
+			t.Fatalf("Bucket %d has %d entries, expected %d", i, b, count/64)
+		}
+	}
+}
+
+func TestString(t *testing.T) {
+	seen := make(map[uint64]string)
+	for i := 0; i < 64*1024; i++ {
+		// All code paths: short, 4-16, 17-48 and long strings
+		s := fmt.Sprintf("%0*d.com.", i%70, i)
+		h := String(s)
+		if h != Bytes([]byte(s)) {
+			t.Fatalf("String and Bytes differ for %s", s)
+		}
+		if prev, ok := seen[h]; ok {
+			t.Fatalf("Collision %s %s", prev, s)
+		}
+		seen[h] = s
+	}
+	if String("") == String("a") {
+		t.Fatalf("Collision for short strings")
+	}
+	if StringWithSeed("google.com.", 1) == String("google.com.") {
+		t.Fatalf("Seed is ignored")
+	}
+}
+
+func TestBytesAllocs(t *testing.T) {
+	b := []byte("google.com.")
+	allocs := testing.AllocsPerRun(100, func() {
+		Bytes(b)
+	})
+	if allocs != 0 {
+		t.Fatalf("Bytes allocates %v", allocs)
+	}
+}
+
+var sum uint64
+
+func BenchmarkUint64(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		sum += Uint64(uint64(i))
+	}
+}
+
+func BenchmarkString(b *testing.B) {
+	s := "google.com."
+	for i := 0; i < b.N; i++ {
+		sum += String(s)
+	}
+}
+
+func TestFingerprint(t *testing.T) {
+	collisions := 0
+	for i := 0; i < 64*1024; i++ {
+		s := fmt.Sprintf("%d.com.", i)
+		if Fingerprint(s) == 0 {
+			t.Fatalf("Zero fingerprint for %s", s)
+		}
+		if Fingerprint(s) == Fingerprint(s+"x") {
+			collisions++
+		}
+	}
+	if collisions > 1 {
+		t.Fatalf("Got %d collisions", collisions)
+	}
+}
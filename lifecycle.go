@@ -0,0 +1,82 @@
+package mcache
+
+import (
+	"context"
+	"errors"
+	"runtime/pprof"
+	"sync/atomic"
+)
+
+// ErrClosed is returned by the API calls after Close()
+var ErrClosed = errors.New("mcache: cache is closed")
+
+// isClosed costs a load from memory. Store() checks the flag under the
+// shard lock, Close() waits for the in-flight Store() calls
+func (c *Cache) isClosed() bool {
+	return atomic.LoadInt32(&c.closed) != 0
+}
+
+// do calls f with the profiler labels of the cache. The goroutines started
+// by f inherit the labels. A CPU profile of a service with several caches
+// shows the goroutines of every cache: "mcache" is Configuration.Name, and
+// "mcache.worker" is the goroutine
+func (c *Cache) do(worker string, f func()) {
+	labels := pprof.Labels("mcache", c.configuration.Name, "mcache.worker", worker)
+	pprof.Do(context.Background(), labels, func(context.Context) {
+		f()
+	})
+}
+
+// startWorker registers a goroutine which Close() stops and waits for
+// The goroutine calls goroutines.Done() and exits when c.stop is closed
+// Returns false if the cache is closed
+func (c *Cache) startWorker() bool {
+	c.goroutinesMutex.Lock()
+	defer c.goroutinesMutex.Unlock()
+	if c.isClosed() {
+		return false
+	}
+	c.goroutines.Add(1)
+	return true
+}
+
+// Close stops the goroutines owned by the cache, writes the pending
+// records of the write-ahead log and the pending writes of the backend
+// Close() waits for the goroutines of StartSelfCheck() and for
+// MemoryMonitor.Run()
+// After Close() Store() fails, Load() and Evict() find nothing, Prefetch()
+// does not touch the tables
+// If the context expires before the log is written Close() returns the
+// context error, the log and the backend complete in the background
+// The second call returns ErrClosed
+func (c *Cache) Close(ctx context.Context) error {
+	if !atomic.CompareAndSwapInt32(&c.closed, 0, 1) {
+		return ErrClosed
+	}
+	// Wait for the Store() calls which did not see the flag
+	for _, shard := range c.shards {
+		c.lock(shard)
+		c.unlock(shard)
+	}
+	c.goroutinesMutex.Lock()
+	close(c.stop)
+	c.goroutinesMutex.Unlock()
+	done := make(chan error, 1)
+	go func() {
+		c.goroutines.Wait()
+		if c.backend != nil {
+			c.backend.close()
+		}
+		var err error
+		if c.wal != nil {
+			err = c.wal.Close()
+		}
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,100 @@
+package mcache
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"runtime/pprof"
+	"testing"
+	"time"
+)
+
+func TestClose(t *testing.T) {
+	c := New(Configuration{Size: 10, TTL: 10})
+	now := GetTime()
+	c.Store(1, 1, now)
+	if err := c.Close(context.Background()); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if c.Store(2, 2, now) {
+		t.Fatalf("Store succeeded after Close")
+	}
+	if _, _, ok := c.Load(1); ok {
+		t.Fatalf("Load succeeded after Close")
+	}
+	if _, expired := c.Evict(now+100, true); expired {
+		t.Fatalf("Evict succeeded after Close")
+	}
+	// Prefetch() does not lock the shards
+	for _, shard := range c.shards {
+		shard.mutex.Lock()
+		defer shard.mutex.Unlock()
+	}
+	done := make(chan struct{})
+	go func() {
+		c.Prefetch([]uint64{1, 2, 3})
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("Prefetch locked a shard after Close")
+	}
+	if err := c.Close(context.Background()); err != ErrClosed {
+		t.Fatalf("Got %v instead of %v", err, ErrClosed)
+	}
+}
+
+func TestCloseWAL(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "mcache")
+	defer os.RemoveAll(dir)
+	c := openTemp(t, dir)
+	c.Store(1, 1, GetTime())
+	if err := c.Close(context.Background()); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if err := c.Compact(); err != ErrClosed {
+		t.Fatalf("Got %v instead of %v", err, ErrClosed)
+	}
+}
+
+func TestCloseGoroutines(t *testing.T) {
+	c := New(Configuration{Size: 10, TTL: 10})
+	c.StartSelfCheck(context.Background(), time.Hour, nil)
+	m, _ := NewMemoryMonitor(c, MemoryConfiguration{Limit: 100,
+		Usage: func() (uint64, error) { return 0, nil }})
+	done := make(chan struct{})
+	go func() {
+		m.Run(context.Background(), time.Hour)
+		close(done)
+	}()
+	// Let Run() start
+	time.Sleep(10 * time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := c.Close(ctx); err != nil {
+		t.Fatalf("%v", err)
+	}
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("Close did not stop the monitor")
+	}
+	// Too late
+	c.StartSelfCheck(context.Background(), time.Hour, nil)
+	m.Run(context.Background(), time.Hour)
+}
+
+func TestGoroutineLabels(t *testing.T) {
+	c := New(Configuration{Size: 10, TTL: 10, Name: "labels"})
+	defer c.Close(context.Background())
+	c.StartSelfCheck(context.Background(), time.Hour, nil)
+	var buf bytes.Buffer
+	pprof.Lookup("goroutine").WriteTo(&buf, 1)
+	for _, label := range []string{`"mcache":"labels"`, `"mcache.worker":"selfcheck"`} {
+		if !bytes.Contains(buf.Bytes(), []byte(label)) {
+			t.Fatalf("No label %s in the profile", label)
+		}
+	}
+}
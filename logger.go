@@ -0,0 +1,82 @@
+package mcache
+
+import (
+	"fmt"
+	"log"
+	"strings"
+)
+
+// LogLevel is a severity of a log message
+type LogLevel int
+
+// Log levels
+const (
+	LogDebug LogLevel = iota
+	LogInfo
+	LogWarning
+	LogError
+)
+
+func (l LogLevel) String() string {
+	switch l {
+	case LogDebug:
+		return "DEBUG"
+	case LogInfo:
+		return "INFO"
+	case LogWarning:
+		return "WARNING"
+	case LogError:
+		return "ERROR"
+	}
+	return fmt.Sprintf("LEVEL%d", int(l))
+}
+
+// LogField is a key-value pair attached to a log message
+type LogField struct {
+	Key   string
+	Value interface{}
+}
+
+// Logger is implemented by the application
+// The cache calls Enabled() before preparing the fields. Boxing of the
+// values allocates and the failure paths are high frequency paths
+// Log() should not block - buffer, sample or drop
+type Logger interface {
+	Enabled(level LogLevel) bool
+	Log(level LogLevel, msg string, fields ...LogField)
+}
+
+// nopLogger is the default logger
+type nopLogger struct{}
+
+func (nopLogger) Enabled(LogLevel) bool             { return false }
+func (nopLogger) Log(LogLevel, string, ...LogField) {}
+
+// stdLogger is an adapter for the standard logger
+type stdLogger struct {
+	logger *log.Logger
+	level  LogLevel
+}
+
+// NewStdLogger returns a Logger which prints messages of the 'level' and
+// above using the standard logger
+// The standard logger locks a mutex and writes to the output in every call
+// Use it for debug, not in production
+func NewStdLogger(logger *log.Logger, level LogLevel) Logger {
+	return &stdLogger{logger: logger, level: level}
+}
+
+func (l *stdLogger) Enabled(level LogLevel) bool {
+	return level >= l.level
+}
+
+func (l *stdLogger) Log(level LogLevel, msg string, fields ...LogField) {
+	var sb strings.Builder
+	sb.WriteString(level.String())
+	sb.WriteString(" ")
+	sb.WriteString(msg)
+	for _, f := range fields {
+		fmt.Fprintf(&sb, " %s=%v", f.Key, f.Value)
+	}
+	l.logger.Print(sb.String())
+}
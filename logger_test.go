@@ -0,0 +1,34 @@
+package mcache
+
+import (
+	"bytes"
+	"log"
+	"strings"
+	"testing"
+)
+
+func TestLogger(t *testing.T) {
+	var buf bytes.Buffer
+	logger := NewStdLogger(log.New(&buf, "", 0), LogWarning)
+	var smallCache = New(Configuration{Size: 1, TTL: TTL, LoadFactor: 100, Logger: logger})
+	smallCache.Store(0, 0, GetTime())
+	smallCache.Store(1, 1, GetTime())
+	if !strings.Contains(buf.String(), "WARNING Store failed, eviction queue is full key=1") {
+		t.Fatalf("Unexpected log %q", buf.String())
+	}
+	if logger.Enabled(LogDebug) {
+		t.Fatalf("Debug is enabled")
+	}
+}
+
+func TestNopLoggerAllocs(t *testing.T) {
+	var smallCache = New(Configuration{Size: 1, TTL: TTL, LoadFactor: 100})
+	now := GetTime()
+	smallCache.Store(0, 0, now)
+	allocs := testing.AllocsPerRun(100, func() {
+		smallCache.Store(1000, 1, now)
+	})
+	if allocs != 0 {
+		t.Fatalf("Failing Store allocates %v", allocs)
+	}
+}
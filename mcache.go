@@ -1,13 +1,22 @@
 package mcache
 
 import (
-	//	"log"
+	"context"
+	"errors"
+	"math"
+	"reflect"
 	"runtime"
 	"sync"
+	"sync/atomic"
+	"time"
 	"unsafe"
 
-	"github.com/larytet-go/fifo64"
 	"github.com/larytet-go/hashtable"
+	"github.com/larytet-go/unsafepool"
+	"github.com/larytet/mcachego/internal/fifo"
+	"github.com/larytet/mcachego/internal/minheap"
+	"github.com/larytet/mcachego/internal/wal"
+	"github.com/larytet/mcachego/keyhash"
 
 	// nanotime() is 2x faster than time.Now().UnixNano()
 	// I save 40ns in very call
@@ -38,6 +47,7 @@ type TimeMs int32
 // Application is expected to call this function to get "now". The cache API itself does
 // not perform any time related calls. Application can call GetTime only once for a
 // a bunch of operations
+// See Clock
 // time.Now() takes 45ns, runtime.nanotime is 20ns
 // I can not create an exported symbol with //go:linkname
 // I need a wrapper
@@ -48,6 +58,41 @@ func GetTime() TimeMs {
 	return res
 }
 
+// Flags modify eviction of an entry
+type Flags uint32
+
+const (
+	// FlagNoForceEvict entry is removed only by expiration or EvictByRef()
+	// Evict(force=true) skips such entries and moves them to the tail of the FIFO
+	FlagNoForceEvict Flags = 1 << iota
+)
+
+// ExpiryIndex is a data structure which keeps the order of eviction
+type ExpiryIndex int
+
+const (
+	// ExpiryIndexFIFO evicts entries in the order of arrival
+	// This is the fast path if all entries have the same TTL
+	ExpiryIndexFIFO ExpiryIndex = iota
+	// ExpiryIndexHeap evicts entries in the order of expiration
+	// Use the heap if TTLFunc returns different TTLs
+	ExpiryIndexHeap
+)
+
+// expirationQueue is implemented by the FIFO and the heap
+// The interface call costs 2ns. I do not think it justifies two Cache types
+type expirationQueue interface {
+	Add(e fifo.Entry) (seq uint32, ok bool)
+	Peek() (e fifo.Entry, seq uint32, ok bool)
+	Remove() (e fifo.Entry, ok bool)
+	Get(seq uint32) (e fifo.Entry, ok bool)
+	Tombstone(seq uint32) bool
+	Range(fn func(seq uint32, e fifo.Entry) bool)
+	At(i uint32) (seq uint32, e fifo.Entry, ok bool)
+	Len() int
+	Size() int
+}
+
 // Configuration of the cache
 type Configuration struct {
 	Size       int
@@ -56,17 +101,164 @@ type Configuration struct {
 	Collisions int
 	// Try 50(%) load factor - size of Hashtable 2*Size
 	LoadFactor int
+	// Maintain Object to key map, see LoadByObject()
+	// Store() and Evict() write to a map, see reverseIndex
+	ReverseIndex bool
+	// TTLTransform is applied to TTL, to the results of TTLFunc and to the
+	// argument of StoreWithTTL(), see ClampTTL()
+	TTLTransform func(ttl TimeMs) TimeMs
+	// If not nil TTLFunc is called in every Store() instead of using TTL
+	// Evict() checks only the head of the FIFO. An entry with a long TTL
+	// will delay eviction of the entries stored after it. See ExpiryIndexHeap
+	TTLFunc func(key uint64, o Object) TimeMs
+	// The key is a good hash already, for example, xxhash of a string
+	// By default I mix the key with keyhash.Uint64()
+	RawHash bool
+	// Order of eviction, FIFO by default
+	ExpiryIndex ExpiryIndex
+	// Logger for the failure paths, no logging if nil
+	Logger Logger
+	// Update statistics once in StatisticsSampling operations
+	// Zero or one - update in every operation
+	StatisticsSampling int
+	// The cache behaves the same on every host. The default number of
+	// shards does not depend on the number of CPUs
+	// The cache does not call the clock, the random choices - the samples
+	// of SelfCheck() - come from Seed
+	// A test or a trace replay with the same 'now' arguments is reproducible
+	Deterministic bool
+	// Seed of the random choices. If zero and the cache is not
+	// Deterministic the seed is the clock
+	Seed uint64
+	// If not nil every shard owns an unsafepool of this type, for example,
+	// reflect.TypeOf(new(MyData)). See StoreNew(). Store() fails, the
+	// objects come from the pools. The pools keep Size*100/LoadFactor
+	// objects
+	PoolTemplate reflect.Type
+	// Keep 32 bits fingerprints of the user keys, see LoadVerified()
+	Fingerprints bool
+	// Keep the time of the last access, see LoadWithMeta()
+	AccessTime bool
+	// Keep the expired entries StaleTTL ms longer, see LoadStale()
+	StaleTTL TimeMs
+	// Collect the TTL and the eviction age histograms, see GetHistograms()
+	Histograms bool
+	// Time constant of the moving averages in UpdateRates(), 10s by default
+	RatesWindow TimeMs
+	// Occupancy levels and callbacks, see Watermark
+	Watermarks []Watermark
+	// Every Store() evicts up to EvictOnStore expired entries. A simple
+	// application does not need an Evict() loop
+	EvictOnStore int
+	// Called for the objects evicted by Store(), see EvictOnStore
+	OnEvict func(o Object)
+	// Store() of a key stored in the same millisecond does nothing, see
+	// coalesce(). The cache keeps the object of the first Store(). Store()
+	// returns false, StoreE() returns ErrCoalesced and the application frees
+	// the object
+	CoalesceStores bool
+	// If not nil the cache learns the TTL of the keys, see AdaptiveTTL
+	AdaptiveTTL *AdaptiveTTL
+	// If not nil Store() writes through to the backend, see Backend
+	Backend Backend
+	// Write to the backend in a background goroutine
+	BackendAsync bool
+	// Pending writes of the asynchronous mode, 1024 by default. A Store()
+	// which does not fit the queue does not reach the backend
+	BackendQueue int
+	// The objects of the pools start with ItemHeader, see ExpirationOf()
+	// Requires PoolTemplate. New() panics if the type has no header
+	IntrinsicItem bool
+	// The application calls the cache from one goroutine, see lock()
+	// Implies a single shard
+	SingleGoroutine bool
+	// Limit the rate of Store() calls, stores/s. Zero means no limit
+	// Every shard has a token bucket with 1/Shards of the rate
+	StoreRateLimit int
+	// Capacity of the token buckets, 100ms of the rate by default
+	StoreBurst int
+	// Directory of the write-ahead log, see Open()
+	WALDir string
+	// Group commit interval of the log, 10ms by default
+	WALSyncInterval time.Duration
+	// Rotate the log segment after WALSegmentSize bytes, 64MB by default
+	WALSegmentSize int64
+	// Goroutines of Compact() and of the restore in Open(), GOMAXPROCS by
+	// default
+	SnapshotWorkers int
+	// Called by Compact() and Open() with the number of the entries written
+	// or restored and the number of the entries in the snapshot
+	SnapshotProgress func(done, total int)
+	// Name of the cache in the profiler labels of the goroutines, see do()
+	Name string
 }
 
+// deterministicShards is 2*NumCPU of a typical 8 cores server
+const deterministicShards = 16
+
 // Cache keeps internal data
 type Cache struct {
-	// FIFO of the items to support eviction of the expired entries
-	fifo          *fifo64.Fifo
-	size          int
-	shards        [](*shard)
-	shardsMask    uint64
-	statistics    *Statistics
-	configuration Configuration
+	// FIFO (or heap) of the items to support eviction of the expired entries
+	queue expirationQueue
+	// The queue is shared by all shards. Lock order is shard, then queue
+	// An uncontended mutex costs 15ns in Store()
+	queueMutex sync.Mutex
+	// Counters of the Evict() calls which do not reach a shard
+	// Protected by queueMutex
+	queueStatistics statisticsCell
+	size            int
+	shards          [](*shard)
+	shardsMask      uint64
+	configuration   Configuration
+	// Statistics sampling: power of 2 minus 1
+	statisticsMask uint64
+	// nil if Configuration.ReverseIndex is false
+	reverse *reverseIndex
+	logger  Logger
+	// nil if the cache is not created by Open()
+	wal *wal.Log
+	// Set by Close()
+	closed int32
+	// Capacity of the pool of a shard
+	poolSize int
+	// nil if Configuration.Histograms is false
+	histograms *Histograms
+	watermarks []watermark
+	// nil if Configuration.StaleTTL is zero
+	stale *staleIndex
+	rates rates
+	// nil if Configuration.AccessTime is false
+	access *accessTimes
+	// nil if Configuration.Fingerprints is false
+	fingerprints *fingerprints
+	// Failures of Store(), protected by the queueMutex. The failures are
+	// not sampled
+	storeQueueFull uint64
+	storeTableFull uint64
+	// Rate limiter of a shard: tokens/s and capacity, see admit()
+	rateLimit int32
+	rateBurst int32
+	// See checkCanary()
+	canary uint64
+	// Protected by the queueMutex, see coalesce()
+	storeCoalesced uint64
+	// nil if Configuration.AdaptiveTTL is nil
+	adaptive *adaptiveTTL
+	// nil if Configuration.Backend is nil
+	backend *backendWriter
+	// The only shard if Shards is 1
+	single *shard
+	// Set by Configuration.SingleGoroutine
+	nolock bool
+	// Closed by Close(), the goroutines of StartSelfCheck() and
+	// MemoryMonitor.Run() exit. See startWorker()
+	stop            chan struct{}
+	goroutines      sync.WaitGroup
+	goroutinesMutex sync.Mutex
+	// nil if Configuration.Histograms is false
+	ttls *storedTTLs
+	// Picks the entries for SelfCheck(), protected by the queueMutex
+	selfCheckRand xorshift
 }
 
 // Statistics is a placeholder for debug counters
@@ -75,18 +267,52 @@ type Statistics struct {
 	EvictExpired      uint64
 	EvictForce        uint64
 	EvictNotExpired   uint64
+	EvictSkipped      uint64
 	EvictLookupFailed uint64
 	EvictPeekFailed   uint64
 	MaxOccupancy      uint64
+	// Store() calls rejected by Configuration.StoreRateLimit
+	RejectedByRateLimit uint64
+	// Store() failed because the eviction queue is full. Call Evict() more
+	// often or increase the Size
+	StoreQueueFull uint64
+	// Store() failed because the hashtable hit the collisions limit. Try
+	// a lower LoadFactor
+	StoreTableFull uint64
+	// LoadVerified() found an entry of another user key
+	CollisionDetected uint64
+	// Store() found the key stored in the same millisecond, see
+	// Configuration.CoalesceStores
+	StoreCoalesced uint64
+	// Failed calls of the Backend and the writes which did not fit the
+	// queue of the asynchronous mode
+	BackendGetErrors    uint64
+	BackendSetErrors    uint64
+	BackendDeleteErrors uint64
+	BackendDropped      uint64
+	// Calls of Backend.Get() and LoadOrFetch() calls which waited for the
+	// call of another goroutine
+	BackendFetches       uint64
+	BackendFetchesShared uint64
+	// Backend calls and LoadCtx(), StoreCtx() calls which hit the deadline
+	// of the context
+	BackendTimeouts uint64
 }
 
 // New creates a new instance of Cache
 // If 'shards' is zero the table will use 2*runtime.NumCPU()
+// or deterministicShards in the deterministic mode
 func New(configuration Configuration) *Cache {
 	c := new(Cache)
 
+	if configuration.SingleGoroutine {
+		configuration.Shards = 1
+	}
 	if configuration.Shards == 0 {
 		configuration.Shards = 2 * runtime.NumCPU()
+		if configuration.Deterministic {
+			configuration.Shards = deterministicShards
+		}
 	}
 	// Force power of 2
 	configuration.Shards = hashtable.GetPower2(configuration.Shards)
@@ -97,7 +323,24 @@ func New(configuration Configuration) *Cache {
 	if configuration.Collisions == 0 {
 		configuration.Collisions = 64
 	}
+	if configuration.StatisticsSampling > 1 {
+		// Force power of 2
+		configuration.StatisticsSampling = hashtable.GetPower2(configuration.StatisticsSampling)
+		c.statisticsMask = uint64(configuration.StatisticsSampling) - 1
+	}
+	if configuration.TTLTransform != nil {
+		configuration.TTL = configuration.TTLTransform(configuration.TTL)
+	}
 	c.configuration = configuration
+	c.logger = configuration.Logger
+	if c.logger == nil {
+		c.logger = nopLogger{}
+	}
+	if configuration.IntrinsicItem {
+		if err := checkIntrinsic(configuration); err != nil {
+			panic(err)
+		}
+	}
 	c.size = (c.configuration.Size * 100) / c.configuration.LoadFactor
 	c.shards = make([]*shard, configuration.Shards, configuration.Shards)
 	shardSize := c.size / configuration.Shards
@@ -106,18 +349,77 @@ func New(configuration Configuration) *Cache {
 			table: hashtable.New(shardSize, 64),
 		}
 	}
+	if configuration.Shards == 1 {
+		c.single = c.shards[0]
+	}
+	c.nolock = configuration.SingleGoroutine
+	if configuration.ReverseIndex {
+		c.reverse = newReverseIndex(configuration.Size, configuration.Shards)
+	}
+	c.poolSize = shardSize
+	if configuration.StaleTTL > 0 {
+		c.stale = newStaleIndex(c.size, configuration.Shards)
+	}
+	if configuration.AdaptiveTTL != nil {
+		// The feedback is the access time of the entry
+		c.configuration.AccessTime = true
+		c.adaptive = newAdaptiveTTL(*configuration.AdaptiveTTL, c.size)
+	}
+	if c.configuration.AccessTime {
+		c.access = newAccessTimes(c.size)
+	}
+	if configuration.Fingerprints {
+		c.fingerprints = newFingerprints(c.size)
+	}
+	if configuration.Histograms {
+		c.ttls = newStoredTTLs(c.size)
+	}
+	if len(configuration.Watermarks) > 0 {
+		c.watermarks = newWatermarks(configuration.Watermarks, c.size)
+	}
+	if configuration.StoreRateLimit > 0 {
+		// Thousandths of a token per ms is tokens per second
+		c.rateLimit = int32(configuration.StoreRateLimit / configuration.Shards)
+		if c.rateLimit == 0 {
+			c.rateLimit = 1
+		}
+		burst := configuration.StoreBurst / configuration.Shards
+		if burst == 0 {
+			// 100ms worth of tokens
+			burst = int(c.rateLimit)/10 + 1
+		}
+		if burst > math.MaxInt32/tokenScale {
+			burst = math.MaxInt32 / tokenScale
+		}
+		c.rateBurst = int32(burst * tokenScale)
+	}
+	if configuration.Backend != nil {
+		c.do("backend", func() {
+			c.backend = newBackendWriter(configuration)
+		})
+	}
+	c.canary = cacheCanary
+	c.stop = make(chan struct{})
+	seed := configuration.Seed
+	if seed == 0 && !configuration.Deterministic {
+		seed = uint64(nanotime.Now())
+	}
+	c.selfCheckRand = newXorshift(seed)
 	c.Reset()
 	return c
 }
 
 // Len returns occupancy
 func (c *Cache) Len() int {
-	return c.fifo.Len()
+	c.lockQueue()
+	count := c.queue.Len()
+	c.unlockQueue()
+	return count
 }
 
 // Size returns accomodations
 func (c *Cache) Size() int {
-	return c.fifo.Size()
+	return c.queue.Size()
 }
 
 // Reset removes all items from the cache
@@ -127,16 +429,69 @@ func (c *Cache) Size() int {
 func (c *Cache) Reset() {
 	// Probably faster and more reliable is to allocate everything
 	// than try to call delete()
-	c.fifo = fifo64.New(c.size)
+	if c.configuration.ExpiryIndex == ExpiryIndexHeap {
+		c.queue = minheap.New(c.size)
+	} else {
+		c.queue = fifo.New(c.size)
+	}
 	for _, shard := range c.shards {
 		shard.table.Reset()
+		if c.configuration.PoolTemplate != nil {
+			shard.pool = unsafepool.New(c.configuration.PoolTemplate, c.poolSize)
+		}
+		shard.tokens = c.rateBurst
+	}
+	if c.reverse != nil {
+		c.reverse.reset()
+	}
+	if c.stale != nil {
+		c.stale.reset(c.size)
+	}
+	if c.access != nil {
+		c.access.reset()
+	}
+	if c.fingerprints != nil {
+		c.fingerprints.reset()
+	}
+	if c.wal != nil {
+		c.wal.Append(wal.Record{Op: wal.OpReset})
+	}
+	c.resetStatistics()
+	if c.adaptive != nil {
+		c.adaptive.reset()
 	}
-	c.statistics = new(Statistics)
+	if c.configuration.Histograms {
+		c.histograms = new(Histograms)
+	}
+	c.resetWatermarks()
+}
+
+// hash returns hash of the key
+// Sequential integer keys are common and the hashtable needs all bits
+// of the hash. Mixing costs 3ns
+func (c *Cache) hash(key uint64) uint64 {
+	if c.configuration.RawHash {
+		return key
+	}
+	return keyhash.Uint64(key)
+}
+
+// shardIdx returns index of the shard for the hash
+// The hashtable uses the low bits of the hash. I use the high bits for
+// the shard, otherwise all keys in a shard share the low bits
+func (c *Cache) shardIdx(hash uint64) uint64 {
+	return (hash >> 32) & c.shardsMask
 }
 
 // Store adds an object to the cache
 // This is the single most expensive function in the code - 160ns/op for large tables
 func (c *Cache) Store(key uint64, o Object, now TimeMs) bool {
+	return c.StoreWithFlags(key, o, now, 0)
+}
+
+// StoreWithFlags adds an object to the cache
+// See FlagNoForceEvict
+func (c *Cache) StoreWithFlags(key uint64, o Object, now TimeMs, flags Flags) bool {
 	// Create an entry on the stack, copy 64 bits
 	// These two lines of code add 20% overhead
 	// if I use map[int]item instead of map[int]int
@@ -146,131 +501,740 @@ func (c *Cache) Store(key uint64, o Object, now TimeMs) bool {
 	// expirationMs to the user structure
 	// This is very C/C++ style
 
-	// A temporary variable helps to profile the code
-	i := item{o: o, expirationMs: now + c.configuration.TTL}
-	iValue := *((*uintptr)(unsafe.Pointer(&i)))
+	return c.storeWithTTL(key, o, now, c.ttl(key, o), flags)
+}
+
+// StoreWithTTL adds an object with the TTL provided by the application, for
+// example, the TTL of a DNS record. Configuration.TTLTransform applies
+func (c *Cache) StoreWithTTL(key uint64, o Object, now TimeMs, ttl TimeMs, flags Flags) bool {
+	return c.storeWithTTL(key, o, now, c.transformTTL(ttl), flags)
+}
+
+func (c *Cache) storeWithTTL(key uint64, o Object, now TimeMs, ttl TimeMs, flags Flags) bool {
+	r := storeRequest{
+		e:    fifo.Entry{Key: key, ExpirationMs: int32(now + ttl), Flags: uint32(flags)},
+		o:    o,
+		now:  now,
+		mode: storeLimit | storeWriteThrough | storeLog,
+	}
+	result, _ := c.storeEntry(context.Background(), &r)
+	return result == storeOK
+}
+
+// StoreE is StoreWithFlags() which returns the reason of the failure:
+// ErrQueueFull, ErrTableFull, ErrRateLimited, ErrPoolTemplate or ErrClosed
+// ErrCoalesced is not a failure, the object is not in the cache
+// StoreE does not log
+func (c *Cache) StoreE(key uint64, o Object, now TimeMs, flags Flags) error {
+	r := storeRequest{
+		e:    fifo.Entry{Key: key, ExpirationMs: int32(now + c.ttl(key, o)), Flags: uint32(flags)},
+		o:    o,
+		now:  now,
+		mode: storeLimit | storeWriteThrough,
+	}
+	result, _ := c.storeEntry(context.Background(), &r)
+	return result.err()
+}
+
+// storeResult is an outcome of Store()
+type storeResult int
+
+const (
+	storeOK storeResult = iota
+	storeQueueFull
+	storeTableFull
+	storeRateLimited
+	storeClosed
+	// The Object is not from the pool, see Configuration.PoolTemplate
+	storeNotPooled
+	// The key is in the cache with the object of another Store(), see
+	// Configuration.CoalesceStores
+	storeCoalesced
+	// StoreNew() failed to allocate an object
+	storePoolFull
+)
+
+// stored returns true if the cache keeps the key, possibly with the object
+// of another Store(). Store() returns true for storeOK only, the caller
+// frees the object of a coalesced Store()
+func (r storeResult) stored() bool {
+	return r == storeOK || r == storeCoalesced
+}
+
+func (r storeResult) String() string {
+	switch r {
+	case storeOK:
+		return "Store succeeded"
+	case storeQueueFull:
+		return "Store failed, eviction queue is full"
+	case storeTableFull:
+		return "Store failed, hashtable is full"
+	case storeRateLimited:
+		return "Store failed, rate limit"
+	case storeClosed:
+		return "Store failed, cache is closed"
+	case storeNotPooled:
+		return "Store failed, use StoreNew() with the pool"
+	case storeCoalesced:
+		return "Store coalesced"
+	case storePoolFull:
+		return "Store failed, pool is full"
+	}
+	return "Store failed"
+}
+
+// Errors returned by StoreE()
+var (
+	ErrQueueFull   = errors.New("mcache: eviction queue is full")
+	ErrTableFull   = errors.New("mcache: hashtable is full")
+	ErrRateLimited = errors.New("mcache: rate limit")
+	// Store() of a cache with Configuration.PoolTemplate
+	ErrPoolTemplate = errors.New("mcache: use StoreNew() with PoolTemplate")
+	// The cache keeps the object of another Store(), the object of this
+	// Store() is not in the cache. See Configuration.CoalesceStores
+	ErrCoalesced = errors.New("mcache: store coalesced")
+)
+
+func (r storeResult) err() error {
+	switch r {
+	case storeOK:
+		return nil
+	case storeQueueFull:
+		return ErrQueueFull
+	case storeTableFull:
+		return ErrTableFull
+	case storeRateLimited:
+		return ErrRateLimited
+	case storeClosed:
+		return ErrClosed
+	case storeNotPooled:
+		return ErrPoolTemplate
+	case storeCoalesced:
+		return ErrCoalesced
+	}
+	return errors.New(r.String())
+}
 
-	hash := key
-	shardIdx := hash & c.shardsMask
-	shard := c.shards[shardIdx]
+// storeMode selects the bookkeeping of storeEntry()
+type storeMode uint8
+
+const (
+	// Consume a token of the rate limiter, evict on store, coalesce and
+	// count the TTL. The log replay does not
+	storeLimit storeMode = 1 << iota
+	// Write through to the backend
+	storeWriteThrough
+	// Log a failure
+	storeLog
+)
+
+// storeRequest is a call of the Store*() API
+// I pass the request by pointer, the request remains on the stack
+type storeRequest struct {
+	e    fifo.Entry
+	o    Object
+	now  TimeMs
+	mode storeMode
+	// Non-zero fingerprint is set for the entry, see StoreVerified()
+	fingerprint uint32
+	// StoreNew() allocates the object from the pool and calls init under
+	// the shard lock. The TTL is set after init
+	init func(p unsafe.Pointer)
+}
+
+// storeEntry is the path of all Store*() APIs. I store the entry, count the
+// TTL, check the watermarks, write through to the backend and log a failure
+// Returns the error of the backend
+func (c *Cache) storeEntry(ctx context.Context, r *storeRequest) (result storeResult, err error) {
+	result, count := c.storeShard(r)
+	if result == storeOK && r.mode&storeLimit != 0 && c.histograms != nil {
+		c.histograms.TTL.add(TimeMs(r.e.ExpirationMs) - r.now)
+	}
+	if count >= 0 && c.watermarks != nil {
+		c.checkWatermarks(count)
+	}
+	if result == storeOK && r.mode&storeWriteThrough != 0 && c.backend != nil {
+		err = c.writeThrough(ctx, r.e, r.o, r.now)
+	}
+	if !result.stored() && r.mode&storeLog != 0 && c.logger.Enabled(LogWarning) {
+		c.logger.Log(LogWarning, result.String(),
+			LogField{"key", r.e.Key}, LogField{"len", count})
+	}
+	return result, err
+}
+
+// storeShard locks the shard and stores the entry
+// Returns the number of entries in the queue, -1 if the queue was not
+// checked
+func (c *Cache) storeShard(r *storeRequest) (result storeResult, count int) {
+	if paranoid {
+		c.checkCanary()
+	}
+	if r.init == nil && c.configuration.PoolTemplate != nil {
+		return storeNotPooled, -1
+	}
+	limit := r.mode&storeLimit != 0
+	key := r.e.Key
+	hash := c.hash(key)
+	shard, _ := c.shardOf(hash)
+	if r.init != nil && shard.pool == nil {
+		return storeNotPooled, -1
+	}
+	if limit && c.configuration.EvictOnStore > 0 {
+		c.evictOnStore(r.now)
+	}
 
 	// 85% of the CPU cycles are spent here. Go lang map is rather slow
 	// Trivial map[int32]int32 requires 90ns to add an entry
 	// What about a custom implementation of map? Can I do better than
 	// 120ns (400 CPU cycles)?
-	shard.mutex.Lock()
-	shard.table.Store(key, hash, iValue)
-	ok := c.fifo.Add(key)
-	count := c.fifo.Len()
-	shard.mutex.Unlock()
+	c.lock(shard)
+	if limit && !c.admit(shard, r.now) {
+		c.unlock(shard)
+		return storeRateLimited, -1
+	}
+	// The log and the import do not coalesce. StoreNew() does not know the
+	// expiration time before init
+	if limit && c.configuration.CoalesceStores && r.init == nil {
+		if count, ok := c.coalesce(shard, hash, r.e); ok {
+			c.unlock(shard)
+			return storeCoalesced, count
+		}
+	}
+	if r.init != nil {
+		result, count = c.storeNew(shard, hash, r)
+	} else {
+		result, count = c.storeLocked(shard, hash, r.e, r.o, TimeMs(r.e.ExpirationMs)-r.now)
+	}
+	if result == storeOK && r.fingerprint != 0 {
+		c.setFingerprint(shard, hash, key, r.fingerprint)
+	}
+	c.unlock(shard)
+	if result == storeClosed {
+		count = -1
+	}
+	return result, count
+}
 
-	if c.statistics.MaxOccupancy < uint64(count) {
-		c.statistics.MaxOccupancy = uint64(count)
+// evictOnStore evicts up to EvictOnStore expired entries
+// The queue is shared by the shards and the entries can belong to any shard.
+// I evict before locking the shard of the Store() - lock order. Eviction
+// before the store makes room in a full queue
+func (c *Cache) evictOnStore(now TimeMs) {
+	for i := 0; i < c.configuration.EvictOnStore; i++ {
+		o, ok := c.Evict(now, false)
+		if !ok {
+			return
+		}
+		if c.configuration.OnEvict != nil {
+			c.configuration.OnEvict(o)
+		}
 	}
-	return ok
 }
 
-// ItemRef is used for fast eviction of entries
-// If ItemRef is a struct with two 64 bits fields I see 10ns overhead
-// Can I return a single 64 bits word?
-// hashtableRef can be 32 bits offset from the beginning of the hash
-// TBD What if ItemRef is a struct of two 32 bits words?
-type ItemRef struct {
-	tableIdx uint32
-	shardIdx uint32
+// storeLocked is called with the shard locked
+func (c *Cache) storeLocked(shard *shard, hash uint64, e fifo.Entry, o Object, ttl TimeMs) (result storeResult, count int) {
+	if c.isClosed() {
+		return storeClosed, 0
+	}
+	c.lockQueue()
+	seq, ok := c.queue.Add(c.queueEntry(e))
+	count = c.queue.Len()
+	if !ok {
+		c.storeQueueFull++
+	}
+	c.unlockQueue()
+	if ok {
+		result, count = c.storeItem(shard, hash, e, o, ttl, seq, count)
+	} else {
+		result = storeQueueFull
+	}
+	if shard.statistics.sample(c.statisticsMask) && shard.statistics.MaxOccupancy < uint64(count) {
+		shard.statistics.MaxOccupancy = uint64(count)
+	}
+	return result, count
+}
+
+// storeItem adds the entry with the queue sequence number to the table
+// 'count' is the number of entries in the queue, 'ttl' is unknownTTL for the
+// restored and the imported entries
+// Called with the shard locked
+func (c *Cache) storeItem(shard *shard, hash uint64, e fifo.Entry, o Object, ttl TimeMs, seq uint32, count int) (storeResult, int) {
+	key := e.Key
+	oldValue, overwrite, _ := shard.table.Load(key, hash)
+	// A temporary variable helps to profile the code
+	i := item{o: o, fifoSeq: seq}
+	iValue := *((*uintptr)(unsafe.Pointer(&i)))
+	if !shard.table.Store(key, hash, iValue) {
+		// The FIFO entry has no entry in the table. I do not want
+		// Evict() to find it
+		c.lockQueue()
+		c.queue.Tombstone(seq)
+		count = c.queue.Len()
+		c.storeTableFull++
+		c.unlockQueue()
+		return storeTableFull, count
+	}
+	if overwrite {
+		// Store() overwrites the entry. The FIFO entry of the old item is
+		// a tombstone, the old object goes back to the pool
+		old := *(*item)(unsafe.Pointer(&oldValue))
+		c.lockQueue()
+		c.queue.Tombstone(old.fifoSeq)
+		count = c.queue.Len()
+		c.unlockQueue()
+		if shard.pool != nil {
+			c.poolFree(shard, old.o)
+		}
+	}
+	if c.access != nil {
+		c.access.clear(seq)
+	}
+	if c.fingerprints != nil {
+		c.fingerprints.set(seq, 0)
+	}
+	if c.ttls != nil {
+		c.ttls.set(seq, ttl)
+	}
+	if c.configuration.IntrinsicItem {
+		c.setHeader(shard, o, e)
+	}
+	if c.reverse != nil {
+		c.reverse.store(o, key)
+	}
+	if c.wal != nil {
+		c.logStore(e, o)
+	}
+	return storeOK, count
 }
 
 // Load performs lookup in the cache
 // Application can use "ref" in calls to EvictByRef()
 // Allocation and return of ref costs 10ns/Load Should I use a dedicated API?
 func (c *Cache) Load(key uint64) (o Object, ref ItemRef, ok bool) {
-	hash := key
-	shardIdx := hash & c.shardsMask
-	shard := c.shards[shardIdx]
-
-	shard.mutex.RLock()
-	iValue, ok, hashtableRef := shard.table.Load(key, hash)
-	shard.mutex.RUnlock()
-	ref = ItemRef{
-		tableIdx: hashtableRef,
-		shardIdx: uint32(shardIdx),
+	if c.isClosed() {
+		return 0, ref, false
 	}
+	hash := c.hash(key)
+	shard, shardIdx := c.shardOf(hash)
 
+	c.rlock(shard)
+	iValue, ok, hashtableRef := shard.table.Load(key, hash)
+	c.runlock(shard)
 	i := *(*item)(unsafe.Pointer(&iValue))
+	ref = newItemRef(shardIdx, hashtableRef, i.fifoSeq)
+
 	return i.o, ref, ok
 }
 
+// LoadCopy calls 'copy' with the object under the shard lock
+// Load() returns an object which can be evicted and freed by the application
+// right after the lookup. Eviction of the entry waits for LoadCopy(). The
+// application copies the data out of the object and frees the objects only
+// after Evict() returns
+// 'copy' should be short and must not call the cache API for the same shard
+// A closure capturing variables allocates, prepare 'copy' in advance
+func (c *Cache) LoadCopy(key uint64, copy func(o Object)) bool {
+	if c.isClosed() {
+		return false
+	}
+	hash := c.hash(key)
+	shard, _ := c.shardOf(hash)
+	c.rlock(shard)
+	iValue, ok, _ := shard.table.Load(key, hash)
+	if ok {
+		i := *(*item)(unsafe.Pointer(&iValue))
+		copy(i.o)
+	}
+	c.runlock(shard)
+	return ok
+}
+
+// Prefetch touches the hashtable slots of the keys ahead of a burst of Load()
+// Go does not expose the PREFETCH instruction and the hashtable does not
+// expose the addresses of the slots. I perform the lookups and drop the
+// results. The following Load() calls find the slots in the data cache
+// This makes sense if the application has something else to do between
+// Prefetch() and Load(), for example, parse the rest of the packet
+func (c *Cache) Prefetch(keys []uint64) {
+	if c.isClosed() {
+		return
+	}
+	for _, key := range keys {
+		hash := c.hash(key)
+		shard, _ := c.shardOf(hash)
+		c.rlock(shard)
+		shard.table.Load(key, hash)
+		c.runlock(shard)
+	}
+}
+
 // EvictByRef can save some CPU cycles if the application peforms
 // lot of lookup-delete cycles
 // This API breaks "eviction only by timeout" guarantee
-// TODO I can keep the index (or reference) to the FIFO item in the map.
-// It will alllow removing the entry from the eviction FIFO as well (mark as nil)
+// The item in the map keeps the sequence number of the FIFO entry. I mark
+// the FIFO entry as a tombstone and Evict() skips it without a lookup
+// A ref of an evicted entry is ignored. The FIFO sequence numbers and the
+// heap handles are not reused until a wrap around. I check the shard of the
+// key anyway, the table slot of the ref must be in the shard of the entry
 func (c *Cache) EvictByRef(ref ItemRef) {
-	shardIdx := ref.shardIdx
-	hashtableRef := ref.tableIdx
-	// I can save this line (multiplication) if I compose ItemRef from the
-	// shard address instead of index
-	shard := c.shards[shardIdx]
-	shard.mutex.Lock()
-	shard.table.RemoveByRef(hashtableRef)
-	shard.mutex.Unlock()
+	shard, ok := c.refShard(ref)
+	if !ok {
+		return
+	}
+	c.lock(shard)
+	c.lockQueue()
+	e, ok := c.queue.Get(ref.fifoSeq)
+	if ok && c.shardIdx(c.hash(e.Key)) != uint64(ref.shardIdx) {
+		ok = false
+	}
+	if ok {
+		c.queue.Tombstone(ref.fifoSeq)
+	}
+	count := c.queue.Len()
+	c.unlockQueue()
+	if ok {
+		if paranoid {
+			c.checkRef(shard, ref, e.Key)
+		}
+		if shard.pool != nil {
+			c.poolFreeKey(shard, e.Key, c.hash(e.Key))
+		}
+		// The slot of an evicted entry can keep another key
+		shard.table.RemoveByRef(ref.tableIdx)
+		if c.wal != nil {
+			c.logDelete(e.Key)
+		}
+	}
+	c.unlock(shard)
+	if ok && c.watermarks != nil {
+		c.checkWatermarks(count)
+	}
 }
 
 // Evict an expired - added before time "now" ms - entry
 // Evict() will remove at most one entry
 // If "force" is true evict the entry even if not expired
 // Use force 'true' if you want to expire all entries periodically
+// Entries stored with FlagNoForceEvict are skipped by the forced eviction
+// With StaleTTL Evict() moves the expired entry to the stale table and
+// returns an object when the grace window of the object ends or when the
+// entry replaces an older stale copy of the key. Evict() returns false after
+// moving an entry, one call moves at most one entry
 func (c *Cache) Evict(now TimeMs, force bool) (o Object, expired bool) {
-	c.statistics.EvictCalled++
-	o, expired = 0, false
-	// If there is a race I will pick a removed entry or fail to pick anything
-	// or pick a not initialized ("") key
-	key, ok := c.fifo.Pick()
-	if ok {
-		// I save hashing by keep the object hash in the FIFO instead of the object itself
-		// I am going to call Evict() for every Store(). I assume that the Load()
-		// performance is more important
-		hash := key
-		shardIdx := hash & c.shardsMask
-		shard := c.shards[shardIdx]
-
-		shard.mutex.Lock()
-
-		if iValue, ok, ref := shard.table.Load(key, hash); ok {
-			i := (*item)(unsafe.Pointer(&iValue))
-			isExpired := force || ((i.expirationMs - now) <= 0)
-			if isExpired {
-				c.statistics.EvictExpired++
-				if !expired {
-					c.statistics.EvictForce++
+	if c.isClosed() {
+		return 0, false
+	}
+	// Every skipped entry goes to the tail of the FIFO. I try every entry
+	// in the FIFO at most once
+	// The heap does not skip, see peek()
+	if c.stale != nil {
+		if o, ok := c.evictStale(now); ok {
+			return o, true
+		}
+	}
+	retries := 0
+	if c.configuration.ExpiryIndex == ExpiryIndexFIFO {
+		retries = c.Len()
+	}
+	for first := true; ; first = false {
+		o, result := c.evict(now, force, first)
+		retries--
+		if result != evictSkipped || retries < 0 {
+			return o, (result == evictExpired || result == evictForce || result == evictDisplaced)
+		}
+	}
+}
+
+// evictResult is an outcome of a single eviction attempt
+type evictResult int
+
+const (
+	evictExpired evictResult = iota
+	evictForce
+	evictNotExpired
+	evictSkipped
+	evictLookupFailed
+	evictPeekFailed
+	// The expired entry moved to the stale table, see StaleTTL
+	evictRetained
+	// evictRetained which replaced an older stale copy of the key, the
+	// object is of the older copy
+	evictDisplaced
+)
+
+// evict counts the outcome in the statistics cell of the lock it holds
+// 'first' is true for the first attempt in the Evict() call
+func (c *Cache) evict(now TimeMs, force bool, first bool) (o Object, result evictResult) {
+	if paranoid {
+		c.checkCanary()
+	}
+	// The older stale copy of the key, see retain()
+	var displacedObject Object
+	// I can not lock the shard while holding the queue lock. I peek the
+	// queue and check the head again after locking the shard
+	c.lockQueue()
+	e, seq, ok := c.peek(now, force)
+	// The expiration time is in the FIFO. I do not need a lookup if the
+	// head of the FIFO is not expired
+	isExpired := (TimeMs(e.ExpirationMs) - now) <= 0
+	noForceEvict := (Flags(e.Flags) & FlagNoForceEvict) != 0
+	// In the intrinsic mode the expiration time is in the object
+	intrinsic := c.configuration.IntrinsicItem
+	if !ok || (!isExpired && !force && !intrinsic) {
+		// Probably expiration FIFO is empty - nothing to do
+		result = evictPeekFailed
+		if ok {
+			result = evictNotExpired
+		}
+		if c.queueStatistics.sample(c.statisticsMask) {
+			c.queueStatistics.count(result, first)
+		}
+		c.unlockQueue()
+		return 0, result
+	}
+	c.unlockQueue()
+
+	// I save hashing by keep the object hash in the FIFO instead of the object itself
+	// I am going to call Evict() for every Store(). I assume that the Load()
+	// performance is more important
+	key := e.Key
+	hash := c.hash(key)
+	shard, shardIdx := c.shardOf(hash)
+
+	c.lock(shard)
+	c.lockQueue()
+
+	iValue, ok, ref := shard.table.Load(key, hash)
+	i := (*item)(unsafe.Pointer(&iValue))
+	if intrinsic && ok && i.fifoSeq == seq {
+		e = c.getHeader(shard, i.o, key)
+		isExpired = (TimeMs(e.ExpirationMs) - now) <= 0
+		noForceEvict = (Flags(e.Flags) & FlagNoForceEvict) != 0
+	}
+	if _, headSeq, headOk := c.peek(now, force); !headOk || headSeq != seq {
+		// If there is a race another Evict() removed the head
+		result = evictPeekFailed
+	} else if !ok || i.fifoSeq != seq {
+		// The entry was removed from the map without tombstoning. Store()
+		// tombstones the FIFO entry of the overwritten entry
+		result = evictLookupFailed
+		c.dequeue(seq)
+		if c.logger.Enabled(LogDebug) {
+			c.logger.Log(LogDebug, "Evict lookup failed", LogField{"key", key}, LogField{"found", ok})
+		}
+	} else if !isExpired && !force {
+		// The intrinsic mode, the head of the queue is not expired
+		result = evictNotExpired
+	} else if isExpired || !noForceEvict {
+		result = evictExpired
+		if !isExpired {
+			result = evictForce
+		}
+		c.dequeue(seq)
+		shard.table.RemoveByRef(ref)
+		o = i.o
+		if isExpired && c.stale != nil {
+			// The object is in use until the end of the grace window
+			if old, displaced, ok := c.retain(shardIdx, hash, e, o); !ok {
+				if shard.pool != nil {
+					c.poolFree(shard, o)
 				}
-				c.fifo.Remove()
-				shard.table.RemoveByRef(ref)
-				o = i.o
-				expired = true
+			} else if displaced {
+				result, displacedObject = evictDisplaced, old
 			} else {
-				c.statistics.EvictNotExpired++
+				result = evictRetained
 			}
-		} else {
-			// This is bad - entry is in the eviction FIFO, but not in the hashtable
-			// memory leak? Was removed not by eviction?
-			// Currently EvictByRef() does not remove entries from the eviction FIFO
-			c.statistics.EvictLookupFailed++
-			c.fifo.Remove()
+		} else if shard.pool != nil {
+			c.poolFree(shard, o)
+		}
+		if !isExpired && c.wal != nil {
+			c.logDelete(key)
+		}
+		if c.histograms != nil {
+			if age, ok := c.evictionAge(seq, e.ExpirationMs, now); ok && isExpired {
+				c.histograms.ExpiredAge.add(age)
+			} else if ok {
+				c.histograms.ForcedAge.add(age)
+			}
+		}
+		// After the histograms, the feedback changes the TTL
+		if isExpired && c.adaptive != nil {
+			c.adaptiveFeedback(key, hash, o, seq, e.ExpirationMs)
 		}
-
-		shard.mutex.Unlock()
 	} else {
-		// Probably expiration FIFO is empty - nothing to do
-		c.statistics.EvictPeekFailed++
+		// Forced eviction of an entry with FlagNoForceEvict
+		// Move the entry to the tail of the FIFO
+		if c.configuration.ExpiryIndex == ExpiryIndexFIFO {
+			c.queue.Remove()
+			i.fifoSeq, _ = c.queue.Add(c.queueEntry(e))
+			shard.table.Store(key, hash, iValue)
+			if c.access != nil {
+				c.access.move(seq, i.fifoSeq)
+			}
+			if c.fingerprints != nil {
+				c.fingerprints.move(seq, i.fifoSeq)
+			}
+			if c.ttls != nil {
+				c.ttls.move(seq, i.fifoSeq)
+			}
+		}
+		result = evictSkipped
+	}
+
+	count := c.queue.Len()
+	c.unlockQueue()
+	if shard.statistics.sample(c.statisticsMask) {
+		shard.statistics.count(result, first)
+	}
+	c.unlock(shard)
+
+	if c.watermarks != nil {
+		c.checkWatermarks(count)
+	}
+	if c.reverse != nil && (result == evictExpired || result == evictForce || result == evictRetained || result == evictDisplaced) {
+		c.reverse.remove(o, key)
 	}
+	if result == evictDisplaced {
+		return displacedObject, result
+	}
+	return o, result
+}
+
+// peek returns the head of the queue
+// A FlagNoForceEvict entry on the top of the heap stops the forced eviction,
+// the heap can not move the entry to the tail like the FIFO does. With the
+// heap the forced eviction peeks the earliest entry without the flag. If the
+// top of the heap is expired no entry is skipped
+// Called with the queue locked
+func (c *Cache) peek(now TimeMs, force bool) (e fifo.Entry, seq uint32, ok bool) {
+	e, seq, ok = c.queue.Peek()
+	if !ok || !force || (TimeMs(e.ExpirationMs)-now) <= 0 || (Flags(e.Flags)&FlagNoForceEvict) == 0 {
+		return e, seq, ok
+	}
+	if heap, isHeap := c.queue.(*minheap.Heap); isHeap {
+		return heap.PeekSkip(uint32(FlagNoForceEvict))
+	}
+	return e, seq, ok
+}
+
+// dequeue removes the entry returned by peek()
+// Called with the queue locked
+func (c *Cache) dequeue(seq uint32) {
+	if heap, isHeap := c.queue.(*minheap.Heap); isHeap {
+		heap.Tombstone(seq)
+		return
+	}
+	c.queue.Remove()
+}
+
+// statisticsCell is a set of counters protected by a lock
+// Global counters bounce between the cores at 10M ops/s. Every shard keeps
+// a cell next to the mutex, the cell travels with the lock and the counters
+// do not need atomic operations
+type statisticsCell struct {
+	counters
+	tick uint64
+}
+
+// counters is the part of Statistics which the cells keep
+// The shard is 128 bytes and has no room for all fields of Statistics
+type counters struct {
+	EvictCalled         uint64
+	EvictExpired        uint64
+	EvictForce          uint64
+	EvictNotExpired     uint64
+	EvictSkipped        uint64
+	EvictLookupFailed   uint64
+	EvictPeekFailed     uint64
+	MaxOccupancy        uint64
+	RejectedByRateLimit uint64
+}
 
-	return o, expired
+// sample returns true if the operation should update the counters
+// Updating a dozen of counters in every call is not free when I run 10M ops/s
+// Configuration.StatisticsSampling=N updates the counters once in N operations
+func (s *statisticsCell) sample(mask uint64) bool {
+	s.tick++
+	return (s.tick & mask) == 0
+}
+
+func (s *counters) count(result evictResult, first bool) {
+	if first {
+		s.EvictCalled++
+	}
+	switch result {
+	case evictForce:
+		s.EvictForce++
+		s.EvictExpired++
+	case evictExpired, evictDisplaced:
+		s.EvictExpired++
+	case evictNotExpired:
+		s.EvictNotExpired++
+	case evictSkipped:
+		s.EvictSkipped++
+	case evictLookupFailed:
+		s.EvictLookupFailed++
+	case evictPeekFailed:
+		s.EvictPeekFailed++
+	}
+}
+
+// add sums the counters. MaxOccupancy is a maximum
+func (s *Statistics) add(other *counters) {
+	s.EvictCalled += other.EvictCalled
+	s.EvictExpired += other.EvictExpired
+	s.EvictForce += other.EvictForce
+	s.EvictNotExpired += other.EvictNotExpired
+	s.EvictSkipped += other.EvictSkipped
+	s.EvictLookupFailed += other.EvictLookupFailed
+	s.EvictPeekFailed += other.EvictPeekFailed
+	s.RejectedByRateLimit += other.RejectedByRateLimit
+	if s.MaxOccupancy < other.MaxOccupancy {
+		s.MaxOccupancy = other.MaxOccupancy
+	}
 }
 
 // GetStatistics returns a snapshot of debug counters
+// I collect the cells of all shards under the locks
+// If sampling is enabled the counters are scaled by the sampling rate
+// MaxOccupancy is not scaled
 func (c *Cache) GetStatistics() Statistics {
-	return *c.statistics
+	var s Statistics
+	c.lockQueue()
+	s.add(&c.queueStatistics.counters)
+	s.StoreQueueFull = c.storeQueueFull
+	s.StoreTableFull = c.storeTableFull
+	s.StoreCoalesced = c.storeCoalesced
+	if c.backend != nil {
+		s.BackendGetErrors = atomic.LoadUint64(&c.backend.getErrors)
+		s.BackendSetErrors = atomic.LoadUint64(&c.backend.setErrors)
+		s.BackendDeleteErrors = atomic.LoadUint64(&c.backend.deleteErrors)
+		s.BackendDropped = atomic.LoadUint64(&c.backend.dropped)
+		s.BackendFetches = atomic.LoadUint64(&c.backend.fetches)
+		s.BackendFetchesShared = atomic.LoadUint64(&c.backend.fetchesShared)
+		s.BackendTimeouts = atomic.LoadUint64(&c.backend.timeouts)
+	}
+	if c.fingerprints != nil {
+		s.CollisionDetected = atomic.LoadUint64(&c.fingerprints.collisions)
+	}
+	c.unlockQueue()
+	for _, shard := range c.shards {
+		c.rlock(shard)
+		s.add(&shard.statistics.counters)
+		c.runlock(shard)
+	}
+	if rate := c.statisticsMask + 1; rate > 1 {
+		s.EvictCalled *= rate
+		s.EvictExpired *= rate
+		s.EvictForce *= rate
+		s.EvictNotExpired *= rate
+		s.EvictSkipped *= rate
+		s.EvictLookupFailed *= rate
+		s.EvictPeekFailed *= rate
+		s.RejectedByRateLimit *= rate
+	}
+	return s
 }
 
 // GC is going to poll the cache entries. I can try map[init]int and cast int to
@@ -278,9 +1242,17 @@ func (c *Cache) GetStatistics() Statistics {
 // Inside of the "item" I keep an address of the "item" allocated from a pool
 // Insertion into the map[int]int is 20% faster than map[int]item :100ns vs 120ns
 // The fastest in the benchmarks is map[string]uintptr
+// The shard is 128 bytes - two cache lines because of the adjacent line
+// prefetch. The shards do not share the cache lines
 type shard struct {
-	table *hashtable.Hashtable
-	mutex sync.RWMutex
+	table      *hashtable.Hashtable
+	mutex      sync.RWMutex
+	statistics statisticsCell
+	// nil if Configuration.PoolTemplate is nil
+	pool *unsafepool.Pool
+	// Token bucket of the rate limiter
+	tokens     int32
+	lastRefill TimeMs
 }
 
 // Straight from https://github.com/patrickmn/go-cache
@@ -288,7 +1260,9 @@ type shard struct {
 // If I keep the item struct small I can avoid using of a memory pool for items
 // The benchmark is clear here: copy of a small object is better than allocation
 // from a pool and copy the pointer.
+// The expiration time is in the eviction FIFO, the item keeps the sequence
+// number of the FIFO entry (or the handle of the heap entry)
 type item struct {
-	expirationMs TimeMs
-	o            Object
+	fifoSeq uint32
+	o       Object
 }
@@ -10,8 +10,8 @@ import (
 	"unsafe"
 
 	"github.com/cespare/xxhash"
-	"github.com/larytet-go/fifo64"
 	"github.com/larytet-go/unsafepool"
+	"github.com/larytet/mcachego/internal/fifo"
 )
 
 var TTL TimeMs = 10
@@ -97,6 +97,49 @@ func TestRemoveByRef(t *testing.T) {
 	}
 }
 
+func TestRemoveByRefTombstone(t *testing.T) {
+	var smallCache = New(Configuration{Size: 2, TTL: TTL, LoadFactor: 100})
+	start := GetTime()
+	smallCache.Store(0, 0, start)
+	_, ref, _ := smallCache.Load(0)
+	smallCache.EvictByRef(ref)
+	if smallCache.Len() != 0 {
+		t.Fatalf("Got %d, expected 0", smallCache.Len())
+	}
+	smallCache.Store(0, 1, start+TTL)
+	o, evicted := smallCache.Evict(start+TTL, false)
+	if evicted {
+		t.Fatalf("Evicted entry %v before it expired", o)
+	}
+	o, evicted = smallCache.Evict(start+2*TTL, false)
+	if !evicted || o != 1 {
+		t.Fatalf("Failed to evict value from the cache %v", o)
+	}
+	s := smallCache.GetStatistics()
+	if s.EvictLookupFailed != 0 {
+		t.Fatalf("Lookup failed %d times", s.EvictLookupFailed)
+	}
+}
+
+func TestOverwriteTombstone(t *testing.T) {
+	for _, expiryIndex := range []ExpiryIndex{ExpiryIndexFIFO, ExpiryIndexHeap} {
+		cache := New(Configuration{Size: 4, TTL: TTL, LoadFactor: 100, ExpiryIndex: expiryIndex})
+		start := GetTime()
+		for i := 0; i < 3; i++ {
+			cache.Store(0, Object(i), start)
+		}
+		if cache.Len() != 1 {
+			t.Fatalf("Got Len %d instead of 1", cache.Len())
+		}
+		if o, evicted := cache.Evict(start, true); !evicted || o != 2 {
+			t.Fatalf("Failed to evict the last object %v", o)
+		}
+		if s := cache.GetStatistics(); s.EvictLookupFailed != 0 {
+			t.Fatalf("Lookup failed %d times", s.EvictLookupFailed)
+		}
+	}
+}
+
 func TestOverflow(t *testing.T) {
 	var smallCache = New(Configuration{Size: 1, TTL: TTL, LoadFactor: 100})
 	if ok := smallCache.Store(0, 0, GetTime()); !ok {
@@ -107,6 +150,64 @@ func TestOverflow(t *testing.T) {
 	}
 }
 
+func TestNoForceEvict(t *testing.T) {
+	for _, expiryIndex := range []ExpiryIndex{ExpiryIndexFIFO, ExpiryIndexHeap} {
+		var smallCache = New(Configuration{Size: 2, TTL: TTL, LoadFactor: 100, ExpiryIndex: expiryIndex})
+		now := GetTime()
+		smallCache.StoreWithFlags(0, 0, now, FlagNoForceEvict)
+		// The top of the heap is the entry with the flag
+		smallCache.StoreWithTTL(1, 1, now, 2*TTL, 0)
+		o, evicted := smallCache.Evict(now, true)
+		if !evicted {
+			t.Fatalf("Failed to force evict value from the cache %v", expiryIndex)
+		}
+		if o != 1 {
+			t.Fatalf("Evicted %v instead of %v", o, 1)
+		}
+		_, evicted = smallCache.Evict(now, true)
+		if evicted {
+			t.Fatalf("Force evicted entry with FlagNoForceEvict")
+		}
+		_, _, ok := smallCache.Load(0)
+		if !ok {
+			t.Fatalf("Failed to load value from the cache")
+		}
+		o, evicted = smallCache.Evict(now+TTL+1, false)
+		if !evicted {
+			t.Fatalf("Failed to evict expired value from the cache")
+		}
+		if o != 0 {
+			t.Fatalf("Evicted %v instead of %v", o, 0)
+		}
+	}
+}
+
+func TestExpiryIndexHeap(t *testing.T) {
+	ttlFunc := func(key uint64, o Object) TimeMs {
+		return TimeMs(key) * TTL
+	}
+	var smallCache = New(Configuration{Size: 3, TTL: TTL, LoadFactor: 100, TTLFunc: ttlFunc, ExpiryIndex: ExpiryIndexHeap})
+	now := GetTime()
+	smallCache.Store(3, 3, now)
+	smallCache.Store(1, 1, now)
+	smallCache.Store(2, 2, now)
+	for k := 1; k <= 3; k++ {
+		o, evicted := smallCache.Evict(now+TimeMs(k)*TTL, false)
+		if !evicted {
+			t.Fatalf("Failed to evict value from the cache")
+		}
+		if o != Object(k) {
+			t.Fatalf("Evicted %v instead of %v", o, k)
+		}
+	}
+	smallCache.Store(1, 1, now)
+	_, ref, _ := smallCache.Load(1)
+	smallCache.EvictByRef(ref)
+	if smallCache.Len() != 0 {
+		t.Fatalf("Got %d, expected 0", smallCache.Len())
+	}
+}
+
 type MyData struct {
 	a int
 	b int
@@ -268,7 +369,6 @@ func BenchmarkHashFnv(b *testing.B) {
 }
 
 func BenchmarkStackAllocationMap(b *testing.B) {
-	now := GetTime()
 	mapSize := 10 * 1000 * 1000
 	b.ReportAllocs()
 	b.N = mapSize
@@ -276,20 +376,20 @@ func BenchmarkStackAllocationMap(b *testing.B) {
 	b.ResetTimer()
 
 	for i := 0; i < b.N; i++ {
-		it := item{o: Object(i), expirationMs: now + TTL}
+		it := item{o: Object(i), fifoSeq: uint32(i)}
 		m[uintptr(it.o)] = uintptr(it.o)
 	}
 }
 
 func BenchmarkFifo(b *testing.B) {
 	fifoSize := 10 * 1000 * 1000
-	fifo := fifo64.New(fifoSize)
+	f := fifo.New(fifoSize)
 	b.ReportAllocs()
 	b.N = fifoSize
 	b.ResetTimer()
 
 	for i := 0; i < b.N; i++ {
-		ok := fifo.Add(uint64(i))
+		_, ok := f.Add(fifo.Entry{Key: uint64(i)})
 		if !ok {
 			b.Fatalf("Failed to add an object to the FIFO %d", i)
 		}
@@ -451,3 +551,179 @@ func BenchmarkLoad(b *testing.B) {
 		}
 	}
 }
+
+// Store() and Evict() from all cores
+// The statistics counters are per shard, the global counters bounce
+// between the cores
+func BenchmarkStoreEvictParallel(b *testing.B) {
+	b.ReportAllocs()
+	cache := New(Configuration{Size: 1000 * 1000, TTL: TTL, LoadFactor: 50})
+	var goroutines uint64
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		key := atomic.AddUint64(&goroutines, 1) << 40
+		now := GetTime()
+		for pb.Next() {
+			key++
+			if !cache.Store(key, Object(key), now) {
+				cache.Evict(now, true)
+			}
+			cache.Evict(now, false)
+		}
+	})
+}
+
+func TestShardSize(t *testing.T) {
+	if size := unsafe.Sizeof(shard{}); size != 128 {
+		t.Fatalf("Shard is %d bytes instead of 128", size)
+	}
+}
+
+func TestLoadCopy(t *testing.T) {
+	var smallCache = New(Configuration{Size: 2, TTL: TTL, LoadFactor: 100})
+	now := GetTime()
+	smallCache.Store(1, 10, now)
+	if smallCache.LoadCopy(2, func(Object) { t.Fatalf("Called for a missing key") }) {
+		t.Fatalf("Found a missing key")
+	}
+	evicted := make(chan Object, 1)
+	ok := smallCache.LoadCopy(1, func(o Object) {
+		if o != 10 {
+			t.Fatalf("Got %v instead of %v", o, 10)
+		}
+		go func() {
+			o, _ := smallCache.Evict(now, true)
+			evicted <- o
+		}()
+		select {
+		case <-evicted:
+			t.Fatalf("Evicted the entry in the middle of LoadCopy")
+		case <-time.After(10 * time.Millisecond):
+		}
+	})
+	if !ok {
+		t.Fatalf("Failed to load")
+	}
+	if o := <-evicted; o != 10 {
+		t.Fatalf("Evicted %v instead of %v", o, 10)
+	}
+}
+
+// Prefetch() and Load() of a batch of keys scattered over a large table
+func BenchmarkPrefetch(b *testing.B) {
+	const batch = 16
+	size := 4 * 1000 * 1000
+	cache := New(Configuration{Size: size, TTL: TTL})
+	now := GetTime()
+	for i := 0; i < size; i++ {
+		cache.Store(uint64(i), Object(i), now)
+	}
+	keys := make([]uint64, batch)
+	b.ResetTimer()
+	for i := 0; i < b.N; i += batch {
+		for j := range keys {
+			keys[j] = uint64((i + j) * 7919 % size)
+		}
+		cache.Prefetch(keys)
+		for _, key := range keys {
+			cache.Load(key)
+		}
+	}
+}
+
+func TestDeterministic(t *testing.T) {
+	run := func() []Object {
+		cache := New(Configuration{Size: 64, TTL: TTL, Deterministic: true})
+		var evicted []Object
+		now := TimeMs(0)
+		for i := 0; i < 1000; i++ {
+			key := uint64(i*7919) % 1000
+			if !cache.Store(key, Object(i), now) {
+				o, _ := cache.Evict(now, true)
+				evicted = append(evicted, o)
+			}
+			now++
+		}
+		return evicted
+	}
+	first, second := run(), run()
+	if len(first) == 0 || fmt.Sprint(first) != fmt.Sprint(second) {
+		t.Fatalf("Runs differ %v %v", first, second)
+	}
+	cache := New(Configuration{Size: 64, TTL: TTL, Deterministic: true})
+	if len(cache.shards) != deterministicShards {
+		t.Fatalf("Got %d shards instead of %d", len(cache.shards), deterministicShards)
+	}
+	// The samples of SelfCheck() depend on the seed only
+	a := New(Configuration{Size: 64, TTL: TTL, Deterministic: true, Seed: 42})
+	b := New(Configuration{Size: 64, TTL: TTL, Deterministic: true, Seed: 42})
+	other := New(Configuration{Size: 64, TTL: TTL, Deterministic: true, Seed: 43})
+	same, differ := true, false
+	for i := 0; i < 16; i++ {
+		n := a.selfCheckRand.next()
+		same = same && n == b.selfCheckRand.next()
+		differ = differ || n != other.selfCheckRand.next()
+	}
+	if !same || !differ {
+		t.Fatalf("The seed does not define the samples %v %v", same, differ)
+	}
+}
+
+func TestStoreE(t *testing.T) {
+	cache := New(Configuration{Size: 4, TTL: TTL, LoadFactor: 100, Shards: 1})
+	for i := 0; i < 4; i++ {
+		if err := cache.StoreE(uint64(i), Object(i), 0, 0); err != nil {
+			t.Fatalf("Failed to store %d: %v", i, err)
+		}
+	}
+	if err := cache.StoreE(4, 4, 0, 0); err != ErrQueueFull {
+		t.Fatalf("Got %v instead of %v", err, ErrQueueFull)
+	}
+
+	// The keys collide in the slot 0 of the table
+	cache = New(Configuration{Size: 256, TTL: TTL, LoadFactor: 100, Shards: 1, RawHash: true})
+	var err error
+	stored := 0
+	for i := 1; i < 256 && err == nil; i++ {
+		if err = cache.StoreE(uint64(i*256), Object(i), 0, 0); err == nil {
+			stored++
+		}
+	}
+	if err != ErrTableFull {
+		t.Fatalf("Got %v instead of %v", err, ErrTableFull)
+	}
+	if cache.Len() != stored {
+		t.Fatalf("Got Len %d instead of %d", cache.Len(), stored)
+	}
+	statistics := cache.GetStatistics()
+	if statistics.StoreTableFull != 1 || statistics.StoreQueueFull != 0 {
+		t.Fatalf("Bad statistics %+v", statistics)
+	}
+	if _, ok := cache.Evict(TTL, false); !ok {
+		t.Fatalf("Failed to evict")
+	}
+}
+
+func TestEvictOnStore(t *testing.T) {
+	var evicted []Object
+	cache := New(Configuration{Size: 4, TTL: 10, LoadFactor: 100, EvictOnStore: 2,
+		OnEvict: func(o Object) { evicted = append(evicted, o) }})
+	for i := 0; i < 4; i++ {
+		cache.Store(uint64(i), Object(i), 0)
+	}
+	// The queue is full, Store() makes room
+	if !cache.Store(4, 4, 10) {
+		t.Fatalf("Failed to store")
+	}
+	if len(evicted) != 2 || evicted[0] != 0 || evicted[1] != 1 {
+		t.Fatalf("Evicted %v", evicted)
+	}
+	if cache.Len() != 3 {
+		t.Fatalf("Got Len %d instead of 3", cache.Len())
+	}
+	// Nothing expired
+	cache.Store(5, 5, 5)
+	if len(evicted) != 2 {
+		t.Fatalf("Evicted %v", evicted)
+	}
+}
@@ -0,0 +1,206 @@
+package mcache
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// A container is OOM killed long before the cache reaches the Size. The
+// memory monitor reads the memory usage of the cgroup and force evicts
+// entries when the usage is above the high watermark
+// The monitor does not shrink the pools. Evict() returns the objects of
+// a cache with PoolTemplate to the pools, the application frees other
+// objects in MemoryConfiguration.Evicted
+
+// MemoryConfiguration of the monitor
+type MemoryConfiguration struct {
+	// Limit in bytes. Zero - read the limit of the cgroup
+	Limit uint64
+	// Force eviction above HighWatermark percent of the limit, 90% by default
+	HighWatermark int
+	// Entries to evict in every Check(), 1% of the cache Size by default
+	EvictBatch int
+	// Usage returns the memory usage in bytes. By default the usage of the
+	// cgroup or RSS of the process
+	Usage func() (uint64, error)
+	// Evicted is called for every evicted object, can be nil
+	Evicted func(o Object)
+}
+
+// MemoryStatistics are counters of the monitor
+type MemoryStatistics struct {
+	Checks uint64
+	// Check() calls which evicted entries
+	Interventions uint64
+	Evicted       uint64
+	// The last reading
+	Usage  uint64
+	Limit  uint64
+	Errors uint64
+}
+
+// MemoryMonitor force evicts entries under memory pressure
+type MemoryMonitor struct {
+	cache         *Cache
+	configuration MemoryConfiguration
+	mutex         sync.Mutex
+	statistics    MemoryStatistics
+}
+
+// NewMemoryMonitor returns an error if the limit is not set and the process
+// does not run in a cgroup with a memory limit
+func NewMemoryMonitor(c *Cache, configuration MemoryConfiguration) (*MemoryMonitor, error) {
+	if configuration.Limit == 0 {
+		limit, err := cgroupLimit()
+		if err != nil {
+			return nil, err
+		}
+		configuration.Limit = limit
+	}
+	if configuration.HighWatermark == 0 {
+		configuration.HighWatermark = 90
+	}
+	if configuration.EvictBatch == 0 {
+		configuration.EvictBatch = c.configuration.Size/100 + 1
+	}
+	if configuration.Usage == nil {
+		configuration.Usage = memoryUsage
+	}
+	return &MemoryMonitor{cache: c, configuration: configuration}, nil
+}
+
+// Check reads the memory usage and removes up to EvictBatch entries from
+// the queue if the usage is above the high watermark
+// Returns the number of evicted objects
+func (m *MemoryMonitor) Check(now TimeMs) int {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	s := &m.statistics
+	s.Checks++
+	usage, err := m.configuration.Usage()
+	if err != nil {
+		s.Errors++
+		return 0
+	}
+	s.Usage, s.Limit = usage, m.configuration.Limit
+	if usage*100 < m.configuration.Limit*uint64(m.configuration.HighWatermark) {
+		return 0
+	}
+	// Evict() returns false after moving an expired entry to the stale
+	// table, see StaleTTL. I continue while the queue shrinks
+	evicted := 0
+	for i := 0; i < m.configuration.EvictBatch; i++ {
+		count := m.cache.Len()
+		o, ok := m.cache.Evict(now, true)
+		if ok {
+			evicted++
+			if m.configuration.Evicted != nil {
+				m.configuration.Evicted(o)
+			}
+		} else if count == 0 || m.cache.Len() >= count {
+			// Empty or only the FlagNoForceEvict entries are left
+			break
+		}
+	}
+	if evicted > 0 {
+		s.Interventions++
+		s.Evicted += uint64(evicted)
+	}
+	return evicted
+}
+
+// Run calls Check() every interval until the context is done or the cache
+// is closed. Close() waits for Run() to return
+// The Go runtime does not return the freed memory to the OS immediately. The
+// interval should be long enough for the usage to reflect the evictions
+func (m *MemoryMonitor) Run(ctx context.Context, interval time.Duration) {
+	if !m.cache.startWorker() {
+		return
+	}
+	defer m.cache.goroutines.Done()
+	m.cache.do("memory", func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				m.Check(GetTime())
+			case <-ctx.Done():
+				return
+			case <-m.cache.stop:
+				return
+			}
+		}
+	})
+}
+
+// GetStatistics returns a copy of the counters
+func (m *MemoryMonitor) GetStatistics() MemoryStatistics {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.statistics
+}
+
+// cgroup v2 and v1 files
+const (
+	cgroupV2Limit = "/sys/fs/cgroup/memory.max"
+	cgroupV2Usage = "/sys/fs/cgroup/memory.current"
+	cgroupV1Limit = "/sys/fs/cgroup/memory/memory.limit_in_bytes"
+	cgroupV1Usage = "/sys/fs/cgroup/memory/memory.usage_in_bytes"
+)
+
+// cgroupV1Unlimited - cgroup v1 reports a huge number if there is no limit
+const cgroupV1Unlimited = 1 << 62
+
+func readUint64(name string) (uint64, error) {
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseUint(string(bytes.TrimSpace(data)), 10, 64)
+}
+
+func cgroupLimit() (uint64, error) {
+	// cgroup v2 without a limit contains "max" and the parsing fails
+	if limit, err := readUint64(cgroupV2Limit); err == nil {
+		return limit, nil
+	}
+	if limit, err := readUint64(cgroupV1Limit); err == nil && limit < cgroupV1Unlimited {
+		return limit, nil
+	}
+	return 0, fmt.Errorf("mcache: no cgroup memory limit, set MemoryConfiguration.Limit")
+}
+
+// memoryUsage returns the usage of the cgroup or RSS of the process
+func memoryUsage() (uint64, error) {
+	if usage, err := readUint64(cgroupV2Usage); err == nil {
+		return usage, nil
+	}
+	if usage, err := readUint64(cgroupV1Usage); err == nil {
+		return usage, nil
+	}
+	return rss()
+}
+
+// rss reads the resident set size from /proc/self/statm
+func rss() (uint64, error) {
+	data, err := ioutil.ReadFile("/proc/self/statm")
+	if err != nil {
+		return 0, err
+	}
+	fields := bytes.Fields(data)
+	if len(fields) < 2 {
+		return 0, fmt.Errorf("mcache: bad /proc/self/statm")
+	}
+	pages, err := strconv.ParseUint(string(fields[1]), 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	return pages * uint64(os.Getpagesize()), nil
+}
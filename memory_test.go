@@ -0,0 +1,66 @@
+package mcache
+
+import (
+	"runtime"
+	"testing"
+)
+
+func TestMemoryMonitor(t *testing.T) {
+	cache := New(Configuration{Size: 100, TTL: 1000, LoadFactor: 100})
+	for i := 0; i < 100; i++ {
+		cache.Store(uint64(i), Object(i), 0)
+	}
+	usage := uint64(50)
+	var evicted []Object
+	m, err := NewMemoryMonitor(cache, MemoryConfiguration{
+		Limit:      100,
+		EvictBatch: 10,
+		Usage:      func() (uint64, error) { return usage, nil },
+		Evicted:    func(o Object) { evicted = append(evicted, o) },
+	})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if n := m.Check(0); n != 0 {
+		t.Fatalf("Evicted %d entries below the watermark", n)
+	}
+	usage = 95
+	if n := m.Check(0); n != 10 || len(evicted) != 10 || evicted[0] != 0 {
+		t.Fatalf("Evicted %d entries %v", n, evicted)
+	}
+	if cache.Len() != 90 {
+		t.Fatalf("Got Len %d instead of 90", cache.Len())
+	}
+	s := m.GetStatistics()
+	if s.Checks != 2 || s.Interventions != 1 || s.Evicted != 10 || s.Usage != 95 {
+		t.Fatalf("Bad statistics %+v", s)
+	}
+}
+
+func TestMemoryMonitorStale(t *testing.T) {
+	cache := New(Configuration{Size: 10, TTL: 10, LoadFactor: 100, StaleTTL: 1000})
+	for i := 0; i < 10; i++ {
+		cache.Store(uint64(i), Object(i), TimeMs(i/5)*100)
+	}
+	m, _ := NewMemoryMonitor(cache, MemoryConfiguration{
+		Limit:      100,
+		EvictBatch: 10,
+		Usage:      func() (uint64, error) { return 95, nil },
+	})
+	// The expired entries move to the stale table, Evict() returns false
+	if n := m.Check(20); n != 5 {
+		t.Fatalf("Evicted %d entries instead of 5", n)
+	}
+	if cache.Len() != 0 {
+		t.Fatalf("Got Len %d instead of 0", cache.Len())
+	}
+}
+
+func TestRSS(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("No /proc")
+	}
+	if usage, err := memoryUsage(); err != nil || usage == 0 {
+		t.Fatalf("Got usage %d %v", usage, err)
+	}
+}
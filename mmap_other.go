@@ -0,0 +1,20 @@
+//go:build !unix
+// +build !unix
+
+package mcache
+
+import (
+	"io"
+	"os"
+)
+
+// I read the file to the memory where mmap is not available
+func mmapFile(file *os.File, size int) ([]byte, error) {
+	data := make([]byte, size)
+	_, err := io.ReadFull(file, data)
+	return data, err
+}
+
+func munmapFile(data []byte) error {
+	return nil
+}
@@ -0,0 +1,17 @@
+//go:build unix
+// +build unix
+
+package mcache
+
+import (
+	"os"
+	"syscall"
+)
+
+func mmapFile(file *os.File, size int) ([]byte, error) {
+	return syscall.Mmap(int(file.Fd()), 0, size, syscall.PROT_READ, syscall.MAP_SHARED)
+}
+
+func munmapFile(data []byte) error {
+	return syscall.Munmap(data)
+}
@@ -0,0 +1,172 @@
+package mcache
+
+import (
+	"math/rand"
+	"testing"
+)
+
+// modelEntry is an entry of the reference model
+type modelEntry struct {
+	key          uint64
+	o            Object
+	expirationMs TimeMs
+	noForceEvict bool
+	// Removed by EvictByRef(), the cache keeps a tombstone in the FIFO
+	dead bool
+}
+
+// model is a map and a slice reference implementation of the cache with
+// the eviction FIFO
+// The model mirrors the observable behaviour: what Store/Load/Evict return
+// and Len()
+type model struct {
+	size  int
+	ttl   TimeMs
+	queue []*modelEntry
+	table map[uint64]*modelEntry
+}
+
+func newModel(size int, ttl TimeMs) *model {
+	return &model{size: size, ttl: ttl, table: make(map[uint64]*modelEntry)}
+}
+
+func (m *model) len() int {
+	count := 0
+	for _, e := range m.queue {
+		if !e.dead {
+			count++
+		}
+	}
+	return count
+}
+
+func (m *model) store(key uint64, o Object, now TimeMs, flags Flags) bool {
+	// Tombstones occupy slots until they reach the head
+	for len(m.queue) >= m.size && m.queue[0].dead {
+		m.queue = m.queue[1:]
+	}
+	if len(m.queue) >= m.size {
+		return false
+	}
+	e := &modelEntry{key: key, o: o, expirationMs: now + m.ttl, noForceEvict: (flags & FlagNoForceEvict) != 0}
+	m.queue = append(m.queue, e)
+	// The FIFO entry of the overwritten entry is a tombstone
+	if old, ok := m.table[key]; ok {
+		old.dead = true
+	}
+	m.table[key] = e
+	return true
+}
+
+func (m *model) load(key uint64) (Object, bool) {
+	e, ok := m.table[key]
+	if !ok {
+		return 0, false
+	}
+	return e.o, true
+}
+
+func (m *model) evictByRef(key uint64) {
+	if e, ok := m.table[key]; ok {
+		delete(m.table, key)
+		e.dead = true
+	}
+}
+
+func (m *model) evictOne(now TimeMs, force bool) (Object, evictResult) {
+	for len(m.queue) > 0 && m.queue[0].dead {
+		m.queue = m.queue[1:]
+	}
+	if len(m.queue) == 0 {
+		return 0, evictPeekFailed
+	}
+	h := m.queue[0]
+	isExpired := (h.expirationMs - now) <= 0
+	if !isExpired && !force {
+		return 0, evictNotExpired
+	}
+	m.queue = m.queue[1:]
+	if m.table[h.key] != h {
+		return 0, evictLookupFailed
+	}
+	if isExpired || !h.noForceEvict {
+		delete(m.table, h.key)
+		return h.o, evictExpired
+	}
+	m.queue = append(m.queue, h)
+	return 0, evictSkipped
+}
+
+func (m *model) evict(now TimeMs, force bool) (Object, bool) {
+	for retries := m.len(); ; retries-- {
+		o, result := m.evictOne(now, force)
+		if result != evictSkipped || retries <= 0 {
+			return o, result == evictExpired
+		}
+	}
+}
+
+// runModel executes the operations encoded in the data against the cache
+// and the model. Every operation is 3 bytes: operation, key, argument
+func runModel(t *testing.T, data []byte) {
+	size := 8
+	ttl := TimeMs(10)
+	cache := New(Configuration{Size: size, TTL: ttl, LoadFactor: 50, Shards: 1})
+	// The FIFO is as large as the table, see LoadFactor
+	m := newModel(cache.Size(), ttl)
+	now := GetTime()
+	for i := 0; i+2 < len(data); i += 3 {
+		op, key, arg := data[i]%7, uint64(data[i+1]%8), data[i+2]
+		switch op {
+		case 0, 1:
+			var flags Flags
+			if op == 1 {
+				flags = FlagNoForceEvict
+			}
+			ok := cache.StoreWithFlags(key, Object(arg), now, flags)
+			if expected := m.store(key, Object(arg), now, flags); ok != expected {
+				t.Fatalf("op %d: Store(%d) returned %v, model %v", i/3, key, ok, expected)
+			}
+		case 2:
+			o, _, ok := cache.Load(key)
+			expectedO, expected := m.load(key)
+			if ok != expected || (ok && o != expectedO) {
+				t.Fatalf("op %d: Load(%d) returned %v %v, model %v %v", i/3, key, o, ok, expectedO, expected)
+			}
+		case 3, 4:
+			force := (op == 4)
+			o, ok := cache.Evict(now, force)
+			expectedO, expected := m.evict(now, force)
+			if ok != expected || (ok && o != expectedO) {
+				t.Fatalf("op %d: Evict(%v) returned %v %v, model %v %v", i/3, force, o, ok, expectedO, expected)
+			}
+		case 5:
+			if _, ref, ok := cache.Load(key); ok {
+				cache.EvictByRef(ref)
+			}
+			m.evictByRef(key)
+		case 6:
+			now += TimeMs(arg % 16)
+		}
+		if cache.Len() != m.len() {
+			t.Fatalf("op %d: Len() returned %d, model %d", i/3, cache.Len(), m.len())
+		}
+	}
+}
+
+func TestCacheModel(t *testing.T) {
+	for seed := int64(0); seed < 200; seed++ {
+		r := rand.New(rand.NewSource(seed))
+		data := make([]byte, 3*500)
+		r.Read(data)
+		runModel(t, data)
+	}
+}
+
+func FuzzCacheModel(f *testing.F) {
+	f.Add([]byte{0, 1, 1, 2, 1, 0, 6, 0, 15, 3, 0, 0})
+	f.Add([]byte{1, 1, 1, 0, 2, 2, 4, 0, 0, 4, 0, 0, 5, 1, 0, 0, 1, 3})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		runModel(t, data)
+	})
+}
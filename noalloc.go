@@ -0,0 +1,47 @@
+package mcache
+
+import (
+	"context"
+	"unsafe"
+
+	"github.com/larytet/mcachego/internal/fifo"
+)
+
+// The API in this file never allocates from the heap. Use it in the hot
+// paths if the GC pauses matter. The tests in noalloc_test.go gate every
+// function with testing.AllocsPerRun()
+// Store() and Load() do not allocate either, but they are allowed to in the
+// future: Store() boxes the logger fields if the logger is enabled, Load()
+// returns an ItemRef
+// The guarantee does not cover the optional features with their own memory
+// A new object in the reverse index grows a map, a slow disk grows the
+// buffer of the write-ahead log
+
+// LoadNoAlloc performs lookup in the cache
+// Unlike Load() LoadNoAlloc() does not return a reference
+func (c *Cache) LoadNoAlloc(key uint64) (o Object, ok bool) {
+	if c.isClosed() {
+		return 0, false
+	}
+	hash := c.hash(key)
+	shard, _ := c.shardOf(hash)
+	c.rlock(shard)
+	iValue, ok, _ := shard.table.Load(key, hash)
+	c.runlock(shard)
+	i := *(*item)(unsafe.Pointer(&iValue))
+	return i.o, ok
+}
+
+// StoreNoAlloc adds an object to the cache
+// Unlike Store() StoreNoAlloc() does not log failures. TTLFunc is called
+// if set, the function should not allocate either
+func (c *Cache) StoreNoAlloc(key uint64, o Object, now TimeMs) bool {
+	r := storeRequest{
+		e:    fifo.Entry{Key: key, ExpirationMs: int32(now + c.ttl(key, o))},
+		o:    o,
+		now:  now,
+		mode: storeLimit | storeWriteThrough,
+	}
+	result, _ := c.storeEntry(context.Background(), &r)
+	return result == storeOK
+}
@@ -0,0 +1,53 @@
+package mcache
+
+import (
+	"bytes"
+	"log"
+	"testing"
+)
+
+// checkAllocs fails the test if the function allocates
+func checkAllocs(t *testing.T, name string, f func()) {
+	if allocs := testing.AllocsPerRun(100, f); allocs != 0 {
+		t.Fatalf("%s allocates %v times", name, allocs)
+	}
+}
+
+func TestNoAlloc(t *testing.T) {
+	var buf bytes.Buffer
+	// The logger is enabled and StoreNoAlloc() still does not allocate
+	logger := NewStdLogger(log.New(&buf, "", 0), LogDebug)
+	cache := New(Configuration{Size: 1000, TTL: TTL, Logger: logger})
+	now := GetTime()
+	key := uint64(0)
+	checkAllocs(t, "StoreNoAlloc", func() {
+		key++
+		cache.StoreNoAlloc(key, Object(key), now)
+	})
+	checkAllocs(t, "LoadNoAlloc", func() {
+		if _, ok := cache.LoadNoAlloc(1); !ok {
+			t.Fatalf("Failed to load")
+		}
+	})
+	checkAllocs(t, "Load", func() {
+		cache.Load(1)
+	})
+	keys := []uint64{1, 2, 3, 1 << 40}
+	checkAllocs(t, "Prefetch", func() {
+		cache.Prefetch(keys)
+	})
+	checkAllocs(t, "Evict", func() {
+		cache.Evict(now, true)
+	})
+
+	full := New(Configuration{Size: 1, TTL: TTL, LoadFactor: 100, Logger: logger})
+	full.Store(0, 0, now)
+	checkAllocs(t, "StoreNoAlloc failure", func() {
+		if full.StoreNoAlloc(1, 1, now) {
+			t.Fatalf("Did not fail on overflow")
+		}
+	})
+	if buf.Len() != 0 {
+		t.Fatalf("StoreNoAlloc logged %q", buf.String())
+	}
+}
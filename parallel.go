@@ -0,0 +1,99 @@
+package mcache
+
+import (
+	"runtime"
+)
+
+// Snapshot and restore of tens of millions of entries take seconds on a
+// single core. Compact() encodes the sections of the snapshot in parallel,
+// Open() decodes the sections in parallel and fills the tables of the shards
+// in parallel. The order of the eviction queue does not change: the sections
+// keep ranges of the queue and the restore adds the entries to the queue
+// before it fills the tables
+
+// workers returns the number of goroutines for the snapshot and the restore
+func (c *Cache) workers() int {
+	if c.configuration.SnapshotWorkers > 0 {
+		return c.configuration.SnapshotWorkers
+	}
+	return runtime.GOMAXPROCS(0)
+}
+
+// parallel calls fn(idx) for every idx in [0, n) from the worker goroutines
+// 'done' is called in the calling goroutine after every fn(), can be nil
+func (c *Cache) parallel(n int, fn func(idx int), done func(idx int)) {
+	workers := c.workers()
+	if workers > n {
+		workers = n
+	}
+	tasks := make(chan int, n)
+	for idx := 0; idx < n; idx++ {
+		tasks <- idx
+	}
+	close(tasks)
+	results := make(chan int, n)
+	for w := 0; w < workers; w++ {
+		go func() {
+			for idx := range tasks {
+				fn(idx)
+				results <- idx
+			}
+		}()
+	}
+	for i := 0; i < n; i++ {
+		idx := <-results
+		if done != nil {
+			done(idx)
+		}
+	}
+}
+
+// progress calls Configuration.SnapshotProgress if set
+func (c *Cache) progress(done, total int) {
+	if c.configuration.SnapshotProgress != nil {
+		c.configuration.SnapshotProgress(done, total)
+	}
+}
+
+// restoreEntries stores the entries in the order of the slice
+// I add all entries to the queue under one lock and fill the tables of the
+// shards in parallel. The snapshot can keep more entries than the cache,
+// I keep the newest entries
+func (c *Cache) restoreEntries(entries []liveEntry) {
+	if len(entries) == 0 {
+		return
+	}
+	c.lockQueue()
+	if free := c.queue.Size() - c.queue.Len(); len(entries) > free {
+		entries = entries[len(entries)-free:]
+	}
+	for i := range entries {
+		seq, ok := c.queue.Add(entries[i].e)
+		if !ok {
+			entries = entries[:i]
+			break
+		}
+		entries[i].seq = seq
+	}
+	count := c.queue.Len()
+	c.unlockQueue()
+
+	batches := make([][]liveEntry, len(c.shards))
+	for _, entry := range entries {
+		entry.hash = c.hash(entry.e.Key)
+		idx := c.shardIdx(entry.hash)
+		batches[idx] = append(batches[idx], entry)
+	}
+	done := 0
+	c.parallel(len(batches), func(idx int) {
+		shard := c.shards[idx]
+		c.lock(shard)
+		for _, entry := range batches[idx] {
+			c.storeItem(shard, entry.hash, entry.e, entry.o, unknownTTL, entry.seq, count)
+		}
+		c.unlock(shard)
+	}, func(idx int) {
+		done += len(batches[idx])
+		c.progress(done, len(entries))
+	})
+}
@@ -0,0 +1,45 @@
+package mcache
+
+import (
+	"fmt"
+	"unsafe"
+)
+
+// Build with -tags mcache_paranoid in staging. The paranoid build panics if
+// the unsafe code goes wrong:
+//   - ItemRef does not point to the entry of the key
+//   - Pointer() or the pool gets an object outside of the pool
+//   - Free() of an object which is free already
+//   - The Cache is not created by New() or the memory is overwritten
+//
+// The statistics do not need a paranoid mode. The counters are protected by
+// the shard and the queue locks and are race free in both builds
+// The production build keeps the hot paths: every check is under
+// 'if paranoid' and the compiler removes the code
+
+// cacheCanary is the last field of the Cache
+const cacheCanary = 0x6d63616368656361
+
+// checkCanary panics if the Cache is not created by New()
+func (c *Cache) checkCanary() {
+	if c.canary != cacheCanary {
+		panic(fmt.Sprintf("mcache: bad canary %x", c.canary))
+	}
+}
+
+// checkRef panics if the ref does not point to the entry of the key
+// Called with the shard locked
+func (c *Cache) checkRef(shard *shard, ref ItemRef, key uint64) {
+	iValue, ok, tableIdx := shard.table.Load(key, c.hash(key))
+	i := *(*item)(unsafe.Pointer(&iValue))
+	if ok && (tableIdx != ref.tableIdx || i.fifoSeq != ref.fifoSeq) {
+		panic(fmt.Sprintf("mcache: ref %+v does not match key %d, slot %d, seq %d", ref, key, tableIdx, i.fifoSeq))
+	}
+}
+
+// checkObject panics if the object is outside of the pool
+func (c *Cache) checkObject(shard *shard, o Object) {
+	if !shard.pool.Belongs(shard.pool.GetBase() + uintptr(o)) {
+		panic(fmt.Sprintf("mcache: object %d is not in the pool", o))
+	}
+}
@@ -0,0 +1,8 @@
+//go:build !mcache_paranoid
+// +build !mcache_paranoid
+
+package mcache
+
+// paranoid is false in the production build. The compiler removes the
+// checks
+const paranoid = false
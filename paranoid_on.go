@@ -0,0 +1,7 @@
+//go:build mcache_paranoid
+// +build mcache_paranoid
+
+package mcache
+
+// paranoid enables the checks in paranoid.go
+const paranoid = true
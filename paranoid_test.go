@@ -0,0 +1,33 @@
+//go:build mcache_paranoid
+// +build mcache_paranoid
+
+package mcache
+
+import (
+	"strings"
+	"testing"
+)
+
+func expectPanic(t *testing.T, message string, fn func()) {
+	defer func() {
+		r := recover()
+		if r == nil || !strings.Contains(r.(string), message) {
+			t.Fatalf("Got panic %v instead of %q", r, message)
+		}
+	}()
+	fn()
+}
+
+func TestParanoid(t *testing.T) {
+	expectPanic(t, "canary", func() {
+		var c Cache
+		c.Store(1, 1, 0)
+	})
+	cache := New(Configuration{Size: 4, TTL: 100, LoadFactor: 100, Shards: 1})
+	cache.Store(1, 1, 0)
+	_, ref, _ := cache.Load(1)
+	ref.tableIdx++
+	expectPanic(t, "does not match", func() {
+		cache.EvictByRef(ref)
+	})
+}
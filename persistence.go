@@ -0,0 +1,411 @@
+package mcache
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/larytet/mcachego/internal/fifo"
+	"github.com/larytet/mcachego/internal/wal"
+	"github.com/larytet/mcachego/snapshot"
+)
+
+// Open creates a cache and restores the state from Configuration.WALDir
+// Open loads the last snapshot and replays the write-ahead log on top of it
+// Store(), EvictByRef(), EvictByObject(), forced eviction and Reset() append
+// records to the log. Expiration does not need a record - the replay drops
+// the expired entries
+// Call Close(ctx) to write the pending records
+func Open(configuration Configuration) (*Cache, error) {
+	if configuration.WALDir == "" {
+		return nil, fmt.Errorf("mcache: WALDir is empty")
+	}
+	if configuration.PoolTemplate != nil {
+		return nil, fmt.Errorf("mcache: the log does not support PoolTemplate")
+	}
+	if err := os.MkdirAll(configuration.WALDir, 0755); err != nil {
+		return nil, err
+	}
+	c := New(configuration)
+	if err := c.restore(); err != nil {
+		return nil, err
+	}
+	var log *wal.Log
+	var err error
+	c.do("wal", func() {
+		log, err = wal.Open(wal.Configuration{
+			Dir:          configuration.WALDir,
+			SegmentSize:  configuration.WALSegmentSize,
+			SyncInterval: configuration.WALSyncInterval,
+			TimeBase:     timeBase,
+			OnError: func(err error) {
+				if c.logger.Enabled(LogError) {
+					c.logger.Log(LogError, "WAL write failed", LogField{"error", err})
+				}
+			},
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	c.wal = log
+	return c, nil
+}
+
+// timeBase maps the monotonic time of the process to the wall clock
+// GetTime() is a monotonic time and means nothing after a reboot
+func timeBase() wal.Record {
+	return wal.Record{
+		Op:           wal.OpTime,
+		Key:          uint64(time.Now().UnixNano() / int64(time.Millisecond)),
+		ExpirationMs: int32(GetTime()),
+	}
+}
+
+// logStore is called with the shard locked. The lock keeps the records of
+// the same key in the order of the table updates
+func (c *Cache) logStore(e fifo.Entry, o Object) {
+	c.wal.Append(wal.Record{
+		Op:           wal.OpStore,
+		Key:          e.Key,
+		Object:       uint32(o),
+		ExpirationMs: e.ExpirationMs,
+		Flags:        uint16(e.Flags),
+	})
+}
+
+// logDelete is called with the shard locked
+func (c *Cache) logDelete(key uint64) {
+	c.wal.Append(wal.Record{Op: wal.OpDelete, Key: key})
+}
+
+// replayer converts the monotonic time of the recorded process to the
+// monotonic time of this process
+type replayer struct {
+	c *Cache
+	// Difference between the recorded monotonic time and now
+	offset  int64
+	now     TimeMs
+	wallNow int64
+}
+
+func newReplayer(c *Cache) *replayer {
+	base := timeBase()
+	return &replayer{c: c, now: TimeMs(base.ExpirationMs), wallNow: int64(base.Key)}
+}
+
+func (r *replayer) apply(record wal.Record) {
+	c := r.c
+	switch record.Op {
+	case wal.OpTime:
+		// Recorded monotonic time plus the offset is the time of this process
+		// The wall clock advanced (wallNow - wall) since the record
+		wall := int64(record.Key)
+		r.offset = int64(r.now) - (r.wallNow - wall) - int64(record.ExpirationMs)
+	case wal.OpStore:
+		e, ok := r.entry(record)
+		if !ok {
+			c.deleteKey(record.Key)
+			return
+		}
+		request := storeRequest{e: e, o: Object(record.Object), now: r.now}
+		if result, _ := c.storeEntry(context.Background(), &request); result != storeOK {
+			// The log can keep more entries than the cache
+			c.Evict(r.now, true)
+			c.storeEntry(context.Background(), &request)
+		}
+	case wal.OpDelete:
+		c.deleteKey(record.Key)
+	case wal.OpReset:
+		c.Reset()
+	}
+}
+
+// entry converts the expiration time. Returns false if the entry expired
+func (r *replayer) entry(record wal.Record) (fifo.Entry, bool) {
+	expiration := TimeMs(int64(record.ExpirationMs) + r.offset)
+	e := fifo.Entry{Key: record.Key, ExpirationMs: int32(expiration), Flags: uint32(record.Flags)}
+	return e, expiration-r.now > 0
+}
+
+// deleteKey removes the entry if present
+func (c *Cache) deleteKey(key uint64) bool {
+	_, ref, ok := c.Load(key)
+	if ok {
+		c.EvictByRef(ref)
+	}
+	return ok
+}
+
+const snapshotSuffix = ".snapshot"
+
+func snapshotName(segment uint64) string {
+	return fmt.Sprintf("%016x%s", segment, snapshotSuffix)
+}
+
+// snapshots returns the snapshot numbers in ascending order
+// The snapshot N keeps the state of the segments before N
+func snapshots(dir string) ([]uint64, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var res []uint64
+	for _, f := range files {
+		name := f.Name()
+		if !strings.HasSuffix(name, snapshotSuffix) {
+			continue
+		}
+		segment, err := strconv.ParseUint(strings.TrimSuffix(name, snapshotSuffix), 16, 64)
+		if err != nil {
+			continue
+		}
+		res = append(res, segment)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i] < res[j] })
+	return res, nil
+}
+
+// restore loads the last snapshot and replays the log
+func (c *Cache) restore() error {
+	dir := c.configuration.WALDir
+	r := newReplayer(c)
+	list, err := snapshots(dir)
+	if err != nil {
+		return err
+	}
+	segment := uint64(0)
+	if len(list) > 0 {
+		segment = list[len(list)-1]
+		if err := c.loadSnapshot(filepath.Join(dir, snapshotName(segment)), r); err != nil {
+			return err
+		}
+	}
+	return wal.Replay(dir, segment, r.apply)
+}
+
+func (c *Cache) loadSnapshot(name string, r *replayer) error {
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		return err
+	}
+	reader, err := snapshot.NewReader(bytes.NewReader(data), snapshot.SectionCache)
+	if err != nil {
+		return fmt.Errorf("mcache: %s: %w", name, err)
+	}
+	var payloads [][]byte
+	for {
+		section, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("mcache: %s: %w", name, err)
+		}
+		payloads = append(payloads, section.Payload)
+	}
+
+	// Decode the sections in parallel
+	sections := make([][]wal.Record, len(payloads))
+	bad := make([]bool, len(payloads))
+	c.parallel(len(payloads), func(idx int) {
+		payload := payloads[idx]
+		records := make([]wal.Record, len(payload)/wal.RecordSize)
+		for i := range records {
+			if !records[i].Decode(payload[i*wal.RecordSize:]) {
+				bad[idx] = true
+				return
+			}
+		}
+		sections[idx] = records
+	}, nil)
+	for idx := range bad {
+		if bad[idx] {
+			return fmt.Errorf("mcache: %s: bad record", name)
+		}
+	}
+
+	// Compact() writes the time record and the store records. I collect the
+	// entries and apply any other record in order
+	var entries []liveEntry
+	for _, records := range sections {
+		for _, record := range records {
+			if record.Op != wal.OpStore {
+				c.restoreEntries(entries)
+				entries = entries[:0]
+				r.apply(record)
+				continue
+			}
+			if e, ok := r.entry(record); ok {
+				entries = append(entries, liveEntry{e: e, o: Object(record.Object)})
+			}
+		}
+	}
+	c.restoreEntries(entries)
+	return nil
+}
+
+// Compact writes a snapshot of the cache and removes the log segments
+// and the snapshots which the new snapshot replaces
+// Compact allocates a copy of the eviction queue, see liveEntries()
+func (c *Cache) Compact() error {
+	if c.wal == nil {
+		return fmt.Errorf("mcache: WAL is not enabled")
+	}
+	if c.isClosed() {
+		return ErrClosed
+	}
+	// The records appended after the rotation can be in the snapshot too
+	// The replay of the log on top of the snapshot is idempotent
+	segment, err := c.wal.Rotate()
+	if err != nil {
+		return err
+	}
+
+	base := timeBase()
+	entries := c.liveEntries(TimeMs(base.ExpirationMs))
+	sections := c.encodeSections(base, entries)
+	index := encodeIndex(entries)
+	if err := c.writeSnapshot(segment, sections, index, len(entries)); err != nil {
+		return err
+	}
+	dir := c.configuration.WALDir
+	list, err := snapshots(dir)
+	if err != nil {
+		return err
+	}
+	for _, s := range list {
+		if s < segment {
+			if err := os.Remove(filepath.Join(dir, snapshotName(s))); err != nil {
+				return err
+			}
+		}
+	}
+	return wal.RemoveSegments(dir, segment)
+}
+
+// snapshotSectionEntries is the number of entries in a section of the
+// snapshot, 1.5MB. The restore decodes the sections in parallel
+const snapshotSectionEntries = 64 * 1024
+
+// encodeSections encodes the time record and the entries in parallel
+// The first section starts with the time record
+func (c *Cache) encodeSections(base wal.Record, entries []liveEntry) [][]byte {
+	count := (len(entries) + snapshotSectionEntries - 1) / snapshotSectionEntries
+	if count == 0 {
+		count = 1
+	}
+	sections := make([][]byte, count)
+	c.parallel(count, func(idx int) {
+		start := idx * snapshotSectionEntries
+		end := start + snapshotSectionEntries
+		if end > len(entries) {
+			end = len(entries)
+		}
+		size := end - start
+		if idx == 0 {
+			size++
+		}
+		payload := make([]byte, size*wal.RecordSize)
+		b := payload
+		if idx == 0 {
+			base.Encode(b)
+			b = b[wal.RecordSize:]
+		}
+		for _, entry := range entries[start:end] {
+			record := wal.Record{
+				Op:           wal.OpStore,
+				Key:          entry.e.Key,
+				Object:       uint32(entry.o),
+				ExpirationMs: entry.e.ExpirationMs,
+				Flags:        uint16(entry.e.Flags),
+			}
+			record.Encode(b)
+			b = b[wal.RecordSize:]
+		}
+		sections[idx] = payload
+	}, nil)
+	return sections
+}
+
+// encodeIndex returns the keys of the entries sorted for the binary search
+// in SnapshotView and the record numbers of the entries. The record number
+// counts the time record. A section keeps up to snapshotIndexEntries keys,
+// the keys of a section follow the keys of the previous section
+func encodeIndex(entries []liveEntry) [][]byte {
+	type keyRecord struct {
+		key    uint64
+		record uint32
+	}
+	keys := make([]keyRecord, len(entries))
+	for i, entry := range entries {
+		keys[i] = keyRecord{key: entry.e.Key, record: uint32(i + 1)}
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].key < keys[j].key })
+	index := make([][]byte, 0, len(keys)/snapshotIndexEntries+1)
+	for start := 0; start < len(keys) || start == 0; start += snapshotIndexEntries {
+		end := start + snapshotIndexEntries
+		if end > len(keys) {
+			end = len(keys)
+		}
+		section := make([]byte, (end-start)*snapshotIndexSize)
+		for i, k := range keys[start:end] {
+			b := section[i*snapshotIndexSize:]
+			binary.LittleEndian.PutUint64(b, k.key)
+			binary.LittleEndian.PutUint32(b[8:], k.record)
+		}
+		index = append(index, section)
+	}
+	return index
+}
+
+// writeSnapshot writes a temporary file and renames it. A crash leaves
+// either the old or the new snapshot
+// The optional index sections follow the cache sections, Open() skips them
+func (c *Cache) writeSnapshot(segment uint64, sections [][]byte, index [][]byte, total int) error {
+	dir := c.configuration.WALDir
+	name := filepath.Join(dir, snapshotName(segment))
+	tmp := name + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	config := []byte(strconv.Itoa(wal.RecordSize))
+	w, err := snapshot.NewWriter(file, config)
+	done := 0
+	for idx := 0; err == nil && idx < len(sections); idx++ {
+		err = w.WriteSection(snapshot.SectionCache, snapshot.FlagRequired, sections[idx])
+		done += len(sections[idx]) / wal.RecordSize
+		if idx == 0 {
+			// The time record
+			done--
+		}
+		c.progress(done, total)
+	}
+	for idx := 0; err == nil && idx < len(index); idx++ {
+		err = w.WriteSection(snapshot.SectionIndex, 0, index[idx])
+	}
+	if err == nil {
+		err = w.Close()
+	}
+	if err == nil {
+		err = file.Sync()
+	}
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, name)
+}
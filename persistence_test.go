@@ -0,0 +1,154 @@
+package mcache
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func openTemp(t *testing.T, dir string) *Cache {
+	c, err := Open(Configuration{Size: 100, TTL: 10 * 1000, WALDir: dir, WALSyncInterval: time.Millisecond})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	return c
+}
+
+func TestWALRestore(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "mcache")
+	defer os.RemoveAll(dir)
+	c := openTemp(t, dir)
+	now := GetTime()
+	for i := 0; i < 10; i++ {
+		c.Store(uint64(i), Object(i+100), now)
+	}
+	_, ref, _ := c.Load(3)
+	c.EvictByRef(ref)
+	// Expired after the restart
+	c.StoreWithFlags(20, 20, now-20*1000, 0)
+	if err := c.Close(context.Background()); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	c = openTemp(t, dir)
+	defer c.Close(context.Background())
+	for i := 0; i < 10; i++ {
+		o, _, ok := c.Load(uint64(i))
+		if i == 3 {
+			if ok {
+				t.Fatalf("Removed key %d is restored", i)
+			}
+			continue
+		}
+		if !ok || o != Object(i+100) {
+			t.Fatalf("Key %d is not restored %v %v", i, o, ok)
+		}
+	}
+	if _, _, ok := c.Load(20); ok {
+		t.Fatalf("Expired key is restored")
+	}
+	if c.Len() != 9 {
+		t.Fatalf("Got Len %d instead of 9", c.Len())
+	}
+}
+
+func TestWALCompact(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "mcache")
+	defer os.RemoveAll(dir)
+	c := openTemp(t, dir)
+	now := GetTime()
+	for i := 0; i < 10; i++ {
+		c.Store(uint64(i), Object(i), now)
+	}
+	if err := c.Compact(); err != nil {
+		t.Fatalf("%v", err)
+	}
+	c.Store(10, 10, now)
+	_, ref, _ := c.Load(0)
+	c.EvictByRef(ref)
+	c.Close(context.Background())
+
+	files, _ := ioutil.ReadDir(dir)
+	if len(files) != 2 {
+		t.Fatalf("Expected a snapshot and a segment, got %d files", len(files))
+	}
+
+	c = openTemp(t, dir)
+	defer c.Close(context.Background())
+	for i := 1; i <= 10; i++ {
+		if o, _, ok := c.Load(uint64(i)); !ok || o != Object(i) {
+			t.Fatalf("Key %d is not restored", i)
+		}
+	}
+	if _, _, ok := c.Load(0); ok {
+		t.Fatalf("Removed key is restored")
+	}
+}
+
+func TestWALReset(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "mcache")
+	defer os.RemoveAll(dir)
+	c := openTemp(t, dir)
+	c.Store(1, 1, GetTime())
+	c.Reset()
+	c.Store(2, 2, GetTime())
+	c.Close(context.Background())
+	c = openTemp(t, dir)
+	defer c.Close(context.Background())
+	if _, _, ok := c.Load(1); ok {
+		t.Fatalf("Key is restored after Reset")
+	}
+	if _, _, ok := c.Load(2); !ok {
+		t.Fatalf("Key is not restored")
+	}
+}
+
+func TestSnapshotSections(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "mcache")
+	defer os.RemoveAll(dir)
+	count := 3*snapshotSectionEntries + 10
+	var done, total int
+	configuration := Configuration{Size: count, TTL: 10 * 1000, WALDir: dir, SnapshotWorkers: 4,
+		SnapshotProgress: func(d, t int) { done, total = d, t }}
+	c, err := Open(configuration)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	now := GetTime()
+	for i := 0; i < count; i++ {
+		c.Store(uint64(i), Object(i), now)
+	}
+	if err := c.Compact(); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if done != count || total != count {
+		t.Fatalf("Compact progress %d/%d instead of %d", done, total, count)
+	}
+	c.Close(context.Background())
+
+	done, total = 0, 0
+	c, err = Open(configuration)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer c.Close(context.Background())
+	if done != count || total != count {
+		t.Fatalf("Restore progress %d/%d instead of %d", done, total, count)
+	}
+	if c.Len() != count {
+		t.Fatalf("Got Len %d instead of %d", c.Len(), count)
+	}
+	for i := 0; i < count; i++ {
+		if o, _, ok := c.Load(uint64(i)); !ok || o != Object(i) {
+			t.Fatalf("Key %d is not restored", i)
+		}
+	}
+	// The order of the queue survives the restore
+	for i := 0; i < 10; i++ {
+		if o, ok := c.Evict(now, true); !ok || o != Object(i) {
+			t.Fatalf("Evict returned %d instead of %d", o, i)
+		}
+	}
+}
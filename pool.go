@@ -0,0 +1,110 @@
+package mcache
+
+import (
+	"context"
+	"fmt"
+	"unsafe"
+
+	"github.com/larytet/mcachego/internal/fifo"
+)
+
+// The pool of the shard keeps the objects of the shard's entries. The Object
+// is an offset from the beginning of the pool
+// Evict(), EvictByRef(), EvictByObject() and Store() of an existing key free
+// the object. The application does not call Alloc()/Free()
+// The Object returned by Evict() is already free. The application can not
+// access the object after Evict()
+// The write-ahead log and the import do not support the pools: the log does
+// not keep the data of the objects. Store() of an Object which is not from
+// the pool fails with ErrPoolTemplate: eviction would free the Object to
+// the pool
+// The pool of a shard has a slot for every slot of the shard's hashtable,
+// Size*100/LoadFactor/Shards objects. With the default LoadFactor the pools
+// keep 2*Size objects. The keys are not spread evenly between the shards,
+// and I prefer the spare objects to a StoreNew() failing in a busy shard
+// The pool API is in uintptr. The pointers to the objects are derived from
+// the base of the pool by unsafe.Add(), see poolBase()
+
+// StoreNew allocates an object from the pool of the shard, calls 'init' and
+// stores the object in the cache. Returns false if the pool or the cache
+// is full
+// 'init' is called under the shard lock, Load() does not see a partially
+// initialized object. A closure capturing variables allocates, prepare
+// 'init' in advance
+// Like Store() StoreNew() consumes a token of the rate limiter and evicts on
+// store. StoreNew() does not coalesce
+func (c *Cache) StoreNew(key uint64, now TimeMs, init func(p unsafe.Pointer)) bool {
+	r := storeRequest{
+		e:    fifo.Entry{Key: key},
+		now:  now,
+		mode: storeLimit | storeWriteThrough | storeLog,
+		init: init,
+	}
+	result, _ := c.storeEntry(context.Background(), &r)
+	return result == storeOK
+}
+
+// storeNew allocates the object from the pool, calls init and stores the
+// object. Called with the shard locked
+func (c *Cache) storeNew(shard *shard, hash uint64, r *storeRequest) (storeResult, int) {
+	ptr, ok := shard.pool.Alloc()
+	if !ok {
+		return storePoolFull, -1
+	}
+	r.o = Object(ptr - shard.pool.GetBase())
+	r.init(objectPointer(shard, r.o))
+	ttl := c.ttl(r.e.Key, r.o)
+	r.e.ExpirationMs = int32(r.now + ttl)
+	result, count := c.storeLocked(shard, hash, r.e, r.o, ttl)
+	if result != storeOK {
+		shard.pool.Free(ptr)
+	}
+	return result, count
+}
+
+// Pointer returns the address of the object stored with the key
+// The object can be evicted and reused right after the lookup. Call
+// Pointer() from LoadCopy() if Evict() runs in another goroutine
+func (c *Cache) Pointer(key uint64, o Object) unsafe.Pointer {
+	shard, _ := c.shardOf(c.hash(key))
+	if shard.pool == nil {
+		return nil
+	}
+	if paranoid {
+		c.checkObject(shard, o)
+	}
+	return objectPointer(shard, o)
+}
+
+// poolBase returns the base of the pool as a pointer
+// unsafe.Pointer(uintptr) of an arbitrary uintptr is what go vet and the
+// checkptr instrumentation of -race reject. I copy the address to a pointer
+// once, the pool keeps the memory alive
+func poolBase(shard *shard) unsafe.Pointer {
+	base := shard.pool.GetBase()
+	return *(*unsafe.Pointer)(unsafe.Pointer(&base))
+}
+
+// objectPointer returns the address of the object of the pool
+func objectPointer(shard *shard, o Object) unsafe.Pointer {
+	return unsafe.Add(poolBase(shard), uintptr(o))
+}
+
+// poolFree is called with the shard locked
+func (c *Cache) poolFree(shard *shard, o Object) {
+	if paranoid {
+		c.checkObject(shard, o)
+	}
+	if !shard.pool.Free(shard.pool.GetBase()+uintptr(o)) && paranoid {
+		panic(fmt.Sprintf("mcache: failed to free object %d, double free?", o))
+	}
+}
+
+// poolFreeKey frees the object of the key if the key is in the table
+// Called with the shard locked
+func (c *Cache) poolFreeKey(shard *shard, key uint64, hash uint64) {
+	if iValue, ok, _ := shard.table.Load(key, hash); ok {
+		i := *(*item)(unsafe.Pointer(&iValue))
+		c.poolFree(shard, i.o)
+	}
+}
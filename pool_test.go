@@ -0,0 +1,119 @@
+package mcache
+
+import (
+	"reflect"
+	"testing"
+	"unsafe"
+)
+
+type poolData struct {
+	a, b uint64
+}
+
+func TestStoreNew(t *testing.T) {
+	cache := New(Configuration{Size: 2, TTL: TTL, LoadFactor: 100, Shards: 1,
+		PoolTemplate: reflect.TypeOf(new(poolData))})
+	now := GetTime()
+	value := uint64(1)
+	init := func(p unsafe.Pointer) {
+		(*poolData)(p).a = value
+	}
+	if !cache.StoreNew(1, now, init) {
+		t.Fatalf("Failed to store")
+	}
+	value = 2
+	// Overwrite returns the first object to the pool
+	if !cache.StoreNew(1, now, init) {
+		t.Fatalf("Failed to store")
+	}
+	o, _, ok := cache.Load(1)
+	if !ok {
+		t.Fatalf("Failed to load")
+	}
+	if data := (*poolData)(cache.Pointer(1, o)); data.a != 2 {
+		t.Fatalf("Got %d instead of 2", data.a)
+	}
+	// The FIFO entry of the first Store is a tombstone
+	if cache.Len() != 1 {
+		t.Fatalf("Got Len %d instead of 1", cache.Len())
+	}
+	if !cache.StoreNew(2, now, init) {
+		t.Fatalf("The pool leaks objects")
+	}
+	if cache.StoreNew(3, now, init) {
+		t.Fatalf("Did not fail on overflow")
+	}
+	// Evict() frees the object
+	if _, expired := cache.Evict(now+TTL, false); !expired {
+		t.Fatalf("Failed to evict")
+	}
+	if !cache.StoreNew(3, now+TTL, init) {
+		t.Fatalf("Evict did not free the object")
+	}
+	_, ref, _ := cache.Load(2)
+	cache.EvictByRef(ref)
+	if !cache.StoreNew(4, now+TTL, init) {
+		t.Fatalf("EvictByRef did not free the object")
+	}
+}
+
+func TestStoreNewNoPool(t *testing.T) {
+	cache := New(Configuration{Size: 2, TTL: TTL})
+	if cache.StoreNew(1, GetTime(), func(unsafe.Pointer) {}) {
+		t.Fatalf("Stored without a pool")
+	}
+	if cache.Pointer(1, 0) != nil {
+		t.Fatalf("Got a pointer without a pool")
+	}
+}
+
+func TestStoreWithPool(t *testing.T) {
+	cache := New(Configuration{Size: 2, TTL: TTL, Shards: 1,
+		PoolTemplate: reflect.TypeOf(new(poolData))})
+	if cache.Store(1, 1, GetTime()) {
+		t.Fatalf("Stored an object which is not from the pool")
+	}
+	if err := cache.StoreE(1, 1, GetTime(), 0); err != ErrPoolTemplate {
+		t.Fatalf("Got %v instead of %v", err, ErrPoolTemplate)
+	}
+	if cache.Len() != 0 {
+		t.Fatalf("Got Len %d instead of 0", cache.Len())
+	}
+}
+
+// Compare with BenchmarkAllocStoreEvictFree
+func BenchmarkStoreNewEvict(b *testing.B) {
+	b.ReportAllocs()
+	cache := New(Configuration{Size: b.N, TTL: TTL, LoadFactor: 50,
+		PoolTemplate: reflect.TypeOf(new(MyData))})
+	now := GetTime()
+	init := func(unsafe.Pointer) {}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if !cache.StoreNew(uint64(b.N-i), now, init) {
+			b.Fatalf("Failed to add item %d", i)
+		}
+	}
+	now += 1000*1000*TTL + 1
+	for i := 0; i < b.N; i++ {
+		if _, expired := cache.Evict(now, false); !expired {
+			b.Fatalf("Failed to evict %v", i)
+		}
+	}
+}
+
+func TestStoreNewBookkeeping(t *testing.T) {
+	backend := newMapBackend()
+	cache := New(Configuration{Size: 2, TTL: TTL, LoadFactor: 100, Shards: 1, Histograms: true,
+		Backend: backend, PoolTemplate: reflect.TypeOf(new(poolData))})
+	if !cache.StoreNew(1, 0, func(unsafe.Pointer) {}) {
+		t.Fatalf("Failed to store")
+	}
+	if h := cache.GetHistograms(); h.TTL.Count() != 1 {
+		t.Fatalf("Got %d TTLs instead of 1", h.TTL.Count())
+	}
+	// The backend does not get the offsets in the pool
+	if _, _, ok := backend.load(1); ok {
+		t.Fatalf("StoreNew wrote the object through")
+	}
+}
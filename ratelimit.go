@@ -0,0 +1,36 @@
+package mcache
+
+// tokenScale - the bucket keeps thousandths of a token. The refill is
+// elapsed ms * tokens/s, no division in Store()
+const tokenScale = 1000
+
+// admit refills the token bucket of the shard and takes a token
+// A runaway upstream can not churn the whole cache in seconds and destroy
+// the hit rate of the steady state traffic
+// I use the 'now' of the application, the cache does not call the clock
+// Called with the shard locked
+func (c *Cache) admit(shard *shard, now TimeMs) bool {
+	if c.rateLimit == 0 {
+		return true
+	}
+	elapsed := now - shard.lastRefill
+	if elapsed > 0 {
+		tokens := int64(shard.tokens) + int64(elapsed)*int64(c.rateLimit)
+		if tokens > int64(c.rateBurst) {
+			tokens = int64(c.rateBurst)
+		}
+		shard.tokens = int32(tokens)
+		shard.lastRefill = now
+	} else if elapsed < 0 {
+		// The first call or the application's time went backwards
+		shard.lastRefill = now
+	}
+	if shard.tokens < tokenScale {
+		if shard.statistics.sample(c.statisticsMask) {
+			shard.statistics.RejectedByRateLimit++
+		}
+		return false
+	}
+	shard.tokens -= tokenScale
+	return true
+}
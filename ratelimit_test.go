@@ -0,0 +1,59 @@
+package mcache
+
+import (
+	"testing"
+)
+
+func TestStoreRateLimit(t *testing.T) {
+	cache := New(Configuration{Size: 10000, TTL: TTL, Shards: 1, StoreRateLimit: 1000, StoreBurst: 10})
+	now := GetTime()
+	stored := 0
+	for i := 0; i < 100; i++ {
+		if cache.Store(uint64(i), Object(i), now) {
+			stored++
+		}
+	}
+	if stored != 10 {
+		t.Fatalf("Stored %d instead of the burst 10", stored)
+	}
+	// 1000 stores/s is one store per ms
+	now += 5
+	stored = 0
+	for i := 0; i < 100; i++ {
+		if cache.Store(uint64(i), Object(i), now) {
+			stored++
+		}
+	}
+	if stored != 5 {
+		t.Fatalf("Stored %d instead of 5", stored)
+	}
+	if s := cache.GetStatistics(); s.RejectedByRateLimit != 185 {
+		t.Fatalf("Got %d rejections instead of 185", s.RejectedByRateLimit)
+	}
+	// The bucket does not grow above the burst
+	now += 1000 * 1000
+	stored = 0
+	for i := 0; i < 100; i++ {
+		if cache.StoreNoAlloc(uint64(i), Object(i), now) {
+			stored++
+		}
+	}
+	if stored != 10 {
+		t.Fatalf("Stored %d instead of the burst 10", stored)
+	}
+}
+
+func TestStoreRateLimitTimeWrap(t *testing.T) {
+	cache := New(Configuration{Size: 100, TTL: TTL, Shards: 1, StoreRateLimit: 1000, StoreBurst: 1})
+	// A negative time stamp is fine
+	now := TimeMs(-1 << 30)
+	if !cache.Store(1, 1, now) {
+		t.Fatalf("Failed to store")
+	}
+	if cache.Store(2, 2, now) {
+		t.Fatalf("Did not fail on the rate limit")
+	}
+	if !cache.Store(2, 2, now+1) {
+		t.Fatalf("The bucket is not refilled")
+	}
+}
@@ -0,0 +1,73 @@
+package mcache
+
+import (
+	"math"
+	"sync"
+)
+
+// Rates are moving averages of the eviction counters
+// An autoscaler reacts to the cache pressure without scraping the counters
+// and differentiating them
+type Rates struct {
+	// Evictions per second, expired and forced
+	Evictions float64
+	// Forced evictions per second
+	Forced float64
+	// Forced evictions out of all evictions, from 0 to 1. A high ratio means
+	// that the entries die from the lack of space and not from the TTL
+	ForcedRatio float64
+}
+
+// rates keeps the state of UpdateRates()
+type rates struct {
+	mutex sync.Mutex
+	Rates
+	last   Statistics
+	lastMs TimeMs
+	ready  bool
+}
+
+// defaultRatesWindow is the time constant of the moving average, ms
+const defaultRatesWindow = 10000
+
+// UpdateRates samples the statistics and updates the moving averages
+// Call UpdateRates() from the evictor goroutine, for example, once a second
+// The weight of a sample depends on the time since the previous call, the
+// interval does not have to be regular. See Configuration.RatesWindow
+func (c *Cache) UpdateRates(now TimeMs) {
+	s := c.GetStatistics()
+	r := &c.rates
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	elapsed := now - r.lastMs
+	// The first call or Reset() - the counters start from zero
+	if !r.ready || s.EvictExpired < r.last.EvictExpired || s.EvictForce < r.last.EvictForce {
+		r.last, r.lastMs, r.ready = s, now, true
+		return
+	}
+	if elapsed <= 0 {
+		return
+	}
+	window := c.configuration.RatesWindow
+	if window <= 0 {
+		window = defaultRatesWindow
+	}
+	alpha := 1 - math.Exp(-float64(elapsed)/float64(window))
+	seconds := float64(elapsed) / 1000
+	evictions := float64(s.EvictExpired-r.last.EvictExpired) / seconds
+	forced := float64(s.EvictForce-r.last.EvictForce) / seconds
+	r.Evictions += alpha * (evictions - r.Evictions)
+	r.Forced += alpha * (forced - r.Forced)
+	r.ForcedRatio = 0
+	if r.Evictions > 0 {
+		r.ForcedRatio = r.Forced / r.Evictions
+	}
+	r.last, r.lastMs = s, now
+}
+
+// GetRates returns the moving averages computed by UpdateRates()
+func (c *Cache) GetRates() Rates {
+	c.rates.mutex.Lock()
+	defer c.rates.mutex.Unlock()
+	return c.rates.Rates
+}
@@ -0,0 +1,40 @@
+package mcache
+
+import (
+	"math"
+	"testing"
+)
+
+func TestRates(t *testing.T) {
+	cache := New(Configuration{Size: 100, TTL: 100, LoadFactor: 100, RatesWindow: 1000})
+	cache.UpdateRates(0)
+	for i := 0; i < 100; i++ {
+		cache.Store(uint64(i), Object(i), 0)
+	}
+	for i := 0; i < 25; i++ {
+		cache.Evict(0, true)
+	}
+	for i := 0; i < 75; i++ {
+		cache.Evict(200, false)
+	}
+	cache.UpdateRates(1000)
+	r := cache.GetRates()
+	alpha := 1 - math.Exp(-1)
+	if math.Abs(r.Evictions-100*alpha) > 0.01 || math.Abs(r.Forced-25*alpha) > 0.01 {
+		t.Fatalf("Bad rates %+v", r)
+	}
+	if math.Abs(r.ForcedRatio-0.25) > 0.01 {
+		t.Fatalf("Got forced ratio %f instead of 0.25", r.ForcedRatio)
+	}
+	// No evictions, the rates decay
+	cache.UpdateRates(2000)
+	if cache.GetRates().Evictions >= r.Evictions {
+		t.Fatalf("Rate did not decay %+v", cache.GetRates())
+	}
+	// Reset() restarts the counters
+	cache.Reset()
+	cache.UpdateRates(3000)
+	if cache.GetRates().Evictions <= 0 {
+		t.Fatalf("Rates after Reset %+v", cache.GetRates())
+	}
+}
@@ -0,0 +1,184 @@
+package mcache
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/larytet/mcachego/internal/wal"
+	"github.com/larytet/mcachego/snapshot"
+)
+
+// snapshotIndexSize is the size of an entry of the index section
+//
+//	key uint64, record uint32
+const snapshotIndexSize = 12
+
+// snapshotIndexEntries is the number of keys in an index section. A var for
+// the tests
+var snapshotIndexEntries = snapshot.MaxSectionSize / snapshotIndexSize
+
+// SnapshotView serves lookups from a snapshot file mapped to the memory
+// The view is for large static datasets, for example, categorization lists:
+// OpenSnapshotReadOnly() does not copy or decode the entries and Load()
+// reads the pages of the file on demand
+// The view does not evict. Load() returns the expiration time of the entry
+// when the snapshot was written, the application decides
+// SnapshotView is safe for concurrent use
+type SnapshotView struct {
+	data []byte
+	// Sorted keys and the record numbers, see encodeIndex()
+	index    [][]byte
+	entries  int
+	sections [][]byte
+	// Record number of the first record of every section
+	starts []int
+	// Wall clock ms minus the monotonic time of the process which wrote
+	// the snapshot
+	wallOffset int64
+}
+
+// OpenSnapshotReadOnly maps the snapshot written by Compact()
+// I verify the checksum of the index, the checksums of the records are
+// verified by Load(). A snapshot written before the index section was
+// added returns an error - run Compact() to rewrite it
+func OpenSnapshotReadOnly(path string) (*SnapshotView, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if info.Size() == 0 || int64(int(info.Size())) != info.Size() {
+		return nil, fmt.Errorf("mcache: %s: bad size %d", path, info.Size())
+	}
+	data, err := mmapFile(file, int(info.Size()))
+	if err != nil {
+		return nil, err
+	}
+	v, err := newSnapshotView(data)
+	if err != nil {
+		munmapFile(data)
+		return nil, fmt.Errorf("mcache: %s: %w", path, err)
+	}
+	return v, nil
+}
+
+func newSnapshotView(data []byte) (*SnapshotView, error) {
+	known := []snapshot.SectionID{snapshot.SectionCache, snapshot.SectionIndex}
+	m, err := snapshot.Map(data, known, []snapshot.SectionID{snapshot.SectionIndex})
+	if err != nil {
+		return nil, err
+	}
+	if string(m.Config) != strconv.Itoa(wal.RecordSize) {
+		return nil, fmt.Errorf("unsupported record size %q", m.Config)
+	}
+	v := &SnapshotView{data: data}
+	records := 0
+	for _, section := range m.Sections {
+		switch section.ID {
+		case snapshot.SectionCache:
+			if len(section.Payload)%wal.RecordSize != 0 {
+				return nil, fmt.Errorf("bad section size %d", len(section.Payload))
+			}
+			v.sections = append(v.sections, section.Payload)
+			v.starts = append(v.starts, records)
+			records += len(section.Payload) / wal.RecordSize
+		case snapshot.SectionIndex:
+			if len(section.Payload)%snapshotIndexSize != 0 {
+				return nil, fmt.Errorf("bad index section size %d", len(section.Payload))
+			}
+			v.index = append(v.index, section.Payload)
+			v.entries += len(section.Payload) / snapshotIndexSize
+		}
+	}
+	if len(v.index) == 0 {
+		return nil, fmt.Errorf("no index section")
+	}
+	var base wal.Record
+	if len(v.sections) == 0 || !base.Decode(v.sections[0]) || base.Op != wal.OpTime {
+		return nil, fmt.Errorf("no time record")
+	}
+	v.wallOffset = int64(base.Key) - int64(base.ExpirationMs)
+	return v, nil
+}
+
+// Len returns the number of entries
+func (v *SnapshotView) Len() int {
+	return v.entries
+}
+
+// Load performs a binary search in the index and decodes the record
+// A corrupted record is not found
+func (v *SnapshotView) Load(key uint64) (o Object, expiration time.Time, ok bool) {
+	// The first index section which ends with a key not below the key
+	var index []byte
+	for _, section := range v.index {
+		last := len(section) - snapshotIndexSize
+		if last >= 0 && binary.LittleEndian.Uint64(section[last:]) >= key {
+			index = section
+			break
+		}
+	}
+	count := len(index) / snapshotIndexSize
+	lo, hi := 0, count
+	for lo < hi {
+		mid := int(uint(lo+hi) >> 1)
+		if binary.LittleEndian.Uint64(index[mid*snapshotIndexSize:]) < key {
+			lo = mid + 1
+		} else {
+			hi = mid
+		}
+	}
+	if lo == count {
+		return 0, expiration, false
+	}
+	b := index[lo*snapshotIndexSize:]
+	if binary.LittleEndian.Uint64(b) != key {
+		return 0, expiration, false
+	}
+	record, ok := v.record(int(binary.LittleEndian.Uint32(b[8:])))
+	if !ok || record.Op != wal.OpStore || record.Key != key {
+		return 0, expiration, false
+	}
+	ms := int64(record.ExpirationMs) + v.wallOffset
+	return Object(record.Object), time.Unix(0, ms*int64(time.Millisecond)), true
+}
+
+// record decodes the record by the record number
+func (v *SnapshotView) record(n int) (record wal.Record, ok bool) {
+	// The last section which starts at or before n
+	lo, hi := 0, len(v.starts)
+	for lo < hi {
+		mid := int(uint(lo+hi) >> 1)
+		if v.starts[mid] <= n {
+			lo = mid + 1
+		} else {
+			hi = mid
+		}
+	}
+	if lo == 0 {
+		return record, false
+	}
+	section := v.sections[lo-1]
+	offset := (n - v.starts[lo-1]) * wal.RecordSize
+	if offset >= len(section) {
+		return record, false
+	}
+	return record, record.Decode(section[offset:])
+}
+
+// Close unmaps the file. The view can not be used after Close()
+func (v *SnapshotView) Close() error {
+	data := v.data
+	v.data, v.index, v.sections = nil, nil, nil
+	if data == nil {
+		return nil
+	}
+	return munmapFile(data)
+}
@@ -0,0 +1,80 @@
+package mcache
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSnapshotView(t *testing.T) {
+	testSnapshotView(t, snapshotSectionEntries+10)
+}
+
+func TestSnapshotViewSplitIndex(t *testing.T) {
+	defer func(entries int) { snapshotIndexEntries = entries }(snapshotIndexEntries)
+	snapshotIndexEntries = 7
+	testSnapshotView(t, 50)
+}
+
+func testSnapshotView(t *testing.T, count int) {
+	dir, _ := ioutil.TempDir("", "mcache")
+	defer os.RemoveAll(dir)
+	c, err := Open(Configuration{Size: count, TTL: 10 * 1000, WALDir: dir})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	// The stores and Compact() under -race take a while
+	expected := time.Now().Add(10 * time.Second)
+	now := GetTime()
+	for i := 0; i < count; i++ {
+		// The index sorts the keys
+		c.Store(uint64(count-i)*3, Object(i), now)
+	}
+	if err := c.Compact(); err != nil {
+		t.Fatalf("%v", err)
+	}
+	c.Close(context.Background())
+
+	list, _ := snapshots(dir)
+	v, err := OpenSnapshotReadOnly(filepath.Join(dir, snapshotName(list[len(list)-1])))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer v.Close()
+	if v.Len() != count {
+		t.Fatalf("Got Len %d instead of %d", v.Len(), count)
+	}
+	for i := 0; i < count; i++ {
+		o, expiration, ok := v.Load(uint64(count-i) * 3)
+		if !ok || o != Object(i) {
+			t.Fatalf("Key %d is not found", (count-i)*3)
+		}
+		if d := expected.Sub(expiration); d < -time.Second || d > time.Second {
+			t.Fatalf("Bad expiration %v, expected %v", expiration, expected)
+		}
+	}
+	for _, key := range []uint64{0, 1, 4, uint64(count)*3 + 1} {
+		if _, _, ok := v.Load(key); ok {
+			t.Fatalf("Found key %d", key)
+		}
+	}
+}
+
+func TestSnapshotViewNoIndex(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "mcache")
+	defer os.RemoveAll(dir)
+	c := openTemp(t, dir)
+	defer c.Close(context.Background())
+	c.Store(1, 1, GetTime())
+	base := timeBase()
+	entries := c.liveEntries(TimeMs(base.ExpirationMs))
+	if err := c.writeSnapshot(1, c.encodeSections(base, entries), nil, len(entries)); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if _, err := OpenSnapshotReadOnly(filepath.Join(dir, snapshotName(1))); err == nil {
+		t.Fatalf("Opened a snapshot without the index")
+	}
+}
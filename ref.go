@@ -0,0 +1,35 @@
+package mcache
+
+// ItemRef is used for fast eviction of entries
+// If ItemRef is a struct with two 64 bits fields I see 10ns overhead
+// Can I return a single 64 bits word?
+// hashtableRef can be 32 bits offset from the beginning of the hash
+// TBD What if ItemRef is a struct of two 32 bits words?
+// fifoSeq allows to remove the entry from the eviction FIFO
+// All packing and unpacking of the refs is in this file
+type ItemRef struct {
+	tableIdx uint32
+	shardIdx uint32
+	fifoSeq  uint32
+}
+
+// newItemRef packs the location of the entry
+// tableIdx is the ref returned by the hashtable of the shard
+func newItemRef(shardIdx uint64, tableIdx uint32, fifoSeq uint32) ItemRef {
+	return ItemRef{
+		tableIdx: tableIdx,
+		shardIdx: uint32(shardIdx),
+		fifoSeq:  fifoSeq,
+	}
+}
+
+// refShard returns the shard of the ref
+// Returns false if the ref does not belong to this cache
+// I can save the bounds check if I compose ItemRef from the shard address
+// instead of index
+func (c *Cache) refShard(ref ItemRef) (*shard, bool) {
+	if uint64(ref.shardIdx) >= uint64(len(c.shards)) {
+		return nil, false
+	}
+	return c.shards[ref.shardIdx], true
+}
@@ -0,0 +1,41 @@
+package mcache
+
+import (
+	"testing"
+)
+
+func TestEvictByStaleRef(t *testing.T) {
+	cache := New(Configuration{Size: 4, TTL: 100, LoadFactor: 100, Shards: 1})
+	cache.Store(1, 1, 0)
+	_, ref, _ := cache.Load(1)
+	cache.EvictByRef(ref)
+	// The slot of the key 1 is free and the key 2 can take it
+	cache.Store(2, 2, 0)
+	cache.EvictByRef(ref)
+	if _, _, ok := cache.Load(2); !ok {
+		t.Fatalf("A stale ref removed another entry")
+	}
+	cache.EvictByRef(newItemRef(uint64(cache.Shards()), 0, 0))
+	if cache.Len() != 1 {
+		t.Fatalf("Got Len %d instead of 1", cache.Len())
+	}
+}
+
+func TestEvictByStaleRefHeap(t *testing.T) {
+	cache := New(Configuration{Size: 4, TTL: 100, LoadFactor: 100, Shards: 1, ExpiryIndex: ExpiryIndexHeap})
+	cache.Store(1, 1, 0)
+	_, ref, _ := cache.Load(1)
+	cache.EvictByRef(ref)
+	// The heap reuses the handle of the key 1 for the key 2
+	cache.Store(2, 2, 0)
+	cache.EvictByRef(ref)
+	if _, _, ok := cache.Load(2); !ok {
+		t.Fatalf("A stale ref removed another entry")
+	}
+	if cache.Len() != 1 {
+		t.Fatalf("Got Len %d instead of 1", cache.Len())
+	}
+	if o, ok := cache.Evict(100, false); !ok || o != 2 {
+		t.Fatalf("Failed to evict the key 2, got %d", o)
+	}
+}
@@ -0,0 +1,141 @@
+package mcache
+
+import (
+	"sync"
+	"unsafe"
+
+	"github.com/larytet/mcachego/keyhash"
+)
+
+// reverseIndex maps an object to the key
+// The application frees objects upstream and needs to find the cache entry
+// pointing to the object without scanning the whole table
+// I do not remove mappings in EvictByRef() - the ref does not tell me the
+// object. I check every mapping against the table in the lookup instead
+// Store() and Evict() update the index. A single map behind a single mutex
+// serializes the shards. The index is split to stripes by the hash of the
+// object, a stripe for every shard. A lookup by object knows the stripe and
+// does not know the shard. Every update costs a map write, 50-100ns
+type reverseIndex struct {
+	stripes []reverseStripe
+	mask    uint64
+}
+
+// reverseStripe is 128 bytes, the stripes do not share the cache lines
+type reverseStripe struct {
+	mutex sync.Mutex
+	keys  map[Object]uint64
+	_     [128 - 16]byte
+}
+
+func newReverseIndex(size int, stripes int) *reverseIndex {
+	r := &reverseIndex{
+		stripes: make([]reverseStripe, stripes),
+		mask:    uint64(stripes) - 1,
+	}
+	for i := range r.stripes {
+		r.stripes[i].keys = make(map[Object]uint64, size/stripes)
+	}
+	return r
+}
+
+// stripe returns the stripe of the object. The number of stripes is a power
+// of 2
+func (r *reverseIndex) stripe(o Object) *reverseStripe {
+	return &r.stripes[keyhash.Uint64(uint64(o))&r.mask]
+}
+
+func (r *reverseIndex) reset() {
+	for i := range r.stripes {
+		stripe := &r.stripes[i]
+		stripe.mutex.Lock()
+		stripe.keys = make(map[Object]uint64, len(stripe.keys))
+		stripe.mutex.Unlock()
+	}
+}
+
+func (r *reverseIndex) store(o Object, key uint64) {
+	stripe := r.stripe(o)
+	stripe.mutex.Lock()
+	stripe.keys[o] = key
+	stripe.mutex.Unlock()
+}
+
+func (r *reverseIndex) load(o Object) (key uint64, ok bool) {
+	stripe := r.stripe(o)
+	stripe.mutex.Lock()
+	key, ok = stripe.keys[o]
+	stripe.mutex.Unlock()
+	return key, ok
+}
+
+// remove the mapping if the object still points to the key
+func (r *reverseIndex) remove(o Object, key uint64) {
+	stripe := r.stripe(o)
+	stripe.mutex.Lock()
+	if k, ok := stripe.keys[o]; ok && k == key {
+		delete(stripe.keys, o)
+	}
+	stripe.mutex.Unlock()
+}
+
+// LoadByObject returns the key of the entry keeping the object
+// Requires Configuration.ReverseIndex
+func (c *Cache) LoadByObject(o Object) (key uint64, ok bool) {
+	if c.reverse == nil {
+		return 0, false
+	}
+	key, ok = c.reverse.load(o)
+	if !ok {
+		return 0, false
+	}
+	hash := c.hash(key)
+	shard, _ := c.shardOf(hash)
+	c.rlock(shard)
+	iValue, ok, _ := shard.table.Load(key, hash)
+	c.runlock(shard)
+	if ok {
+		i := *(*item)(unsafe.Pointer(&iValue))
+		ok = (i.o == o)
+	}
+	if !ok {
+		// The entry was overwritten or removed by EvictByRef()
+		c.reverse.remove(o, key)
+	}
+	return key, ok
+}
+
+// EvictByObject removes the entry keeping the object
+// Requires Configuration.ReverseIndex
+// Like EvictByRef() this API marks the FIFO entry of the key as a tombstone
+func (c *Cache) EvictByObject(o Object) (key uint64, ok bool) {
+	if c.reverse == nil {
+		return 0, false
+	}
+	key, ok = c.reverse.load(o)
+	if !ok {
+		return 0, false
+	}
+	hash := c.hash(key)
+	shard, _ := c.shardOf(hash)
+	c.lock(shard)
+	iValue, ok, ref := shard.table.Load(key, hash)
+	i := *(*item)(unsafe.Pointer(&iValue))
+	if ok && i.o == o {
+		if shard.pool != nil {
+			c.poolFree(shard, o)
+		}
+		shard.table.RemoveByRef(ref)
+		c.lockQueue()
+		c.queue.Tombstone(i.fifoSeq)
+		c.unlockQueue()
+		if c.wal != nil {
+			c.logDelete(key)
+		}
+	} else {
+		ok = false
+	}
+	c.unlock(shard)
+	c.reverse.remove(o, key)
+	return key, ok
+}
@@ -0,0 +1,50 @@
+package mcache
+
+import (
+	"testing"
+	"unsafe"
+)
+
+func TestReverseStripeSize(t *testing.T) {
+	if size := unsafe.Sizeof(reverseStripe{}); size != 128 {
+		t.Fatalf("Stripe is %d bytes instead of 128", size)
+	}
+}
+
+func TestReverseIndex(t *testing.T) {
+	var smallCache = New(Configuration{Size: 2, TTL: TTL, LoadFactor: 100, ReverseIndex: true})
+	now := GetTime()
+	smallCache.Store(1, 10, now)
+	smallCache.Store(2, 20, now)
+	key, ok := smallCache.LoadByObject(20)
+	if !ok {
+		t.Fatalf("Failed to find object in the reverse index")
+	}
+	if key != 2 {
+		t.Fatalf("Got key %d instead of %d", key, 2)
+	}
+	key, ok = smallCache.EvictByObject(10)
+	if !ok || key != 1 {
+		t.Fatalf("Failed to evict object %d, key %d", 10, key)
+	}
+	if _, _, ok = smallCache.Load(1); ok {
+		t.Fatalf("Entry is in the cache after EvictByObject")
+	}
+	if _, ok = smallCache.LoadByObject(10); ok {
+		t.Fatalf("Object is in the reverse index after EvictByObject")
+	}
+
+	_, ref, _ := smallCache.Load(2)
+	smallCache.EvictByRef(ref)
+	if _, ok = smallCache.LoadByObject(20); ok {
+		t.Fatalf("Object is in the reverse index after EvictByRef")
+	}
+}
+
+func TestReverseIndexDisabled(t *testing.T) {
+	var smallCache = New(Configuration{Size: 1, TTL: TTL, LoadFactor: 100})
+	smallCache.Store(1, 10, GetTime())
+	if _, ok := smallCache.LoadByObject(10); ok {
+		t.Fatalf("Found object without reverse index")
+	}
+}
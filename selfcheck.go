@@ -0,0 +1,224 @@
+package mcache
+
+import (
+	"context"
+	"time"
+	"unsafe"
+
+	"github.com/larytet/mcachego/internal/fifo"
+)
+
+// SelfCheckReport is the result of SelfCheck()
+type SelfCheckReport struct {
+	// Queue entries checked
+	Checked int
+	// Queue entries of the removed or overwritten keys. Evict() skips them,
+	// but they occupy the queue. The repair tombstones them
+	Orphans int
+	// Table entries without a queue entry. Evict() never finds them - a
+	// memory leak. The repair removes them. The hashtable has no iterator,
+	// I find such entries by the Orphans of the same key
+	Lost int
+	// Objects outside of the pool of the shard. Reported, not repaired
+	BadObjects int
+	// Objects allocated from the pools minus the entries which keep the
+	// objects, including the stale entries. Positive is a leak, negative
+	// is a double free. Reported, not repaired
+	PoolLeaked int
+}
+
+// Ok returns true if the check found nothing
+func (r SelfCheckReport) Ok() bool {
+	return r.Orphans == 0 && r.Lost == 0 && r.BadObjects == 0 && r.PoolLeaked == 0
+}
+
+// selfCheckSample is the number of queue entries StartSelfCheck() checks
+// every interval
+const selfCheckSample = 4096
+
+// SelfCheck compares up to 'max' queue entries with the tables. If the
+// queue keeps more than 'max' entries SelfCheck() picks the entries at
+// random, the calls cover the whole queue and all shards over time
+// I copy the entries under the queue lock and check the entries one by one
+// under the shard lock, Store() and Evict() are not blocked for long. The
+// pool check locks all shards once
+// If 'repair' is true SelfCheck() fixes the Orphans and the Lost entries
+func (c *Cache) SelfCheck(max int, repair bool) SelfCheckReport {
+	type queued struct {
+		seq uint32
+		e   fifo.Entry
+	}
+	var report SelfCheckReport
+	if c.isClosed() {
+		return report
+	}
+	sample := make([]queued, 0, max)
+	c.lockQueue()
+	if c.queue.Len() <= max {
+		c.queue.Range(func(seq uint32, e fifo.Entry) bool {
+			sample = append(sample, queued{seq, e})
+			return len(sample) < max
+		})
+	} else {
+		// At() fails for the tombstones, I limit the retries
+		for retries := 2 * max; len(sample) < max && retries > 0; retries-- {
+			if seq, e, ok := c.queue.At(c.selfCheckRand.next()); ok {
+				sample = append(sample, queued{seq, e})
+			}
+		}
+	}
+	c.unlockQueue()
+
+	repaired := false
+	for _, q := range sample {
+		report.Checked++
+		key := q.e.Key
+		hash := c.hash(key)
+		shard, _ := c.shardOf(hash)
+		c.lock(shard)
+		iValue, found, hashtableRef := shard.table.Load(key, hash)
+		i := *(*item)(unsafe.Pointer(&iValue))
+		c.lockQueue()
+		e, ok := c.queue.Get(q.seq)
+		if !ok || e.Key != key {
+			// Evicted after the copy
+			c.unlockQueue()
+			c.unlock(shard)
+			continue
+		}
+		lost := false
+		if found && i.fifoSeq != q.seq {
+			e, ok := c.queue.Get(i.fifoSeq)
+			lost = !ok || e.Key != key
+		}
+		orphan := !found || i.fifoSeq != q.seq
+		if orphan {
+			report.Orphans++
+			if repair {
+				c.queue.Tombstone(q.seq)
+			}
+		}
+		c.unlockQueue()
+		if lost {
+			report.Lost++
+			if repair {
+				c.removeLost(shard, key, i.o, hashtableRef)
+			}
+		}
+		if found && !orphan && shard.pool != nil && !shard.pool.Belongs(shard.pool.GetBase()+uintptr(i.o)) {
+			report.BadObjects++
+		}
+		c.unlock(shard)
+		repaired = repaired || (repair && (orphan || lost))
+	}
+	report.PoolLeaked = c.checkPools()
+	if repaired && c.watermarks != nil {
+		c.checkWatermarks(c.Len())
+	}
+	if !report.Ok() && c.logger.Enabled(LogWarning) {
+		c.logger.Log(LogWarning, "Self check failed",
+			LogField{"orphans", report.Orphans}, LogField{"lost", report.Lost},
+			LogField{"badObjects", report.BadObjects},
+			LogField{"poolLeaked", report.PoolLeaked})
+	}
+	return report
+}
+
+// checkPools returns the objects allocated from the pools minus the entries
+// I lock all shards, the counters of the pools and the queues do not change
+// during the check
+func (c *Cache) checkPools() int {
+	if c.configuration.PoolTemplate == nil {
+		return 0
+	}
+	outstanding := 0
+	for _, shard := range c.shards {
+		c.lock(shard)
+		s := shard.pool.GetStatistics()
+		outstanding += int(s.Allocs - s.Frees)
+	}
+	c.lockQueue()
+	entries := c.queue.Len()
+	if c.stale != nil {
+		entries += c.stale.queue.Len()
+	}
+	c.unlockQueue()
+	for _, shard := range c.shards {
+		c.unlock(shard)
+	}
+	return outstanding - entries
+}
+
+// xorshift is the generator of the self check samples. The global source
+// of math/rand takes a lock, a rand.Rand per cache allocates
+type xorshift uint64
+
+// newXorshift mixes the seed, the seeds 1 and 2 give unrelated sequences
+// The state of xorshift is never zero
+func newXorshift(seed uint64) xorshift {
+	seed += 0x9e3779b97f4a7c15
+	seed = (seed ^ (seed >> 30)) * 0xbf58476d1ce4e5b9
+	seed = (seed ^ (seed >> 27)) * 0x94d049bb133111eb
+	seed ^= seed >> 31
+	if seed == 0 {
+		seed = 1
+	}
+	return xorshift(seed)
+}
+
+// next returns the next number of xorshift64*
+func (x *xorshift) next() uint32 {
+	s := uint64(*x)
+	s ^= s >> 12
+	s ^= s << 25
+	s ^= s >> 27
+	*x = xorshift(s)
+	return uint32((s * 2685821657736338717) >> 32)
+}
+
+// removeLost removes the table entry which has no queue entry
+// Called with the shard locked
+func (c *Cache) removeLost(shard *shard, key uint64, o Object, hashtableRef uint32) {
+	if shard.pool != nil && shard.pool.Belongs(shard.pool.GetBase()+uintptr(o)) {
+		c.poolFree(shard, o)
+	}
+	shard.table.RemoveByRef(hashtableRef)
+	if c.reverse != nil {
+		c.reverse.remove(o, key)
+	}
+	if c.wal != nil {
+		c.logDelete(key)
+	}
+}
+
+// StartSelfCheck runs SelfCheck() with the repair every interval until the
+// context is done or the cache is closed
+// 'report' is called from the goroutine after every check, can be nil
+func (c *Cache) StartSelfCheck(ctx context.Context, interval time.Duration, report func(SelfCheckReport)) {
+	if !c.startWorker() {
+		return
+	}
+	c.do("selfcheck", func() {
+		go c.selfCheckLoop(ctx, interval, report)
+	})
+}
+
+// selfCheckLoop is the goroutine of StartSelfCheck()
+func (c *Cache) selfCheckLoop(ctx context.Context, interval time.Duration, report func(SelfCheckReport)) {
+	defer c.goroutines.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			r := c.SelfCheck(selfCheckSample, true)
+			if report != nil {
+				report(r)
+			}
+		case <-ctx.Done():
+			return
+		case <-c.stop:
+			return
+		}
+	}
+}
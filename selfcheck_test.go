@@ -0,0 +1,116 @@
+package mcache
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+	"unsafe"
+
+	"github.com/larytet/mcachego/internal/fifo"
+)
+
+// addOrphan adds a queue entry which the table does not point to
+func addOrphan(cache *Cache, key uint64) {
+	cache.queueMutex.Lock()
+	cache.queue.Add(fifo.Entry{Key: key, ExpirationMs: 10})
+	cache.queueMutex.Unlock()
+}
+
+func TestSelfCheck(t *testing.T) {
+	cache := New(Configuration{Size: 8, TTL: 10, LoadFactor: 100})
+	cache.Store(1, 2, 0)
+	addOrphan(cache, 5)
+	cache.Store(2, 2, 0)
+	if r := cache.SelfCheck(10, false); r.Checked != 3 || r.Orphans != 1 || r.Lost != 0 {
+		t.Fatalf("Bad report %+v", r)
+	}
+	if cache.Len() != 3 {
+		t.Fatalf("Check without repair changed Len %d", cache.Len())
+	}
+	if r := cache.SelfCheck(10, true); r.Orphans != 1 {
+		t.Fatalf("Bad report %+v", r)
+	}
+	if r := cache.SelfCheck(10, true); !r.Ok() || r.Checked != 2 {
+		t.Fatalf("Bad report after repair %+v", r)
+	}
+	if o, _, ok := cache.Load(1); !ok || o != 2 {
+		t.Fatalf("Repair removed a live entry")
+	}
+
+	// The queue lost the entry of the key
+	cache.Store(3, 4, 0)
+	_, ref, _ := cache.Load(3)
+	cache.queueMutex.Lock()
+	cache.queue.Tombstone(ref.fifoSeq)
+	cache.queueMutex.Unlock()
+	addOrphan(cache, 3)
+	if r := cache.SelfCheck(10, true); r.Orphans != 1 || r.Lost != 1 {
+		t.Fatalf("Bad report %+v", r)
+	}
+	if _, _, ok := cache.Load(3); ok {
+		t.Fatalf("Lost entry is not removed")
+	}
+	if r := cache.SelfCheck(10, false); !r.Ok() {
+		t.Fatalf("Bad report after repair %+v", r)
+	}
+}
+
+func TestSelfCheckSample(t *testing.T) {
+	cache := New(Configuration{Size: 1000, TTL: 10, LoadFactor: 50})
+	for i := 0; i < 1000; i++ {
+		cache.Store(uint64(i), Object(i), 0)
+	}
+	addOrphan(cache, 2000)
+	orphans := 0
+	for i := 0; i < 100; i++ {
+		r := cache.SelfCheck(100, false)
+		if r.Checked != 100 || r.Lost != 0 {
+			t.Fatalf("Bad report %+v", r)
+		}
+		orphans += r.Orphans
+	}
+	// The head of the queue never reaches the orphan at the tail
+	if orphans == 0 {
+		t.Fatalf("The samples missed the orphan")
+	}
+}
+
+func TestSelfCheckPool(t *testing.T) {
+	cache := New(Configuration{Size: 8, TTL: 10, LoadFactor: 100, Shards: 1,
+		PoolTemplate: reflect.TypeOf(new(poolData)), StaleTTL: 100})
+	init := func(p unsafe.Pointer) {}
+	cache.StoreNew(1, 0, init)
+	cache.StoreNew(1, 0, init)
+	cache.StoreNew(2, 0, init)
+	// The stale entry keeps the object
+	cache.Evict(10, false)
+	if r := cache.SelfCheck(10, false); !r.Ok() {
+		t.Fatalf("Bad report %+v", r)
+	}
+	cache.shards[0].pool.Alloc()
+	if r := cache.SelfCheck(10, false); r.PoolLeaked != 1 {
+		t.Fatalf("Bad report %+v", r)
+	}
+}
+
+func TestStartSelfCheck(t *testing.T) {
+	cache := New(Configuration{Size: 8, TTL: 10, LoadFactor: 100})
+	cache.Store(1, 2, 0)
+	addOrphan(cache, 2)
+	reports := make(chan SelfCheckReport, 16)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cache.StartSelfCheck(ctx, time.Millisecond, func(r SelfCheckReport) {
+		select {
+		case reports <- r:
+		default:
+		}
+	})
+	if r := <-reports; r.Orphans != 1 {
+		t.Fatalf("Bad report %+v", r)
+	}
+	if r := <-reports; !r.Ok() {
+		t.Fatalf("Bad report after repair %+v", r)
+	}
+}
@@ -0,0 +1,54 @@
+package mcache
+
+// A small device runs one shard and often one goroutine. The shard of a
+// single shard cache does not need the mask and the slice. In the single
+// goroutine mode the Store*(), Load*(), EvictBy*() APIs, Prefetch(),
+// ExpirationOf() and Evict() do not lock the shard and the eviction queue.
+// The statistics, Flush(), the import and the self check do not lock either
+// Do not call StartSelfCheck() or MemoryMonitor.Run() in the single
+// goroutine mode: the goroutines call the cache concurrently
+
+// shardOf returns the shard of the hash and the index of the shard
+func (c *Cache) shardOf(hash uint64) (*shard, uint64) {
+	if c.single != nil {
+		return c.single, 0
+	}
+	shardIdx := c.shardIdx(hash)
+	return c.shards[shardIdx], shardIdx
+}
+
+func (c *Cache) lock(shard *shard) {
+	if !c.nolock {
+		shard.mutex.Lock()
+	}
+}
+
+func (c *Cache) unlock(shard *shard) {
+	if !c.nolock {
+		shard.mutex.Unlock()
+	}
+}
+
+func (c *Cache) rlock(shard *shard) {
+	if !c.nolock {
+		shard.mutex.RLock()
+	}
+}
+
+func (c *Cache) runlock(shard *shard) {
+	if !c.nolock {
+		shard.mutex.RUnlock()
+	}
+}
+
+func (c *Cache) lockQueue() {
+	if !c.nolock {
+		c.queueMutex.Lock()
+	}
+}
+
+func (c *Cache) unlockQueue() {
+	if !c.nolock {
+		c.queueMutex.Unlock()
+	}
+}
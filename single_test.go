@@ -0,0 +1,45 @@
+package mcache
+
+import (
+	"testing"
+)
+
+func TestSingleGoroutine(t *testing.T) {
+	cache := New(Configuration{Size: 4, TTL: 10, LoadFactor: 100, Shards: 8, SingleGoroutine: true})
+	if cache.Shards() != 1 || cache.single == nil || !cache.nolock {
+		t.Fatalf("Got %d shards", cache.Shards())
+	}
+	for i := 0; i < 4; i++ {
+		if !cache.Store(uint64(i), Object(i), 0) {
+			t.Fatalf("Failed to store %d", i)
+		}
+	}
+	if cache.Store(4, 4, 0) {
+		t.Fatalf("Stored to a full cache")
+	}
+	o, ref, ok := cache.Load(1)
+	if !ok || o != 1 || ref.shardIdx != 0 {
+		t.Fatalf("Got %d %v", o, ok)
+	}
+	cache.EvictByRef(ref)
+	for i := 0; i < 4; i++ {
+		if i == 1 {
+			continue
+		}
+		if o, ok := cache.Evict(10, false); !ok || o != Object(i) {
+			t.Fatalf("Evict returned %d instead of %d", o, i)
+		}
+	}
+	if cache.Len() != 0 {
+		t.Fatalf("Got Len %d instead of 0", cache.Len())
+	}
+}
+
+func BenchmarkSingleGoroutine(b *testing.B) {
+	cache := New(Configuration{Size: b.N + 1, TTL: 10, SingleGoroutine: true})
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		cache.Store(uint64(i), Object(i), 0)
+		cache.Load(uint64(i))
+	}
+}
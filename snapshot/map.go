@@ -0,0 +1,83 @@
+package snapshot
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// Mapped is a snapshot parsed in place, see Map()
+type Mapped struct {
+	Major  uint16
+	Minor  uint16
+	Config []byte
+	// The payloads point to the data of Map()
+	Sections []Section
+}
+
+// Map parses the snapshot in memory, for example, an mmap'ed file, and does
+// not copy the payloads
+// Map verifies the checksums of the sections in 'verify' only. The checksum
+// of gigabytes of payload is not free, the caller can check the records
+// of a section lazily
+func Map(data []byte, known []SectionID, verify []SectionID) (*Mapped, error) {
+	if len(data) < headerSize {
+		return nil, io.ErrUnexpectedEOF
+	}
+	if binary.LittleEndian.Uint32(data[0:]) != Magic {
+		return nil, ErrMagic
+	}
+	m := &Mapped{
+		Major: binary.LittleEndian.Uint16(data[4:]),
+		Minor: binary.LittleEndian.Uint16(data[6:]),
+	}
+	if m.Major != Major {
+		return nil, fmt.Errorf("%w: %d.%d, expected %d.x", ErrVersion, m.Major, m.Minor, Major)
+	}
+	contains := func(ids []SectionID, id SectionID) bool {
+		for _, i := range ids {
+			if i == id {
+				return true
+			}
+		}
+		return false
+	}
+	offset := headerSize
+	for first := true; ; first = false {
+		if len(data)-offset < sectionHeaderSize {
+			return nil, io.ErrUnexpectedEOF
+		}
+		h := data[offset : offset+sectionHeaderSize]
+		offset += sectionHeaderSize
+		length := int(binary.LittleEndian.Uint32(h[4:]))
+		if length > MaxSectionSize {
+			return nil, ErrSectionSize
+		}
+		if len(data)-offset < length {
+			return nil, io.ErrUnexpectedEOF
+		}
+		section := Section{
+			ID:      SectionID(binary.LittleEndian.Uint16(h[0:])),
+			Flags:   SectionFlags(binary.LittleEndian.Uint16(h[2:])),
+			Payload: data[offset : offset+length : offset+length],
+		}
+		offset += length
+		check := first || section.ID == SectionEnd || contains(verify, section.ID)
+		if check && checksum(h, section.Payload) != binary.LittleEndian.Uint32(h[8:]) {
+			return nil, ErrChecksum
+		}
+		switch {
+		case first:
+			if section.ID != SectionConfig {
+				return nil, ErrNoConfig
+			}
+			m.Config = section.Payload
+		case section.ID == SectionEnd:
+			return m, nil
+		case contains(known, section.ID):
+			m.Sections = append(m.Sections, section)
+		case section.Flags&FlagRequired != 0:
+			return nil, fmt.Errorf("%w: %d", ErrUnknownSection, section.ID)
+		}
+	}
+}
@@ -0,0 +1,257 @@
+// Package snapshot defines the binary container shared by all serialization
+// features: hashtable, cache and pool
+//
+// The layout is little endian
+//
+//	header:  magic uint32, major uint16, minor uint16
+//	section: id uint16, flags uint16, length uint32, crc32 uint32, payload
+//
+// The first section is the configuration block. The last section is the end
+// marker with zero length, it catches truncated files
+// CRC32 (Castagnoli) covers the section header fields and the payload
+//
+// Forward compatibility rules
+//   - A reader rejects a snapshot with a different major version
+//   - A reader accepts a snapshot with any minor version
+//   - Minor versions can only add sections
+//   - A reader skips unknown sections unless the section is marked
+//     FlagRequired. A writer marks a section required if ignoring it
+//     produces a wrong cache state
+//
+// 1.1 adds the optional SectionIndex, see Map()
+package snapshot
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+)
+
+// Magic is "MCSN"
+const Magic uint32 = 0x4e53434d
+
+// Version of the format written by this package
+const (
+	Major uint16 = 1
+	Minor uint16 = 1
+)
+
+// SectionID identifies the payload of the section
+type SectionID uint16
+
+const (
+	SectionConfig    SectionID = 1
+	SectionHashtable SectionID = 2
+	SectionCache     SectionID = 3
+	SectionPool      SectionID = 4
+	SectionIndex     SectionID = 5
+	SectionEnd       SectionID = 0xffff
+)
+
+// SectionFlags modify how a reader handles the section
+type SectionFlags uint16
+
+const (
+	// A reader which does not know the section must reject the snapshot
+	FlagRequired SectionFlags = 1 << 0
+)
+
+// MaxSectionSize protects the reader from huge allocations when the length
+// field is corrupted
+const MaxSectionSize = 1 << 30
+
+var (
+	ErrMagic          = errors.New("snapshot: bad magic")
+	ErrVersion        = errors.New("snapshot: unsupported version")
+	ErrChecksum       = errors.New("snapshot: checksum mismatch")
+	ErrUnknownSection = errors.New("snapshot: unknown required section")
+	ErrSectionSize    = errors.New("snapshot: section is too large")
+	ErrNoConfig       = errors.New("snapshot: configuration block is missing")
+)
+
+const (
+	headerSize        = 8
+	sectionHeaderSize = 12
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// Section is a single block of the snapshot
+type Section struct {
+	ID      SectionID
+	Flags   SectionFlags
+	Payload []byte
+}
+
+// Writer produces a snapshot
+type Writer struct {
+	w      io.Writer
+	header [sectionHeaderSize]byte
+	closed bool
+}
+
+// NewWriter writes the header and the configuration block
+func NewWriter(w io.Writer, config []byte) (*Writer, error) {
+	var header [headerSize]byte
+	binary.LittleEndian.PutUint32(header[0:], Magic)
+	binary.LittleEndian.PutUint16(header[4:], Major)
+	binary.LittleEndian.PutUint16(header[6:], Minor)
+	if _, err := w.Write(header[:]); err != nil {
+		return nil, err
+	}
+	sw := &Writer{w: w}
+	if err := sw.write(SectionConfig, FlagRequired, config); err != nil {
+		return nil, err
+	}
+	return sw, nil
+}
+
+func checksum(header []byte, payload []byte) uint32 {
+	crc := crc32.Update(0, crcTable, header[:8])
+	return crc32.Update(crc, crcTable, payload)
+}
+
+func (sw *Writer) write(id SectionID, flags SectionFlags, payload []byte) error {
+	if len(payload) > MaxSectionSize {
+		return ErrSectionSize
+	}
+	h := sw.header[:]
+	binary.LittleEndian.PutUint16(h[0:], uint16(id))
+	binary.LittleEndian.PutUint16(h[2:], uint16(flags))
+	binary.LittleEndian.PutUint32(h[4:], uint32(len(payload)))
+	binary.LittleEndian.PutUint32(h[8:], checksum(h, payload))
+	if _, err := sw.w.Write(h); err != nil {
+		return err
+	}
+	_, err := sw.w.Write(payload)
+	return err
+}
+
+// WriteSection appends a section. The configuration block and the end
+// marker are written by NewWriter() and Close()
+func (sw *Writer) WriteSection(id SectionID, flags SectionFlags, payload []byte) error {
+	if id == SectionConfig || id == SectionEnd {
+		return fmt.Errorf("snapshot: section %d is reserved", id)
+	}
+	if sw.closed {
+		return errors.New("snapshot: writer is closed")
+	}
+	return sw.write(id, flags, payload)
+}
+
+// Close writes the end marker. Close does not close the underlying writer
+func (sw *Writer) Close() error {
+	if sw.closed {
+		return nil
+	}
+	sw.closed = true
+	return sw.write(SectionEnd, 0, nil)
+}
+
+// Reader parses a snapshot
+type Reader struct {
+	r      io.Reader
+	known  map[SectionID]bool
+	major  uint16
+	minor  uint16
+	config []byte
+	done   bool
+}
+
+// NewReader reads the header and the configuration block. The reader returns
+// only the sections in the known list, skips unknown optional sections and
+// fails on unknown required sections
+func NewReader(r io.Reader, known ...SectionID) (*Reader, error) {
+	var header [headerSize]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	if binary.LittleEndian.Uint32(header[0:]) != Magic {
+		return nil, ErrMagic
+	}
+	sr := &Reader{
+		r:     r,
+		known: make(map[SectionID]bool, len(known)),
+		major: binary.LittleEndian.Uint16(header[4:]),
+		minor: binary.LittleEndian.Uint16(header[6:]),
+	}
+	if sr.major != Major {
+		return nil, fmt.Errorf("%w: %d.%d, expected %d.x", ErrVersion, sr.major, sr.minor, Major)
+	}
+	for _, id := range known {
+		sr.known[id] = true
+	}
+	section, err := sr.read()
+	if err != nil {
+		return nil, err
+	}
+	if section.ID != SectionConfig {
+		return nil, ErrNoConfig
+	}
+	sr.config = section.Payload
+	return sr, nil
+}
+
+// Version returns the version of the snapshot
+func (sr *Reader) Version() (major uint16, minor uint16) {
+	return sr.major, sr.minor
+}
+
+// Config returns the configuration block
+func (sr *Reader) Config() []byte {
+	return sr.config
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+func (sr *Reader) read() (Section, error) {
+	var h [sectionHeaderSize]byte
+	if _, err := io.ReadFull(sr.r, h[:]); err != nil {
+		return Section{}, unexpectedEOF(err)
+	}
+	length := binary.LittleEndian.Uint32(h[4:])
+	if length > MaxSectionSize {
+		return Section{}, ErrSectionSize
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(sr.r, payload); err != nil {
+		return Section{}, unexpectedEOF(err)
+	}
+	if checksum(h[:], payload) != binary.LittleEndian.Uint32(h[8:]) {
+		return Section{}, ErrChecksum
+	}
+	return Section{
+		ID:      SectionID(binary.LittleEndian.Uint16(h[0:])),
+		Flags:   SectionFlags(binary.LittleEndian.Uint16(h[2:])),
+		Payload: payload,
+	}, nil
+}
+
+// Next returns the next known section or io.EOF after the end marker
+// A truncated snapshot returns io.ErrUnexpectedEOF
+func (sr *Reader) Next() (Section, error) {
+	for !sr.done {
+		section, err := sr.read()
+		if err != nil {
+			return Section{}, err
+		}
+		if section.ID == SectionEnd {
+			sr.done = true
+			break
+		}
+		if sr.known[section.ID] {
+			return section, nil
+		}
+		if section.Flags&FlagRequired != 0 {
+			return Section{}, fmt.Errorf("%w: %d", ErrUnknownSection, section.ID)
+		}
+	}
+	return Section{}, io.EOF
+}
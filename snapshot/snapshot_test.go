@@ -0,0 +1,160 @@
+package snapshot
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"testing"
+)
+
+func write(t *testing.T, sections ...Section) []byte {
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, []byte("config"))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	for _, s := range sections {
+		if err := w.WriteSection(s.ID, s.Flags, s.Payload); err != nil {
+			t.Fatalf("%v", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("%v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestRoundTrip(t *testing.T) {
+	data := write(t,
+		Section{ID: SectionHashtable, Flags: FlagRequired, Payload: []byte("table")},
+		Section{ID: SectionCache, Payload: []byte{}},
+		Section{ID: SectionPool, Payload: []byte("pool")},
+	)
+	r, err := NewReader(bytes.NewReader(data), SectionHashtable, SectionCache, SectionPool)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if major, minor := r.Version(); major != Major || minor != Minor {
+		t.Fatalf("Bad version %d.%d", major, minor)
+	}
+	if string(r.Config()) != "config" {
+		t.Fatalf("Bad config %q", r.Config())
+	}
+	expected := []SectionID{SectionHashtable, SectionCache, SectionPool}
+	for _, id := range expected {
+		s, err := r.Next()
+		if err != nil || s.ID != id {
+			t.Fatalf("Got %v %v instead of section %d", s, err, id)
+		}
+	}
+	if s, err := r.Next(); err != io.EOF {
+		t.Fatalf("Got %v %v instead of EOF", s, err)
+	}
+}
+
+func TestChecksum(t *testing.T) {
+	data := write(t, Section{ID: SectionCache, Payload: []byte("cache")})
+	for i := headerSize; i < len(data); i++ {
+		corrupted := append([]byte(nil), data...)
+		corrupted[i] ^= 0x01
+		r, err := NewReader(bytes.NewReader(corrupted), SectionCache)
+		for err == nil {
+			_, err = r.Next()
+		}
+		if err == io.EOF {
+			t.Fatalf("Corruption at offset %d is not detected", i)
+		}
+	}
+}
+
+func TestMagic(t *testing.T) {
+	data := write(t)
+	data[0] = 0
+	if _, err := NewReader(bytes.NewReader(data)); err != ErrMagic {
+		t.Fatalf("Got %v instead of %v", err, ErrMagic)
+	}
+}
+
+func TestVersion(t *testing.T) {
+	data := write(t)
+	binary.LittleEndian.PutUint16(data[6:], Minor+1)
+	if _, err := NewReader(bytes.NewReader(data)); err != nil {
+		t.Fatalf("Newer minor version is rejected %v", err)
+	}
+	binary.LittleEndian.PutUint16(data[4:], Major+1)
+	if _, err := NewReader(bytes.NewReader(data)); !errors.Is(err, ErrVersion) {
+		t.Fatalf("Got %v instead of %v", err, ErrVersion)
+	}
+}
+
+func TestUnknownSection(t *testing.T) {
+	data := write(t,
+		Section{ID: 100, Payload: []byte("optional")},
+		Section{ID: SectionCache, Payload: []byte("cache")},
+	)
+	r, _ := NewReader(bytes.NewReader(data), SectionCache)
+	if s, err := r.Next(); err != nil || s.ID != SectionCache {
+		t.Fatalf("Optional section is not skipped %v %v", s, err)
+	}
+
+	data = write(t, Section{ID: 100, Flags: FlagRequired, Payload: []byte("required")})
+	r, _ = NewReader(bytes.NewReader(data), SectionCache)
+	if _, err := r.Next(); !errors.Is(err, ErrUnknownSection) {
+		t.Fatalf("Got %v instead of %v", err, ErrUnknownSection)
+	}
+}
+
+func TestTruncated(t *testing.T) {
+	data := write(t, Section{ID: SectionCache, Payload: []byte("cache")})
+	for i := 0; i < len(data); i++ {
+		r, err := NewReader(bytes.NewReader(data[:i]), SectionCache)
+		for err == nil {
+			_, err = r.Next()
+		}
+		if err != io.ErrUnexpectedEOF {
+			t.Fatalf("Got %v instead of %v for %d bytes", err, io.ErrUnexpectedEOF, i)
+		}
+	}
+}
+
+func TestReserved(t *testing.T) {
+	var buf bytes.Buffer
+	w, _ := NewWriter(&buf, nil)
+	if err := w.WriteSection(SectionEnd, 0, nil); err == nil {
+		t.Fatalf("Reserved section is accepted")
+	}
+}
+
+func TestMap(t *testing.T) {
+	data := write(t,
+		Section{ID: SectionCache, Payload: []byte("cache")},
+		Section{ID: 100, Payload: []byte("optional")},
+		Section{ID: SectionIndex, Payload: []byte("index")},
+	)
+	m, err := Map(data, []SectionID{SectionCache, SectionIndex}, []SectionID{SectionIndex})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if string(m.Config) != "config" || len(m.Sections) != 2 {
+		t.Fatalf("Bad config %q or sections %v", m.Config, m.Sections)
+	}
+	if m.Sections[0].ID != SectionCache || string(m.Sections[1].Payload) != "index" {
+		t.Fatalf("Bad sections %v", m.Sections)
+	}
+	// The payload of the cache section is not verified
+	idx := bytes.Index(data, []byte("cache"))
+	data[idx] ^= 0x01
+	if _, err := Map(data, []SectionID{SectionCache}, nil); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if _, err := Map(data, []SectionID{SectionCache}, []SectionID{SectionCache}); err != ErrChecksum {
+		t.Fatalf("Got %v instead of %v", err, ErrChecksum)
+	}
+	data[idx] ^= 0x01
+	for i := 0; i < len(data); i++ {
+		if _, err := Map(data[:i], []SectionID{SectionCache}, nil); err != io.ErrUnexpectedEOF {
+			t.Fatalf("Got %v instead of %v for %d bytes", err, io.ErrUnexpectedEOF, i)
+		}
+	}
+}
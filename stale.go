@@ -0,0 +1,139 @@
+package mcache
+
+import (
+	"unsafe"
+
+	"github.com/larytet-go/hashtable"
+	"github.com/larytet/mcachego/internal/fifo"
+)
+
+// Serve stale: a DNS resolver prefers an answer which expired an hour ago
+// to SERVFAIL when the upstream is down
+// With Configuration.StaleTTL Evict() does not return an expired entry. The
+// entry moves to the stale table and remains there StaleTTL ms. Load() does
+// not find the stale entries, LoadStale() does. Evict() returns the object
+// when the grace window ends, the application does not free the object
+// before that. Evict() moves at most one entry to the stale table and returns
+// false after the move
+// The stale entries have a separate FIFO and separate hashtables. StaleTTL
+// doubles the memory of the cache
+// The write-ahead log, Compact() and ImportFrom() ignore the stale entries
+
+// staleIndex keeps the expired entries
+type staleIndex struct {
+	// Protected by the queueMutex
+	queue *fifo.Fifo
+	// A table for every shard, protected by the shard locks
+	tables []*hashtable.Hashtable
+}
+
+func newStaleIndex(size int, shards int) *staleIndex {
+	s := &staleIndex{
+		tables: make([]*hashtable.Hashtable, shards),
+	}
+	for i := range s.tables {
+		s.tables[i] = hashtable.New(size/shards, 64)
+	}
+	s.reset(size)
+	return s
+}
+
+// reset is called by Reset()
+func (s *staleIndex) reset(size int) {
+	s.queue = fifo.New(size)
+	for _, table := range s.tables {
+		table.Reset()
+	}
+}
+
+// retain moves the expired entry to the stale table
+// Returns false if the stale FIFO or the table is full, Evict() returns the
+// entry to the application
+// A newer stale copy of the key replaces the older copy. retain() returns
+// the object of the older copy, 'displaced' is true. Evict() returns the
+// object to the application
+// Called with the shard and the queue locked
+func (c *Cache) retain(shardIdx uint64, hash uint64, e fifo.Entry, o Object) (old Object, displaced bool, ok bool) {
+	shard := c.shards[shardIdx]
+	table := c.stale.tables[shardIdx]
+	// The grace window starts at the expiration and not at the eviction
+	e.ExpirationMs = int32(TimeMs(e.ExpirationMs) + c.configuration.StaleTTL)
+	seq, ok := c.stale.queue.Add(e)
+	if !ok {
+		return 0, false, false
+	}
+	key := e.Key
+	iValue, found, _ := table.Load(key, hash)
+	i := item{o: o, fifoSeq: seq}
+	if !table.Store(key, hash, *((*uintptr)(unsafe.Pointer(&i)))) {
+		c.stale.queue.Tombstone(seq)
+		return 0, false, false
+	}
+	if found {
+		older := (*item)(unsafe.Pointer(&iValue))
+		c.stale.queue.Tombstone(older.fifoSeq)
+		old = older.o
+		if shard.pool != nil {
+			c.poolFree(shard, old)
+		}
+	}
+	return old, found, true
+}
+
+// evictStale removes the stale entry if the grace window ended
+func (c *Cache) evictStale(now TimeMs) (o Object, ok bool) {
+	c.lockQueue()
+	e, seq, ok := c.stale.queue.Peek()
+	c.unlockQueue()
+	if !ok || (TimeMs(e.ExpirationMs)-now) > 0 {
+		return 0, false
+	}
+	key := e.Key
+	hash := c.hash(key)
+	shard, shardIdx := c.shardOf(hash)
+	table := c.stale.tables[shardIdx]
+
+	c.lock(shard)
+	c.lockQueue()
+	_, headSeq, headOk := c.stale.queue.Peek()
+	ok = false
+	if headOk && headSeq == seq {
+		c.stale.queue.Remove()
+		iValue, found, ref := table.Load(key, hash)
+		i := (*item)(unsafe.Pointer(&iValue))
+		// The key can have a newer stale copy
+		if found && i.fifoSeq == seq {
+			table.RemoveByRef(ref)
+			o, ok = i.o, true
+			if shard.pool != nil {
+				c.poolFree(shard, o)
+			}
+		}
+	}
+	c.unlockQueue()
+	if ok && shard.statistics.sample(c.statisticsMask) {
+		shard.statistics.count(evictExpired, true)
+	}
+	c.unlock(shard)
+	return o, ok
+}
+
+// LoadStale returns the entry if the entry is in the cache or expired less
+// than StaleTTL ago. 'stale' is true for an expired entry
+// Call LoadStale() if the upstream fails
+func (c *Cache) LoadStale(key uint64) (o Object, stale bool, ok bool) {
+	if c.isClosed() {
+		return 0, false, false
+	}
+	hash := c.hash(key)
+	shard, shardIdx := c.shardOf(hash)
+	c.rlock(shard)
+	iValue, ok, _ := shard.table.Load(key, hash)
+	if !ok && c.stale != nil {
+		iValue, ok, _ = c.stale.tables[shardIdx].Load(key, hash)
+		stale = ok
+	}
+	c.runlock(shard)
+	i := *(*item)(unsafe.Pointer(&iValue))
+	return i.o, stale, ok
+}
@@ -0,0 +1,82 @@
+package mcache
+
+import (
+	"testing"
+)
+
+func TestLoadStale(t *testing.T) {
+	cache := New(Configuration{Size: 10, TTL: 100, LoadFactor: 100, StaleTTL: 1000})
+	now := TimeMs(1000)
+	cache.Store(1, 1, now)
+	cache.Store(2, 2, now+50)
+	if o, stale, ok := cache.LoadStale(1); !ok || stale || o != 1 {
+		t.Fatalf("LoadStale returned %v %v %v", o, stale, ok)
+	}
+	// Both entries expired, Evict() keeps them, one entry in a call
+	for i := 0; i < 2; i++ {
+		if o, ok := cache.Evict(now+200, false); ok {
+			t.Fatalf("Evict returned %v", o)
+		}
+	}
+	if cache.Len() != 0 {
+		t.Fatalf("Got Len %d instead of 0", cache.Len())
+	}
+	if _, _, ok := cache.Load(1); ok {
+		t.Fatalf("Load found a stale entry")
+	}
+	if o, stale, ok := cache.LoadStale(1); !ok || !stale || o != 1 {
+		t.Fatalf("LoadStale returned %v %v %v", o, stale, ok)
+	}
+	// A fresh copy of the key shadows the stale copy
+	cache.Store(2, 3, now+200)
+	if o, stale, ok := cache.LoadStale(2); !ok || stale || o != 3 {
+		t.Fatalf("LoadStale returned %v %v %v", o, stale, ok)
+	}
+	// The grace window of the first entry ends at now+1100
+	if o, ok := cache.Evict(now+1100, false); !ok || o != 1 {
+		t.Fatalf("Evict returned %v %v", o, ok)
+	}
+	if _, _, ok := cache.LoadStale(1); ok {
+		t.Fatalf("LoadStale found an evicted entry")
+	}
+	if o, ok := cache.Evict(now+1150, false); !ok || o != 2 {
+		t.Fatalf("Evict returned %v %v", o, ok)
+	}
+	if o, stale, ok := cache.LoadStale(2); !ok || stale || o != 3 {
+		t.Fatalf("LoadStale returned %v %v %v", o, stale, ok)
+	}
+	// Forced eviction does not retain the entries
+	if o, ok := cache.Evict(now+200, true); !ok || o != 3 {
+		t.Fatalf("Evict returned %v %v", o, ok)
+	}
+	if _, _, ok := cache.LoadStale(2); ok {
+		t.Fatalf("LoadStale found an evicted entry")
+	}
+}
+
+func TestLoadStaleDisplaced(t *testing.T) {
+	cache := New(Configuration{Size: 10, TTL: 100, LoadFactor: 100, StaleTTL: 1000})
+	cache.Store(1, 1, 0)
+	if _, ok := cache.Evict(100, false); ok {
+		t.Fatalf("Evict did not keep the entry")
+	}
+	cache.Store(1, 2, 100)
+	// The newer stale copy replaces the older copy, Evict() returns the
+	// older object
+	if o, ok := cache.Evict(200, false); !ok || o != 1 {
+		t.Fatalf("Evict returned %v %v", o, ok)
+	}
+	if o, stale, ok := cache.LoadStale(1); !ok || !stale || o != 2 {
+		t.Fatalf("LoadStale returned %v %v %v", o, stale, ok)
+	}
+	// The FIFO entry of the older copy is a tombstone
+	if n := cache.stale.queue.Len(); n != 1 {
+		t.Fatalf("Got %d stale entries instead of 1", n)
+	}
+	if o, ok := cache.Evict(1100, false); ok {
+		t.Fatalf("Evict returned %v", o)
+	}
+	if o, ok := cache.Evict(1200, false); !ok || o != 2 {
+		t.Fatalf("Evict returned %v %v", o, ok)
+	}
+}
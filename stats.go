@@ -0,0 +1,111 @@
+package mcache
+
+import (
+	"reflect"
+	"sync/atomic"
+)
+
+// Stats is the statistics of a component. An exporter walks the components
+// of a composed cache and does not need glue code for every type
+// The names of the counters are the names of the fields of the statistics
+// structures, for example, "EvictExpired" or "PoolAllocLockCongested"
+type Stats interface {
+	Snapshot() map[string]uint64
+	Reset()
+}
+
+var (
+	_ Stats = cacheStats{}
+	_ Stats = (*MemoryMonitor)(nil)
+)
+
+// addStats adds the uint64 fields of the structure to the map
+func addStats(m map[string]uint64, prefix string, s interface{}) {
+	v := reflect.ValueOf(s)
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		if f := v.Field(i); f.Kind() == reflect.Uint64 {
+			m[prefix+t.Field(i).Name] += f.Uint()
+		}
+	}
+}
+
+type cacheStats struct {
+	c *Cache
+}
+
+// Stats returns the statistics of the cache, of the pools and of the
+// optional subsystems
+// Cache.Reset() removes the entries, Stats().Reset() clears the counters
+// The counters of the pools are not cleared
+func (c *Cache) Stats() Stats {
+	return cacheStats{c: c}
+}
+
+func (s cacheStats) Snapshot() map[string]uint64 {
+	c := s.c
+	m := make(map[string]uint64)
+	addStats(m, "", c.GetStatistics())
+	m["Len"] = uint64(c.Len())
+	m["Size"] = uint64(c.Size())
+	if c.configuration.PoolTemplate != nil {
+		for _, shard := range c.shards {
+			c.rlock(shard)
+			addStats(m, "Pool", shard.pool.GetStatistics())
+			c.runlock(shard)
+		}
+	}
+	if c.adaptive != nil {
+		m["AdaptiveTTLExtended"] = atomic.LoadUint64(&c.adaptive.extended)
+		m["AdaptiveTTLShortened"] = atomic.LoadUint64(&c.adaptive.shortened)
+	}
+	return m
+}
+
+func (s cacheStats) Reset() {
+	s.c.resetStatistics()
+}
+
+// resetStatistics clears the counters under the locks
+func (c *Cache) resetStatistics() {
+	for _, shard := range c.shards {
+		c.lock(shard)
+		shard.statistics = statisticsCell{}
+		c.unlock(shard)
+	}
+	c.lockQueue()
+	c.queueStatistics = statisticsCell{}
+	c.storeQueueFull, c.storeTableFull = 0, 0
+	c.storeCoalesced = 0
+	c.unlockQueue()
+	if c.fingerprints != nil {
+		atomic.StoreUint64(&c.fingerprints.collisions, 0)
+	}
+	if c.adaptive != nil {
+		atomic.StoreUint64(&c.adaptive.extended, 0)
+		atomic.StoreUint64(&c.adaptive.shortened, 0)
+	}
+	if c.backend != nil {
+		atomic.StoreUint64(&c.backend.getErrors, 0)
+		atomic.StoreUint64(&c.backend.setErrors, 0)
+		atomic.StoreUint64(&c.backend.deleteErrors, 0)
+		atomic.StoreUint64(&c.backend.dropped, 0)
+		atomic.StoreUint64(&c.backend.fetches, 0)
+		atomic.StoreUint64(&c.backend.fetchesShared, 0)
+		atomic.StoreUint64(&c.backend.timeouts, 0)
+	}
+}
+
+// Snapshot returns the counters of the monitor, see Stats
+func (m *MemoryMonitor) Snapshot() map[string]uint64 {
+	res := make(map[string]uint64)
+	addStats(res, "Memory", m.GetStatistics())
+	return res
+}
+
+// Reset clears the counters
+func (m *MemoryMonitor) Reset() {
+	m.mutex.Lock()
+	m.statistics = MemoryStatistics{}
+	m.mutex.Unlock()
+}
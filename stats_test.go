@@ -0,0 +1,50 @@
+package mcache
+
+import (
+	"reflect"
+	"testing"
+	"unsafe"
+)
+
+func TestStats(t *testing.T) {
+	cache := New(Configuration{Size: 4, TTL: 10, LoadFactor: 100})
+	for i := 0; i < 5; i++ {
+		cache.Store(uint64(i), Object(i), 0)
+	}
+	cache.Evict(10, false)
+	stats := cache.Stats()
+	m := stats.Snapshot()
+	if m["StoreQueueFull"] != 1 || m["EvictExpired"] != 1 || m["Len"] != 3 || m["Size"] != 4 {
+		t.Fatalf("Bad snapshot %v", m)
+	}
+	stats.Reset()
+	m = stats.Snapshot()
+	if m["StoreQueueFull"] != 0 || m["EvictExpired"] != 0 || m["Len"] != 3 {
+		t.Fatalf("Bad snapshot after Reset %v", m)
+	}
+
+	type data struct{ a uint64 }
+	cache = New(Configuration{Size: 4, TTL: 10, PoolTemplate: reflect.TypeOf(new(data))})
+	cache.StoreNew(1, 0, func(unsafe.Pointer) {})
+	if _, ok := cache.Stats().Snapshot()["PoolAllocLockCongested"]; !ok {
+		t.Fatalf("No pool statistics")
+	}
+}
+
+func TestMemoryMonitorStats(t *testing.T) {
+	cache := New(Configuration{Size: 4, TTL: 10})
+	m, err := NewMemoryMonitor(cache, MemoryConfiguration{Limit: 100,
+		Usage: func() (uint64, error) { return 50, nil }})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	var stats Stats = m
+	m.Check(0)
+	if s := stats.Snapshot(); s["MemoryChecks"] != 1 || s["MemoryUsage"] != 50 {
+		t.Fatalf("Bad snapshot %v", s)
+	}
+	stats.Reset()
+	if s := stats.Snapshot(); s["MemoryChecks"] != 0 {
+		t.Fatalf("Bad snapshot after Reset %v", s)
+	}
+}
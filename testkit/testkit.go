@@ -0,0 +1,217 @@
+// Package testkit runs concurrent scenarios against a cache and checks
+// invariants. Use it to validate a cache configuration under -race
+//
+//	cache := mcache.New(mcache.Configuration{Size: 1024, TTL: 10})
+//	result := testkit.Run(cache, testkit.Scenario{Writers: 4, Readers: 4, Evictor: true})
+//	if err := result.Err(); err != nil {
+//		t.Fatal(err)
+//	}
+//
+// Writers store Checksum(key) as the object. Readers check that a loaded
+// object matches the key. A mismatch means that the cache returned someone
+// else's data
+package testkit
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/larytet/mcachego"
+	"github.com/larytet/mcachego/keyhash"
+)
+
+// Scenario describes the workload
+type Scenario struct {
+	// Goroutines calling Store()
+	Writers int
+	// Goroutines calling Load()
+	Readers int
+	// A goroutine calling Evict() and checking the invariants
+	Evictor bool
+	// Number of Reset() calls during the run. Reset() is not thread safe
+	// and stops all other goroutines
+	Resets int
+	// Operations per writer and per reader
+	Operations int
+	// Range of the keys
+	Keys int
+	Seed int64
+	// Checked by the evictor after every eviction and at the end of the run
+	Invariants []Invariant
+}
+
+// Invariant returns an error if the cache state is not valid
+type Invariant func(c *mcache.Cache) error
+
+// Result of the scenario
+type Result struct {
+	Stores        uint64
+	StoreFailures uint64
+	Loads         uint64
+	Hits          uint64
+	Evictions     uint64
+	Resets        uint64
+	// Violated invariants and corrupted values, first 16 errors
+	Errors []error
+}
+
+// Err returns the first error or nil
+func (r *Result) Err() error {
+	if len(r.Errors) == 0 {
+		return nil
+	}
+	return r.Errors[0]
+}
+
+// Checksum is the object writers store for the key
+func Checksum(key uint64) mcache.Object {
+	return mcache.Object(keyhash.Uint64(key))
+}
+
+// OccupancyBounds checks that the number of entries is within the capacity
+func OccupancyBounds(c *mcache.Cache) error {
+	if l, s := c.Len(), c.Size(); l < 0 || l > s {
+		return fmt.Errorf("occupancy %d is out of bounds [0, %d]", l, s)
+	}
+	return nil
+}
+
+type runner struct {
+	cache    *mcache.Cache
+	scenario Scenario
+	result   Result
+	// Workers hold the read lock, Reset() holds the write lock
+	resetLock sync.RWMutex
+	errors    sync.Mutex
+	done      int32
+}
+
+func (r *runner) fail(err error) {
+	r.errors.Lock()
+	if len(r.result.Errors) < 16 {
+		r.result.Errors = append(r.result.Errors, err)
+	}
+	r.errors.Unlock()
+}
+
+func (r *runner) check() {
+	r.resetLock.RLock()
+	defer r.resetLock.RUnlock()
+	for _, invariant := range r.scenario.Invariants {
+		if err := invariant(r.cache); err != nil {
+			r.fail(err)
+		}
+	}
+}
+
+func (r *runner) writer(seed int64) {
+	rnd := rand.New(rand.NewSource(seed))
+	for i := 0; i < r.scenario.Operations; i++ {
+		key := uint64(rnd.Intn(r.scenario.Keys))
+		r.resetLock.RLock()
+		ok := r.cache.Store(key, Checksum(key), mcache.GetTime())
+		r.resetLock.RUnlock()
+		atomic.AddUint64(&r.result.Stores, 1)
+		if !ok {
+			atomic.AddUint64(&r.result.StoreFailures, 1)
+		}
+	}
+}
+
+func (r *runner) reader(seed int64) {
+	rnd := rand.New(rand.NewSource(seed))
+	for i := 0; i < r.scenario.Operations; i++ {
+		key := uint64(rnd.Intn(r.scenario.Keys))
+		r.resetLock.RLock()
+		o, _, ok := r.cache.Load(key)
+		r.resetLock.RUnlock()
+		atomic.AddUint64(&r.result.Loads, 1)
+		if ok {
+			atomic.AddUint64(&r.result.Hits, 1)
+			if o != Checksum(key) {
+				r.fail(fmt.Errorf("key %d: loaded %d instead of %d", key, o, Checksum(key)))
+			}
+		}
+	}
+}
+
+func (r *runner) evictor() {
+	for atomic.LoadInt32(&r.done) == 0 {
+		r.resetLock.RLock()
+		// Force eviction if the cache is full
+		force := r.cache.Len() >= r.cache.Size()
+		_, expired := r.cache.Evict(mcache.GetTime(), force)
+		r.resetLock.RUnlock()
+		if expired {
+			atomic.AddUint64(&r.result.Evictions, 1)
+		}
+		r.check()
+	}
+}
+
+func (r *runner) resetter(seed int64) {
+	rnd := rand.New(rand.NewSource(seed))
+	for i := 0; i < r.scenario.Resets && atomic.LoadInt32(&r.done) == 0; i++ {
+		time.Sleep(time.Duration(rnd.Intn(1000)) * time.Microsecond)
+		r.resetLock.Lock()
+		r.cache.Reset()
+		r.resetLock.Unlock()
+		atomic.AddUint64(&r.result.Resets, 1)
+		r.check()
+	}
+}
+
+// Run executes the scenario and returns the result
+func Run(cache *mcache.Cache, scenario Scenario) Result {
+	if scenario.Operations == 0 {
+		scenario.Operations = 10 * 1000
+	}
+	if scenario.Keys == 0 {
+		scenario.Keys = 2 * cache.Size()
+	}
+	if scenario.Invariants == nil {
+		scenario.Invariants = []Invariant{OccupancyBounds}
+	}
+	r := &runner{cache: cache, scenario: scenario}
+
+	var workers sync.WaitGroup
+	for i := 0; i < scenario.Writers; i++ {
+		workers.Add(1)
+		go func(seed int64) {
+			defer workers.Done()
+			r.writer(seed)
+		}(scenario.Seed + int64(i))
+	}
+	for i := 0; i < scenario.Readers; i++ {
+		workers.Add(1)
+		go func(seed int64) {
+			defer workers.Done()
+			r.reader(seed)
+		}(scenario.Seed + int64(scenario.Writers+i))
+	}
+
+	var background sync.WaitGroup
+	if scenario.Evictor {
+		background.Add(1)
+		go func() {
+			defer background.Done()
+			r.evictor()
+		}()
+	}
+	if scenario.Resets > 0 {
+		background.Add(1)
+		go func() {
+			defer background.Done()
+			r.resetter(scenario.Seed - 1)
+		}()
+	}
+
+	workers.Wait()
+	atomic.StoreInt32(&r.done, 1)
+	background.Wait()
+	r.check()
+	return r.result
+}
@@ -0,0 +1,41 @@
+package testkit
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/larytet/mcachego"
+)
+
+func TestRun(t *testing.T) {
+	cache := mcache.New(mcache.Configuration{Size: 1024, TTL: 10})
+	result := Run(cache, Scenario{Writers: 4, Readers: 4, Evictor: true, Resets: 3, Seed: 1})
+	if err := result.Err(); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if result.Stores != 4*10*1000 || result.Loads != 4*10*1000 {
+		t.Fatalf("Bad number of operations %v", result)
+	}
+	if result.Hits == 0 {
+		t.Fatalf("No hits %v", result)
+	}
+}
+
+func TestRunHeap(t *testing.T) {
+	cache := mcache.New(mcache.Configuration{Size: 1024, TTL: 10, ExpiryIndex: mcache.ExpiryIndexHeap})
+	result := Run(cache, Scenario{Writers: 2, Readers: 2, Evictor: true, Seed: 2})
+	if err := result.Err(); err != nil {
+		t.Fatalf("%v", err)
+	}
+}
+
+func TestInvariantViolation(t *testing.T) {
+	cache := mcache.New(mcache.Configuration{Size: 16, TTL: 10})
+	violation := errors.New("violation")
+	result := Run(cache, Scenario{Writers: 1, Operations: 10, Invariants: []Invariant{
+		func(*mcache.Cache) error { return violation },
+	}})
+	if result.Err() != violation {
+		t.Fatalf("Got %v instead of %v", result.Err(), violation)
+	}
+}
@@ -0,0 +1,52 @@
+package mcache
+
+import (
+	"math"
+)
+
+// ttl returns the TTL of a new entry
+func (c *Cache) ttl(key uint64, o Object) TimeMs {
+	// New() applied the transform to the TTL
+	ttl := c.configuration.TTL
+	if c.configuration.TTLFunc != nil {
+		ttl = c.transformTTL(c.configuration.TTLFunc(key, o))
+	}
+	if c.adaptive != nil {
+		ttl = c.adaptive.apply(c.hash(key), ttl)
+	}
+	return ttl
+}
+
+func (c *Cache) transformTTL(ttl TimeMs) TimeMs {
+	if c.configuration.TTLTransform != nil {
+		return c.configuration.TTLTransform(ttl)
+	}
+	return ttl
+}
+
+// ClampTTL returns a TTLTransform which keeps the TTL between min and max
+// A DNS record with TTL 0 should live at least a second, a record with TTL
+// of a week should not occupy the cache for a week
+func ClampTTL(min, max TimeMs) func(ttl TimeMs) TimeMs {
+	return func(ttl TimeMs) TimeMs {
+		if ttl < min {
+			return min
+		}
+		if ttl > max {
+			return max
+		}
+		return ttl
+	}
+}
+
+// maxTTL keeps now+ttl in the int32 expiration time
+const maxTTL = TimeMs(math.MaxInt32 / 2)
+
+// TTLSeconds converts a TTL in seconds, for example, from a DNS record
+// The conversion saturates, a TTL of 68 years does not wrap around
+func TTLSeconds(seconds uint32) TimeMs {
+	if seconds > uint32(maxTTL/1000) {
+		return maxTTL
+	}
+	return TimeMs(seconds) * 1000
+}
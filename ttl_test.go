@@ -0,0 +1,32 @@
+package mcache
+
+import (
+	"testing"
+)
+
+func TestClampTTL(t *testing.T) {
+	cache := New(Configuration{Size: 10, TTL: 10, LoadFactor: 100, TTLTransform: ClampTTL(100, 1000)})
+	now := TimeMs(0)
+	cache.Store(1, 1, now)
+	cache.StoreWithTTL(2, 2, now, TTLSeconds(0), 0)
+	cache.StoreWithTTL(3, 3, now, TTLSeconds(500), 0)
+	cache.StoreWithTTL(4, 4, now, 500, 0)
+	// The configured TTL is clamped too
+	for i, expected := range []TimeMs{100, 100, 1000, 500} {
+		if o, ok := cache.Evict(now+expected-1, false); ok {
+			t.Fatalf("Entry %d: evicted %d before %d", i, o, expected)
+		}
+		if o, ok := cache.Evict(now+expected, false); !ok || o != Object(i+1) {
+			t.Fatalf("Entry %d: evict returned %d %v", i, o, ok)
+		}
+	}
+}
+
+func TestTTLSeconds(t *testing.T) {
+	if ttl := TTLSeconds(300); ttl != 300000 {
+		t.Fatalf("Got %d instead of 300000", ttl)
+	}
+	if ttl := TTLSeconds(1 << 31); ttl != maxTTL {
+		t.Fatalf("Got %d instead of %d", ttl, maxTTL)
+	}
+}
@@ -0,0 +1,72 @@
+package mcache
+
+import (
+	"sync/atomic"
+)
+
+// Watermark is an occupancy level of the cache
+// OnRise is called once when the occupancy reaches Percent of Size(), OnFall
+// is called once when the occupancy drops below FallPercent. An application
+// can shed load or call Evict(force=true) before Store() starts to fail
+// The callbacks are called after the cache releases the locks, from the
+// goroutine which crossed the level. The callbacks should be short, two
+// goroutines can call OnRise and OnFall of the same level concurrently
+type Watermark struct {
+	Percent int
+	// FallPercent is Percent by default. A lower FallPercent avoids
+	// a storm of callbacks when the occupancy oscillates around the level
+	FallPercent int
+	// OnRise and OnFall can be nil
+	OnRise func(count int)
+	OnFall func(count int)
+}
+
+// watermark is a Watermark with the levels in entries
+type watermark struct {
+	Watermark
+	rise int
+	fall int
+	// 1 if the occupancy is above the level
+	above int32
+}
+
+// newWatermarks converts percents to the number of entries
+func newWatermarks(watermarks []Watermark, size int) []watermark {
+	res := make([]watermark, len(watermarks))
+	for i, w := range watermarks {
+		if w.FallPercent == 0 || w.FallPercent > w.Percent {
+			w.FallPercent = w.Percent
+		}
+		res[i] = watermark{
+			Watermark: w,
+			rise:      (size * w.Percent) / 100,
+			fall:      (size * w.FallPercent) / 100,
+		}
+	}
+	return res
+}
+
+// checkWatermarks is called without locks after the occupancy changed
+// The compare-and-swap fires a single callback for a crossing
+func (c *Cache) checkWatermarks(count int) {
+	for i := range c.watermarks {
+		w := &c.watermarks[i]
+		above := atomic.LoadInt32(&w.above) == 1
+		if !above && count >= w.rise {
+			if atomic.CompareAndSwapInt32(&w.above, 0, 1) && w.OnRise != nil {
+				w.OnRise(count)
+			}
+		} else if above && count < w.fall {
+			if atomic.CompareAndSwapInt32(&w.above, 1, 0) && w.OnFall != nil {
+				w.OnFall(count)
+			}
+		}
+	}
+}
+
+// resetWatermarks is called by Reset(), the cache is empty
+func (c *Cache) resetWatermarks() {
+	for i := range c.watermarks {
+		atomic.StoreInt32(&c.watermarks[i].above, 0)
+	}
+}
@@ -0,0 +1,47 @@
+package mcache
+
+import (
+	"testing"
+)
+
+func TestWatermarks(t *testing.T) {
+	var rise, fall []int
+	cache := New(Configuration{Size: 10, TTL: 100, LoadFactor: 100, Shards: 1,
+		Watermarks: []Watermark{{
+			Percent:     80,
+			FallPercent: 50,
+			OnRise:      func(count int) { rise = append(rise, count) },
+			OnFall:      func(count int) { fall = append(fall, count) },
+		}},
+	})
+	now := TimeMs(1000)
+	for i := 0; i < 10; i++ {
+		cache.Store(uint64(i), Object(i), now)
+	}
+	if len(rise) != 1 || rise[0] != 8 {
+		t.Fatalf("OnRise calls %v", rise)
+	}
+	// Store fails and the occupancy is above the level
+	cache.Store(10, 10, now)
+	for i := 0; i < 5; i++ {
+		cache.Evict(now, true)
+	}
+	if len(fall) != 0 {
+		t.Fatalf("OnFall is called above FallPercent %v", fall)
+	}
+	cache.Evict(now, true)
+	if len(fall) != 1 || fall[0] != 4 {
+		t.Fatalf("OnFall calls %v", fall)
+	}
+	for i := 0; i < 4; i++ {
+		cache.Store(uint64(i), Object(i), now)
+	}
+	if len(rise) != 2 || len(fall) != 1 {
+		t.Fatalf("Second crossing: OnRise %v, OnFall %v", rise, fall)
+	}
+	cache.Reset()
+	cache.Store(0, 0, now)
+	if len(rise) != 2 || len(fall) != 1 {
+		t.Fatalf("Reset: OnRise %v, OnFall %v", rise, fall)
+	}
+}